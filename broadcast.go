@@ -0,0 +1,187 @@
+package webcam
+
+import (
+	"sync"
+	"time"
+)
+
+// Broadcaster shares frames with any number of subscribers, which can join
+// and leave at any time. Each subscriber has its own queue, so a slow one
+// never stalls the publisher or the other subscribers.
+type Broadcaster struct {
+	mu     sync.Mutex
+	subs   []*Subscription
+	closed bool
+}
+
+// SubscribeOptions configures a Subscription. The zero value
+// queues a single frame and drops new frames while it's full.
+type SubscribeOptions struct {
+	// Number of frames waiting for the subscriber, 1 if zero
+	Depth int
+
+	// What happens when the queue is full. As the broadcaster
+	// never waits, OverflowBlock, the default, drops the new frame.
+	Overflow OverflowPolicy
+
+	// Maximum number of frames per second delivered
+	// to the subscriber, zero delivers all frames
+	MaxRate float32
+}
+
+// Subscription receives frames from a Broadcaster
+type Subscription struct {
+	b        *Broadcaster
+	opts     SubscribeOptions
+	frames   chan *Frame
+	interval time.Duration
+	last     time.Time
+	dropped  uint64
+}
+
+// NewBroadcaster returns a broadcaster without subscribers
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{}
+}
+
+// Subscribe adds a subscriber. Frames received from the subscription
+// must be released with Frame.Release, and the subscription closed when
+// it's not needed anymore.
+func (b *Broadcaster) Subscribe(opts SubscribeOptions) *Subscription {
+	if opts.Depth <= 0 {
+		opts.Depth = 1
+	}
+
+	s := &Subscription{
+		b:      b,
+		opts:   opts,
+		frames: make(chan *Frame, opts.Depth),
+	}
+	if opts.MaxRate > 0 {
+		s.interval = time.Duration(float64(time.Second) / float64(opts.MaxRate))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(s.frames)
+		return s
+	}
+	b.subs = append(b.subs, s)
+	return s
+}
+
+// Publish sends the frame to all subscribers, retaining it for each of
+// them. It never waits for the subscribers. The reference of the caller
+// is left for the caller to release.
+func (b *Broadcaster) Publish(frame *Frame) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for _, s := range b.subs {
+		s.offer(frame, now)
+	}
+}
+
+// Subscribers returns the number of subscribers
+func (b *Broadcaster) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subs)
+}
+
+// Close closes all subscriptions. Frames published
+// afterwards are ignored.
+func (b *Broadcaster) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, s := range b.subs {
+		s.close()
+	}
+	b.subs = nil
+	b.closed = true
+}
+
+// Frames returns the channel the frames are delivered on.
+// It is closed when the subscription is closed.
+func (s *Subscription) Frames() <-chan *Frame {
+	return s.frames
+}
+
+// Dropped returns the number of frames dropped because
+// the queue of the subscriber was full
+func (s *Subscription) Dropped() uint64 {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+
+	return s.dropped
+}
+
+// Close unsubscribes and releases the frames still queued
+func (s *Subscription) Close() {
+	b := s.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, sub := range b.subs {
+		if sub == s {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			s.close()
+			return
+		}
+	}
+}
+
+func (s *Subscription) close() {
+	close(s.frames)
+	for frame := range s.frames {
+		frame.Release()
+	}
+}
+
+func (s *Subscription) offer(frame *Frame, now time.Time) {
+	// Frames arrive with some jitter, so they are
+	// accepted slightly before the interval passed
+	if s.interval > 0 && !s.last.IsZero() && now.Sub(s.last) < s.interval*9/10 {
+		return
+	}
+	s.last = now
+
+	frame.Retain()
+	for {
+		select {
+		case s.frames <- frame:
+			return
+		default:
+		}
+
+		switch s.opts.Overflow {
+		case OverflowBlock:
+			s.dropped++
+			frame.Release()
+			return
+		case OverflowKeepLatest:
+			s.discard(len(s.frames))
+		default:
+			s.discard(1)
+		}
+	}
+}
+
+// discard drops up to n queued frames. The subscriber
+// may have received some of them meanwhile.
+func (s *Subscription) discard(n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case frame := <-s.frames:
+			frame.Release()
+			s.dropped++
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package webcam
+
+import "testing"
+
+func TestBroadcaster(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 4)
+
+	b := NewBroadcaster()
+	slow := b.Subscribe(SubscribeOptions{Overflow: OverflowDropOldest})
+	fast := b.Subscribe(SubscribeOptions{Depth: 2})
+	if n := b.Subscribers(); n != 2 {
+		t.Fatalf("%d subscribers", n)
+	}
+
+	for _, data := range []string{"first", "second"} {
+		pushTestFrame(t, d, w, data)
+		frame, err := w.GetFrameWithMetadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Publish(frame)
+		frame.Release()
+	}
+
+	// The slow subscriber only got the latest frame
+	frame := <-slow.Frames()
+	if string(frame.Data) != "second" {
+		t.Errorf("slow subscriber got %q", frame.Data)
+	}
+	frame.Release()
+	if n := slow.Dropped(); n != 1 {
+		t.Errorf("slow subscriber dropped %d frames, want 1", n)
+	}
+
+	for _, data := range []string{"first", "second"} {
+		frame := <-fast.Frames()
+		if string(frame.Data) != data {
+			t.Errorf("fast subscriber got %q, want %q", frame.Data, data)
+		}
+		frame.Release()
+	}
+
+	// All buffers were returned to the driver
+	for i := 0; i < 4; i++ {
+		if err := d.PushFrame([]byte("captured")); err != nil {
+			t.Fatalf("buffer %d was not returned: %v", i, err)
+		}
+	}
+
+	slow.Close()
+	if _, ok := <-slow.Frames(); ok {
+		t.Error("closed subscription delivered a frame")
+	}
+	b.Close()
+	if _, ok := <-fast.Frames(); ok {
+		t.Error("subscription is open after the broadcaster was closed")
+	}
+}
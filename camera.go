@@ -0,0 +1,49 @@
+package webcam
+
+// FormatNegotiator is implemented by sources which allow selecting
+// image format, frame size and frame rate.
+type FormatNegotiator interface {
+	GetSupportedFormats() map[PixelFormat]string
+	GetSupportedFrameSizes(f PixelFormat) []FrameSize
+	GetSupportedFramerates(fp PixelFormat, width uint32, height uint32) []FrameRate
+	SetImageFormat(f PixelFormat, width, height uint32) (PixelFormat, uint32, uint32, error)
+	GetFramerate() (float32, error)
+	SetFramerate(fps float32) error
+}
+
+// Streamer is implemented by sources which capture frames
+// only between StartStreaming and StopStreaming calls.
+type Streamer interface {
+	SetBufferCount(count uint32) error
+	StartStreaming() error
+	StopStreaming() error
+}
+
+// FrameReader is implemented by sources which deliver frames.
+// See corresponding Webcam methods for the exact semantics.
+type FrameReader interface {
+	WaitForFrame(timeout uint32) error
+	ReadFrame() ([]byte, error)
+	GetFrame() ([]byte, uint32, error)
+	GetFrameWithMetadata() (*Frame, error)
+	ReleaseFrame(index uint32) error
+}
+
+// Controller is implemented by sources which have adjustable controls.
+type Controller interface {
+	GetControls() map[ControlID]Control
+	GetControl(id ControlID) (int32, error)
+	SetControl(id ControlID, value int32) error
+}
+
+// Camera is a video source that can be used interchangeably with Webcam,
+// e.g. a file, a network stream or a fake used in tests.
+type Camera interface {
+	FormatNegotiator
+	Streamer
+	FrameReader
+	Controller
+	Close() error
+}
+
+var _ Camera = (*Webcam)(nil)
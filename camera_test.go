@@ -0,0 +1,43 @@
+package webcam
+
+import "testing"
+
+// captureGrey captures a frame in the GREY format
+// knowing the source only as a Camera
+func captureGrey(cam Camera, width, height uint32) ([]byte, error) {
+	if _, _, _, err := cam.SetImageFormat(V4L2_PIX_FMT_GREY, width, height); err != nil {
+		return nil, err
+	}
+	if err := cam.SetBufferCount(2); err != nil {
+		return nil, err
+	}
+	if err := cam.StartStreaming(); err != nil {
+		return nil, err
+	}
+	defer cam.StopStreaming()
+
+	if err := cam.WaitForFrame(1); err != nil {
+		return nil, err
+	}
+	return cam.ReadFrame()
+}
+
+func TestCamera(t *testing.T) {
+	w, err := OpenTestPattern(TestPatternConfig{Framerate: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cam Camera = w
+	defer cam.Close()
+
+	if _, ok := cam.GetSupportedFormats()[V4L2_PIX_FMT_GREY]; !ok {
+		t.Fatal("GREY format is not supported")
+	}
+	frame, err := captureGrey(cam, 320, 240)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frame) != 320*240 {
+		t.Errorf("frame of %d bytes", len(frame))
+	}
+}
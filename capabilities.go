@@ -0,0 +1,186 @@
+package webcam
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CapabilityFlags is a set of V4L2_CAP_* flags
+type CapabilityFlags uint32
+
+// flagName is the name of a flag in a set of V4L2 flags
+type flagName struct {
+	flag uint32
+	name string
+}
+
+// flagNames returns names of the flags which are set.
+// Unknown flags are formatted in hex.
+func flagNames(flags uint32, table []flagName) []string {
+	var names []string
+	for _, f := range table {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+			flags &^= f.flag
+		}
+	}
+	if flags != 0 {
+		names = append(names, fmt.Sprintf("0x%08x", flags))
+	}
+	return names
+}
+
+var capabilityNames = []flagName{
+	{V4L2_CAP_VIDEO_CAPTURE, "video-capture"},
+	{V4L2_CAP_VIDEO_OUTPUT, "video-output"},
+	{V4L2_CAP_VIDEO_OVERLAY, "video-overlay"},
+	{V4L2_CAP_VBI_CAPTURE, "vbi-capture"},
+	{V4L2_CAP_VBI_OUTPUT, "vbi-output"},
+	{V4L2_CAP_SLICED_VBI_CAPTURE, "sliced-vbi-capture"},
+	{V4L2_CAP_SLICED_VBI_OUTPUT, "sliced-vbi-output"},
+	{V4L2_CAP_RDS_CAPTURE, "rds-capture"},
+	{V4L2_CAP_VIDEO_OUTPUT_OVERLAY, "video-output-overlay"},
+	{V4L2_CAP_HW_FREQ_SEEK, "hw-freq-seek"},
+	{V4L2_CAP_RDS_OUTPUT, "rds-output"},
+	{V4L2_CAP_VIDEO_CAPTURE_MPLANE, "video-capture-mplane"},
+	{V4L2_CAP_VIDEO_OUTPUT_MPLANE, "video-output-mplane"},
+	{V4L2_CAP_VIDEO_M2M_MPLANE, "video-m2m-mplane"},
+	{V4L2_CAP_VIDEO_M2M, "video-m2m"},
+	{V4L2_CAP_TUNER, "tuner"},
+	{V4L2_CAP_AUDIO, "audio"},
+	{V4L2_CAP_RADIO, "radio"},
+	{V4L2_CAP_MODULATOR, "modulator"},
+	{V4L2_CAP_SDR_CAPTURE, "sdr-capture"},
+	{V4L2_CAP_EXT_PIX_FORMAT, "ext-pix-format"},
+	{V4L2_CAP_SDR_OUTPUT, "sdr-output"},
+	{V4L2_CAP_META_CAPTURE, "meta-capture"},
+	{V4L2_CAP_READWRITE, "readwrite"},
+	{V4L2_CAP_STREAMING, "streaming"},
+	{V4L2_CAP_META_OUTPUT, "meta-output"},
+	{V4L2_CAP_TOUCH, "touch"},
+	{V4L2_CAP_IO_MC, "io-mc"},
+	{V4L2_CAP_DEVICE_CAPS, "device-caps"},
+}
+
+// Has reports whether all of the given V4L2_CAP_* flags are set
+func (f CapabilityFlags) Has(flags uint32) bool {
+	return uint32(f)&flags == flags
+}
+
+// VideoCapture reports whether video can be captured with either
+// the single or the multi-planar API
+func (f CapabilityFlags) VideoCapture() bool {
+	return f.Has(V4L2_CAP_VIDEO_CAPTURE) || f.Has(V4L2_CAP_VIDEO_CAPTURE_MPLANE)
+}
+
+// VideoOutput reports whether video can be output with either
+// the single or the multi-planar API
+func (f CapabilityFlags) VideoOutput() bool {
+	return f.Has(V4L2_CAP_VIDEO_OUTPUT) || f.Has(V4L2_CAP_VIDEO_OUTPUT_MPLANE)
+}
+
+// MemoryToMemory reports whether the device is a memory-to-memory
+// device, e.g. a codec or a scaler
+func (f CapabilityFlags) MemoryToMemory() bool {
+	return f.Has(V4L2_CAP_VIDEO_M2M) || f.Has(V4L2_CAP_VIDEO_M2M_MPLANE)
+}
+
+// MultiPlanar reports whether the multi-planar API is supported
+func (f CapabilityFlags) MultiPlanar() bool {
+	return uint32(f)&(V4L2_CAP_VIDEO_CAPTURE_MPLANE|V4L2_CAP_VIDEO_OUTPUT_MPLANE|V4L2_CAP_VIDEO_M2M_MPLANE) != 0
+}
+
+// Metadata reports whether metadata can be captured or output
+func (f CapabilityFlags) Metadata() bool {
+	return f.Has(V4L2_CAP_META_CAPTURE) || f.Has(V4L2_CAP_META_OUTPUT)
+}
+
+// SDR reports whether the device is a software defined radio
+func (f CapabilityFlags) SDR() bool {
+	return f.Has(V4L2_CAP_SDR_CAPTURE) || f.Has(V4L2_CAP_SDR_OUTPUT)
+}
+
+// Touch reports whether the device is a touch sensor
+func (f CapabilityFlags) Touch() bool {
+	return f.Has(V4L2_CAP_TOUCH)
+}
+
+// ReadWrite reports whether the read()/write() I/O method is supported
+func (f CapabilityFlags) ReadWrite() bool {
+	return f.Has(V4L2_CAP_READWRITE)
+}
+
+// Streaming reports whether the streaming I/O methods are supported
+func (f CapabilityFlags) Streaming() bool {
+	return f.Has(V4L2_CAP_STREAMING)
+}
+
+// Names returns names of the flags which are set, e.g. "video-capture"
+// for V4L2_CAP_VIDEO_CAPTURE. Unknown flags are formatted in hex.
+func (f CapabilityFlags) Names() []string {
+	return flagNames(uint32(f), capabilityNames)
+}
+
+func (f CapabilityFlags) String() string {
+	return strings.Join(f.Names(), "|")
+}
+
+// DeviceCapabilities is the report of VIDIOC_QUERYCAP
+type DeviceCapabilities struct {
+	// Name of the kernel driver, e.g. "uvcvideo"
+	Driver string
+
+	// Human-readable name of the device
+	Card string
+
+	// Location of the device in the system, e.g. "usb-0000:00:14.0-1"
+	BusInfo string
+
+	// Version of the driver, usually the kernel version,
+	// encoded as (major << 16) | (minor << 8) | patch
+	Version uint32
+
+	// Capabilities of the physical device as a whole, which
+	// can be accessed through several device nodes
+	Capabilities CapabilityFlags
+
+	// Capabilities of the opened device node. It equals
+	// Capabilities if the driver doesn't tell them apart.
+	DeviceCaps CapabilityFlags
+}
+
+// KernelVersion returns Version formatted as "major.minor.patch"
+func (c DeviceCapabilities) KernelVersion() string {
+	return fmt.Sprintf("%d.%d.%d", c.Version>>16, (c.Version>>8)&0xff, c.Version&0xff)
+}
+
+// Capabilities returns the driver, the device name and location,
+// and what the physical device and the opened node support.
+func (w *Webcam) Capabilities() (DeviceCapabilities, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return DeviceCapabilities{}, ErrClosed
+	}
+
+	caps, err := queryCapabilities(w.dev)
+
+	if err != nil {
+		return DeviceCapabilities{}, err
+	}
+
+	c := DeviceCapabilities{
+		Driver:       CToGoString(caps.driver[:]),
+		Card:         CToGoString(caps.card[:]),
+		BusInfo:      CToGoString(caps.bus_info[:]),
+		Version:      caps.version,
+		Capabilities: CapabilityFlags(caps.capabilities),
+		DeviceCaps:   CapabilityFlags(caps.capabilities),
+	}
+	if caps.capabilities&V4L2_CAP_DEVICE_CAPS != 0 {
+		c.DeviceCaps = CapabilityFlags(caps.device_caps)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,39 @@
+package webcam
+
+import "testing"
+
+func TestCapabilities(t *testing.T) {
+	d := newTestDriver()
+	d.Version = 6<<16 | 1<<8 | 12
+	w := openTestDriver(t, d)
+
+	caps, err := w.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.Driver != "fake" || caps.Card != "Fake Camera" || caps.BusInfo != "platform:fake" {
+		t.Errorf("capabilities = %+v", caps)
+	}
+	if v := caps.KernelVersion(); v != "6.1.12" {
+		t.Errorf("KernelVersion = %q", v)
+	}
+
+	// Capabilities of the device include V4L2_CAP_DEVICE_CAPS,
+	// those of the node don't
+	if !caps.Capabilities.Has(V4L2_CAP_DEVICE_CAPS) || caps.DeviceCaps.Has(V4L2_CAP_DEVICE_CAPS) {
+		t.Errorf("capabilities %v, of the node %v", caps.Capabilities, caps.DeviceCaps)
+	}
+	if !caps.DeviceCaps.VideoCapture() || !caps.DeviceCaps.Streaming() || caps.DeviceCaps.ReadWrite() {
+		t.Errorf("capabilities of the node %v", caps.DeviceCaps)
+	}
+}
+
+func TestCapabilityFlagsNames(t *testing.T) {
+	flags := CapabilityFlags(V4L2_CAP_VIDEO_CAPTURE_MPLANE | V4L2_CAP_STREAMING | 0x00000008)
+	if s := flags.String(); s != "video-capture-mplane|streaming|0x00000008" {
+		t.Errorf("String = %q", s)
+	}
+	if !flags.MultiPlanar() || !flags.VideoCapture() || flags.VideoOutput() {
+		t.Errorf("flags %v decoded wrong", flags)
+	}
+}
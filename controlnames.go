@@ -0,0 +1,197 @@
+package webcam
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ControlName normalizes the name of a control the same way v4l2-ctl
+// does: letters are lowercased and every run of other characters than
+// letters and digits becomes an underscore, except at the ends. E.g.
+// "Exposure Time, Absolute" becomes "exposure_time_absolute".
+func ControlName(name string) string {
+	var b strings.Builder
+	underscore := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'A' <= c && c <= 'Z':
+			c += 'a' - 'A'
+		case 'a' <= c && c <= 'z', '0' <= c && c <= '9':
+		default:
+			underscore = b.Len() > 0
+			continue
+		}
+		if underscore {
+			b.WriteByte('_')
+			underscore = false
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// FindControl looks up a control by its name. Names are compared after
+// normalizing them with ControlName, so both "Exposure Time, Absolute" and
+// "exposure_time_absolute" find the same control.
+func (w *Webcam) FindControl(name string) (ControlID, Control, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, Control{}, ErrClosed
+	}
+
+	return findControl(w.controls(), name)
+}
+
+// ParseControls parses settings of the form "name=value", as used by
+// v4l2-ctl, into controls which can be set with SetExtControls or
+// ApplyExtControls. Names are looked up like with FindControl. Values are
+// integers, which may be hex with a 0x prefix, true or false for booleans,
+// names or indexes of menu items, values of integer menu items and text
+// of string controls. Values are checked against the range of the control.
+func (w *Webcam) ParseControls(settings ...string) ([]ExtControl, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil, ErrClosed
+	}
+
+	cmap := w.controls()
+	controls := make([]ExtControl, 0, len(settings))
+	for _, setting := range settings {
+		name, value, ok := strings.Cut(setting, "=")
+		if !ok {
+			return nil, fmt.Errorf("Invalid control setting %q, expected name=value", setting)
+		}
+
+		id, c, err := findControl(cmap, name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		control, err := w.parseControl(id, c, value)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value of %s: %w", ControlName(c.Name), err)
+		}
+		controls = append(controls, control)
+	}
+	return controls, nil
+}
+
+// findControl looks up a control by its normalized name. If several
+// controls have the same name, the one with the lowest ID is found.
+func findControl(cmap map[ControlID]Control, name string) (ControlID, Control, error) {
+	ids := make([]ControlID, 0, len(cmap))
+	for id := range cmap {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	key := ControlName(name)
+	for _, id := range ids {
+		if ControlName(cmap[id].Name) == key {
+			return id, cmap[id], nil
+		}
+	}
+	return 0, Control{}, &stateError{fmt.Sprintf("No control named %q", name), ErrUnsupported}
+}
+
+// parseControl parses the value of a control according to its type
+func (w *Webcam) parseControl(id ControlID, c Control, value string) (ExtControl, error) {
+	control := ExtControl{ID: id}
+	min, max, step := int64(c.Min), int64(c.Max), int64(c.Step)
+
+	switch c.Type {
+	case ControlTypeString:
+		// The range is the one of the length
+		if len(value) < int(c.Min) || len(value) > int(c.Max) {
+			return control, checkRange(id, c.Name, int64(len(value)), min, max, 1)
+		}
+		control.String = value
+		return control, nil
+
+	case ControlTypeMenu, ControlTypeIntegerMenu:
+		item, ok := findMenuItem(c.Menu, value, c.Type == ControlTypeIntegerMenu)
+		if !ok {
+			return control, fmt.Errorf("No menu item %q", value)
+		}
+		control.Value = int64(item.Index)
+		return control, nil
+
+	case ControlTypeBoolean:
+		if b, err := strconv.ParseBool(value); err == nil {
+			if b {
+				control.Value = 1
+			}
+			return control, nil
+		}
+
+	case ControlTypeButton:
+		// Any value presses the button
+		return control, nil
+
+	case ControlTypeInteger64:
+		// The range reported by VIDIOC_QUERYCTRL is clamped to 32 bits
+		query, err := queryExtControl(w.dev, uint32(id))
+		if err != nil {
+			return control, err
+		}
+		min, max, step = query.minimum, query.maximum, int64(query.step)
+	}
+
+	n, err := strconv.ParseInt(value, 0, 64)
+
+	if err != nil {
+		return control, err
+	}
+
+	if c.Type == ControlTypeBitmask {
+		if uint32(n)&^uint32(c.Max) != 0 || n < 0 || n > 0xffffffff {
+			return control, fmt.Errorf("Bits 0x%x are not in mask 0x%08x", n, uint32(c.Max))
+		}
+		// Bitmasks are 32-bit values
+		n = int64(int32(uint32(n)))
+	} else if err := checkRange(id, c.Name, n, min, max, step); err != nil {
+		return control, err
+	}
+
+	control.Value = n
+	return control, nil
+}
+
+// findMenuItem finds a menu item by its name or by its index. Names of
+// menu items are normalized like names of controls, items of integer
+// menus are named by their value.
+func findMenuItem(items []MenuItem, value string, integer bool) (MenuItem, bool) {
+	for _, item := range items {
+		if item.Name == value {
+			return item, true
+		}
+	}
+
+	if key := ControlName(value); !integer && key != "" {
+		for _, item := range items {
+			if ControlName(item.Name) == key {
+				return item, true
+			}
+		}
+	}
+
+	index, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return MenuItem{}, false
+	}
+	for _, item := range items {
+		if item.Index == uint32(index) {
+			return item, true
+		}
+	}
+	return MenuItem{}, false
+}
@@ -0,0 +1,96 @@
+package webcam
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestControlName(t *testing.T) {
+	for name, want := range map[string]string{
+		"Exposure Time, Absolute": "exposure_time_absolute",
+		"White Balance (Auto)":    "white_balance_auto",
+		"  Gain  ":                "gain",
+		"exposure_time_absolute":  "exposure_time_absolute",
+	} {
+		if got := ControlName(name); got != want {
+			t.Errorf("ControlName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// newNamedDriver returns a driver with controls
+// of the types ParseControls handles
+func newNamedDriver() *FakeDriver {
+	d := newExtDriver()
+	d.AddControl(FakeControl{ID: ControlID(V4L2_CID_POWER_LINE_FREQUENCY), Name: "Power Line Frequency", Type: V4L2_CTRL_TYPE_MENU, Max: 2, Default: 1,
+		Menu: []string{"Disabled", "50 Hz", "60 Hz"}})
+	d.AddControl(FakeControl{ID: ControlID(V4L2_CID_HFLIP), Name: "Horizontal Flip", Type: V4L2_CTRL_TYPE_BOOLEAN, Max: 1, Step: 1})
+	return d
+}
+
+func TestFindControl(t *testing.T) {
+	d := newNamedDriver()
+	w := openTestDriver(t, d)
+
+	for _, name := range []string{"Power Line Frequency", "power_line_frequency"} {
+		if id, c, err := w.FindControl(name); err != nil || id != ControlID(V4L2_CID_POWER_LINE_FREQUENCY) || c.Name != "Power Line Frequency" {
+			t.Errorf("FindControl(%q) = %v, %v", name, id, err)
+		}
+	}
+	if _, _, err := w.FindControl("zoom"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("FindControl of a missing control = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestParseControls(t *testing.T) {
+	d := newNamedDriver()
+	w := openTestDriver(t, d)
+
+	controls, err := w.ParseControls("brightness=0x10", "power_line_frequency=50 Hz", "horizontal_flip=true", "label=front door")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ExtControl{
+		{ID: testBrightness, Value: 16},
+		{ID: ControlID(V4L2_CID_POWER_LINE_FREQUENCY), Value: 1},
+		{ID: ControlID(V4L2_CID_HFLIP), Value: 1},
+		{ID: testLabel, String: "front door"},
+	}
+	for i := range want {
+		if controls[i].ID != want[i].ID || controls[i].Value != want[i].Value || controls[i].String != want[i].String {
+			t.Errorf("control %d = %+v, want %+v", i, controls[i], want[i])
+		}
+	}
+
+	// Menu items are found by normalized names and by indexes
+	for _, setting := range []string{"power_line_frequency=60_hz", "power_line_frequency=2"} {
+		if controls, err := w.ParseControls(setting); err != nil || controls[0].Value != 2 {
+			t.Errorf("ParseControls(%q) = %+v, %v", setting, controls, err)
+		}
+	}
+
+	if err := w.SetExtControls(controls); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := d.ControlValue(testBrightness); value != 16 {
+		t.Errorf("brightness set to %d, want 16", value)
+	}
+}
+
+func TestParseControlsErrors(t *testing.T) {
+	d := newNamedDriver()
+	w := openTestDriver(t, d)
+
+	var rangeErr *ControlRangeError
+	if _, err := w.ParseControls("brightness=100"); !errors.As(err, &rangeErr) || rangeErr.Value != 100 {
+		t.Errorf("ParseControls out of range = %v, want *ControlRangeError", err)
+	}
+	if _, err := w.ParseControls("zoom=1"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("ParseControls of a missing control = %v, want ErrUnsupported", err)
+	}
+	for _, setting := range []string{"brightness", "brightness=bright", "power_line_frequency=100 Hz", "horizontal_flip=maybe"} {
+		if _, err := w.ParseControls(setting); err == nil {
+			t.Errorf("ParseControls(%q) succeeded", setting)
+		}
+	}
+}
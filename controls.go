@@ -0,0 +1,117 @@
+package webcam
+
+import "strings"
+
+// Values of Control.Type
+const (
+	ControlTypeInteger     = int32(c_int)
+	ControlTypeBoolean     = int32(c_bool)
+	ControlTypeMenu        = int32(c_menu)
+	ControlTypeButton      = int32(c_button)
+	ControlTypeInteger64   = int32(c_int64)
+	ControlTypeString      = int32(c_string)
+	ControlTypeBitmask     = int32(c_bitmask)
+	ControlTypeIntegerMenu = int32(c_int_menu)
+)
+
+// MenuItem is an item of a menu or an integer menu control
+type MenuItem struct {
+	// Value the control is set to for selecting the item
+	Index uint32
+
+	// Name of the item. Items of integer menus
+	// are named after their value.
+	Name string
+
+	// Value of an integer menu item, Index for other menus
+	Value int64
+}
+
+// ControlFlags is a set of V4L2_CTRL_FLAG_* flags
+type ControlFlags uint32
+
+var controlFlagNames = []flagName{
+	{V4L2_CTRL_FLAG_DISABLED, "disabled"},
+	{V4L2_CTRL_FLAG_GRABBED, "grabbed"},
+	{V4L2_CTRL_FLAG_READ_ONLY, "read-only"},
+	{V4L2_CTRL_FLAG_UPDATE, "update"},
+	{V4L2_CTRL_FLAG_INACTIVE, "inactive"},
+	{V4L2_CTRL_FLAG_SLIDER, "slider"},
+	{V4L2_CTRL_FLAG_WRITE_ONLY, "write-only"},
+	{V4L2_CTRL_FLAG_VOLATILE, "volatile"},
+	{V4L2_CTRL_FLAG_HAS_PAYLOAD, "has-payload"},
+	{V4L2_CTRL_FLAG_EXECUTE_ON_WRITE, "execute-on-write"},
+	{V4L2_CTRL_FLAG_MODIFY_LAYOUT, "modify-layout"},
+	{V4L2_CTRL_FLAG_DYNAMIC_ARRAY, "dynamic-array"},
+}
+
+// Has reports whether all of the given V4L2_CTRL_FLAG_* flags are set
+func (f ControlFlags) Has(flags uint32) bool {
+	return uint32(f)&flags == flags
+}
+
+// ReadOnly reports whether the control can't be set
+func (f ControlFlags) ReadOnly() bool {
+	return f.Has(V4L2_CTRL_FLAG_READ_ONLY)
+}
+
+// WriteOnly reports whether the control can't be read,
+// e.g. it triggers an action
+func (f ControlFlags) WriteOnly() bool {
+	return f.Has(V4L2_CTRL_FLAG_WRITE_ONLY)
+}
+
+// Inactive reports whether the control has no effect at the moment,
+// e.g. manual exposure while automatic exposure is on
+func (f ControlFlags) Inactive() bool {
+	return f.Has(V4L2_CTRL_FLAG_INACTIVE)
+}
+
+// Volatile reports whether the value is changed by the device itself,
+// e.g. gain set by automatic exposure
+func (f ControlFlags) Volatile() bool {
+	return f.Has(V4L2_CTRL_FLAG_VOLATILE)
+}
+
+// Grabbed reports whether the control can't be changed temporarily,
+// e.g. because the device is streaming
+func (f ControlFlags) Grabbed() bool {
+	return f.Has(V4L2_CTRL_FLAG_GRABBED)
+}
+
+// ExecuteOnWrite reports whether setting the control
+// acts even if the value doesn't change
+func (f ControlFlags) ExecuteOnWrite() bool {
+	return f.Has(V4L2_CTRL_FLAG_EXECUTE_ON_WRITE)
+}
+
+// Names returns names of the flags which are set, e.g. "read-only"
+// for V4L2_CTRL_FLAG_READ_ONLY. Unknown flags are formatted in hex.
+func (f ControlFlags) Names() []string {
+	return flagNames(uint32(f), controlFlagNames)
+}
+
+func (f ControlFlags) String() string {
+	return strings.Join(f.Names(), "|")
+}
+
+// controlClass returns the V4L2_CTRL_CLASS_* class of a control
+func controlClass(id uint32) uint32 {
+	return id & 0x0fff0000
+}
+
+// checkRange returns *ControlRangeError if the value is out
+// of the range of the control or doesn't match its step
+func checkRange(id ControlID, name string, value, min, max, step int64) error {
+	if value < min || value > max || (step > 1 && (value-min)%step != 0) {
+		return &ControlRangeError{
+			ID:    id,
+			Name:  name,
+			Value: value,
+			Min:   min,
+			Max:   max,
+			Step:  step,
+		}
+	}
+	return nil
+}
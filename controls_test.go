@@ -0,0 +1,45 @@
+package webcam
+
+import "testing"
+
+func TestControlFlagsNames(t *testing.T) {
+	flags := ControlFlags(V4L2_CTRL_FLAG_READ_ONLY | V4L2_CTRL_FLAG_VOLATILE | 0x80000000)
+	if s := flags.String(); s != "read-only|volatile|0x80000000" {
+		t.Errorf("String = %q", s)
+	}
+	if !flags.ReadOnly() || !flags.Volatile() || flags.WriteOnly() {
+		t.Errorf("flags %v decoded wrong", flags)
+	}
+	if names := ControlFlags(0).Names(); len(names) != 0 {
+		t.Errorf("names of no flags = %v", names)
+	}
+}
+
+func TestControlMetadata(t *testing.T) {
+	d := newTestDriver()
+	powerLine := ControlID(V4L2_CID_POWER_LINE_FREQUENCY)
+	d.AddControl(FakeControl{
+		ID:      powerLine,
+		Name:    "Power Line Frequency",
+		Type:    V4L2_CTRL_TYPE_MENU,
+		Max:     2,
+		Step:    1,
+		Default: 1,
+		Flags:   V4L2_CTRL_FLAG_UPDATE,
+		Menu:    []string{"Disabled", "", "60 Hz"},
+	})
+	w := openTestDriver(t, d)
+
+	c, ok := w.GetControls()[powerLine]
+	if !ok {
+		t.Fatal("menu control is missing")
+	}
+	if c.Default != 1 || c.Class != V4L2_CTRL_CLASS_USER || c.Flags.String() != "update" {
+		t.Errorf("control = %+v", c)
+	}
+
+	// Items with empty names are skipped
+	if len(c.Menu) != 2 || c.Menu[0].Name != "Disabled" || c.Menu[1].Index != 2 || c.Menu[1].Name != "60 Hz" {
+		t.Errorf("menu = %+v", c.Menu)
+	}
+}
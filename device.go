@@ -0,0 +1,66 @@
+package webcam
+
+import (
+	"unsafe"
+
+	"github.com/blackjack/webcam/ioctl"
+	"golang.org/x/sys/unix"
+)
+
+// device is the backend a Webcam talks to. Every V4L2 request the package
+// makes goes through it, which allows the real kernel driver to be replaced
+// with FakeDriver in tests.
+type device interface {
+	// fd returns the descriptor that is placed into the poll set.
+	fd() uintptr
+	ioctl(op uintptr, arg unsafe.Pointer) error
+	mmap(offset int64, length int) ([]byte, error)
+	munmap(b []byte) error
+	poll(fds []unix.PollFd, timeout int) (int, error)
+
+	// polled returns the events of the device once a poll
+	// of several descriptors returned for its descriptor
+	polled(fd unix.PollFd) int16
+	read(p []byte) (int, error)
+	close() error
+}
+
+// fileDevice is the default device backed by an open V4L2 node.
+type fileDevice struct {
+	handle uintptr
+}
+
+func (d *fileDevice) fd() uintptr {
+	return d.handle
+}
+
+func (d *fileDevice) ioctl(op uintptr, arg unsafe.Pointer) error {
+	return ioctlError(op, ioctl.Ioctl(d.handle, op, uintptr(arg)))
+}
+
+func (d *fileDevice) mmap(offset int64, length int) ([]byte, error) {
+	b, err := unix.Mmap(int(d.handle), offset, length, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	return b, syscallError("mmap", err)
+}
+
+func (d *fileDevice) munmap(b []byte) error {
+	return syscallError("munmap", unix.Munmap(b))
+}
+
+func (d *fileDevice) poll(fds []unix.PollFd, timeout int) (int, error) {
+	n, err := unix.Poll(fds, timeout)
+	return n, syscallError("poll", err)
+}
+
+func (d *fileDevice) polled(fd unix.PollFd) int16 {
+	return fd.Revents
+}
+
+func (d *fileDevice) read(p []byte) (int, error) {
+	n, err := unix.Read(int(d.handle), p)
+	return n, syscallError("read", err)
+}
+
+func (d *fileDevice) close() error {
+	return unix.Close(int(d.handle))
+}
@@ -0,0 +1,203 @@
+package webcam
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// DeviceInfo describes a V4L2 device node found by Discover
+type DeviceInfo struct {
+	// Path of the device node, e.g. "/dev/video0"
+	Path string
+
+	// Human-readable name of the device
+	Name string
+
+	// Name of the kernel driver, e.g. "uvcvideo"
+	Driver string
+
+	// Location of the device in the system, e.g. "usb-0000:00:14.0-1"
+	BusInfo string
+
+	// USB vendor and product IDs as four hex digits, and the serial
+	// number. They are empty for devices which are not on USB.
+	Vendor  string
+	Product string
+	Serial  string
+
+	// Links to the node in /dev/v4l/by-id and /dev/v4l/by-path, which,
+	// unlike the node path, don't change between reboots and replugs
+	ByID   []string
+	ByPath []string
+
+	// Capabilities of the node. They are zero if the node
+	// couldn't be opened, e.g. for lack of permissions.
+	Capabilities CapabilityFlags
+}
+
+// IsCapture reports whether video can be captured from the node
+func (d DeviceInfo) IsCapture() bool {
+	return d.Capabilities.VideoCapture()
+}
+
+// IsMetadata reports whether the node captures metadata, like the second
+// node UVC cameras expose alongside with the video one
+func (d DeviceInfo) IsMetadata() bool {
+	return d.Capabilities.Has(V4L2_CAP_META_CAPTURE)
+}
+
+// IsOutput reports whether video can be output to the node
+func (d DeviceInfo) IsOutput() bool {
+	return d.Capabilities.VideoOutput()
+}
+
+// Locations of device nodes and their descriptions
+const (
+	devDir  = "/dev"
+	sysDir  = "/sys/class/video4linux"
+	linkDir = "/dev/v4l"
+)
+
+// Discover finds V4L2 video nodes in /dev and /sys/class/video4linux and
+// describes each of them, ordered by node number. Each node is opened
+// briefly to query it with VIDIOC_QUERYCAP, which may contend with other
+// processes opening it at the same time, e.g. drivers which allow a single
+// open fail those with EBUSY.
+func Discover() ([]DeviceInfo, error) {
+	return discover(devDir, sysDir, linkDir, queryNode)
+}
+
+func discover(devDir, sysDir, linkDir string, query func(path string) (DeviceCapabilities, error)) ([]DeviceInfo, error) {
+	names := make(map[string]bool)
+
+	nodes, _ := filepath.Glob(filepath.Join(devDir, "video*"))
+	for _, node := range nodes {
+		names[filepath.Base(node)] = true
+	}
+
+	entries, err := os.ReadDir(sysDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "video") {
+			names[entry.Name()] = true
+		}
+	}
+
+	byID := readLinks(filepath.Join(linkDir, "by-id"))
+	byPath := readLinks(filepath.Join(linkDir, "by-path"))
+
+	result := make([]DeviceInfo, 0, len(names))
+	for name := range names {
+		info := describeNode(devDir, sysDir, name, query)
+		info.ByID = byID[name]
+		info.ByPath = byPath[name]
+		result = append(result, info)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return nodeLess(filepath.Base(result[i].Path), filepath.Base(result[j].Path))
+	})
+
+	return result, nil
+}
+
+// describe describes a single node like Discover,
+// without opening the other nodes
+func describe(name string) DeviceInfo {
+	info := describeNode(devDir, sysDir, name, queryNode)
+	info.ByID = readLinks(filepath.Join(linkDir, "by-id"))[name]
+	info.ByPath = readLinks(filepath.Join(linkDir, "by-path"))[name]
+	return info
+}
+
+// describeNode describes the node with the name, except for its links
+func describeNode(devDir, sysDir, name string, query func(path string) (DeviceCapabilities, error)) DeviceInfo {
+	info := DeviceInfo{Path: filepath.Join(devDir, name)}
+
+	sys := filepath.Join(sysDir, name)
+	info.Name = readSysfs(sys, "name")
+	if driver, err := filepath.EvalSymlinks(filepath.Join(sys, "device", "driver")); err == nil {
+		info.Driver = filepath.Base(driver)
+	}
+	if usb := usbDevice(sys); usb != "" {
+		info.Vendor = readSysfs(usb, "idVendor")
+		info.Product = readSysfs(usb, "idProduct")
+		info.Serial = readSysfs(usb, "serial")
+	}
+
+	if caps, err := query(info.Path); err == nil {
+		if caps.Card != "" {
+			info.Name = caps.Card
+		}
+		info.Driver = caps.Driver
+		info.BusInfo = caps.BusInfo
+		info.Capabilities = caps.DeviceCaps
+	}
+	return info
+}
+
+// queryNode returns capabilities of the device node at path
+func queryNode(path string) (DeviceCapabilities, error) {
+	handle, err := unix.Open(path, unix.O_RDWR|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return DeviceCapabilities{}, err
+	}
+	w := &Webcam{dev: &fileDevice{handle: uintptr(handle)}}
+	defer w.dev.close()
+
+	return w.Capabilities()
+}
+
+// usbDevice returns the sysfs directory of the USB device the node
+// belongs to, or an empty string if it isn't a USB device
+func usbDevice(sys string) string {
+	dir, err := filepath.EvalSymlinks(filepath.Join(sys, "device"))
+	if err != nil {
+		return ""
+	}
+	// The node belongs to an interface of the device
+	for ; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "idVendor")); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+// readLinks returns links in dir indexed by the name of the node they point to
+func readLinks(dir string) map[string][]string {
+	links := make(map[string][]string)
+	found, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, link := range found {
+		if target, err := filepath.EvalSymlinks(link); err == nil {
+			name := filepath.Base(target)
+			links[name] = append(links[name], link)
+		}
+	}
+	return links
+}
+
+func readSysfs(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// nodeLess orders node names by their number, so that video10 follows video9
+func nodeLess(a, b string) bool {
+	na, erra := strconv.Atoi(strings.TrimPrefix(a, "video"))
+	nb, errb := strconv.Atoi(strings.TrimPrefix(b, "video"))
+	if erra != nil || errb != nil || na == nb {
+		return a < b
+	}
+	return na < nb
+}
@@ -0,0 +1,85 @@
+package webcam
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// writeTestFile writes the file, creating its directory
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	root := t.TempDir()
+	dev := filepath.Join(root, "dev")
+	sys := filepath.Join(root, "sys")
+	links := filepath.Join(dev, "v4l")
+
+	// video10 is the metadata node of the same USB camera
+	usb := filepath.Join(root, "devices", "usb1", "1-1")
+	writeTestFile(t, filepath.Join(usb, "idVendor"), "046d\n")
+	writeTestFile(t, filepath.Join(usb, "idProduct"), "0825\n")
+	writeTestFile(t, filepath.Join(usb, "serial"), "ABCD\n")
+	for _, name := range []string{"video2", "video10"} {
+		writeTestFile(t, filepath.Join(dev, name), "")
+		writeTestFile(t, filepath.Join(sys, name, "name"), "Webcam C270\n")
+		iface := filepath.Join(usb, "1-1:1.0")
+		os.MkdirAll(iface, 0755)
+		if err := os.Symlink(iface, filepath.Join(sys, name, "device")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, filepath.Join(dev, "video0"), "")
+	os.MkdirAll(filepath.Join(links, "by-path"), 0755)
+	os.Symlink(filepath.Join(dev, "video2"), filepath.Join(links, "by-path", "usb-0-1-video-index0"))
+
+	query := func(path string) (DeviceCapabilities, error) {
+		switch filepath.Base(path) {
+		case "video2":
+			return DeviceCapabilities{Card: "C270", Driver: "uvcvideo", DeviceCaps: CapabilityFlags(V4L2_CAP_VIDEO_CAPTURE | V4L2_CAP_STREAMING)}, nil
+		case "video10":
+			return DeviceCapabilities{Driver: "uvcvideo", DeviceCaps: CapabilityFlags(V4L2_CAP_META_CAPTURE)}, nil
+		}
+		return DeviceCapabilities{}, unix.EACCES
+	}
+	devices, err := discover(dev, sys, links, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 3 {
+		t.Fatalf("devices = %+v", devices)
+	}
+	for i, name := range []string{"video0", "video2", "video10"} {
+		if filepath.Base(devices[i].Path) != name {
+			t.Errorf("device %d is %s, want %s", i, devices[i].Path, name)
+		}
+	}
+
+	camera := devices[1]
+	if camera.Name != "C270" || camera.Vendor != "046d" || camera.Product != "0825" || camera.Serial != "ABCD" {
+		t.Errorf("camera = %+v", camera)
+	}
+	if !camera.IsCapture() || len(camera.ByPath) != 1 {
+		t.Errorf("camera = %+v", camera)
+	}
+	if meta := devices[2]; !meta.IsMetadata() || meta.IsCapture() || meta.Name != "Webcam C270" {
+		t.Errorf("metadata node = %+v", meta)
+	}
+
+	// Nodes which can't be opened are still reported
+	if devices[0].Capabilities != 0 {
+		t.Errorf("capabilities of an inaccessible node = %v", devices[0].Capabilities)
+	}
+}
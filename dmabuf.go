@@ -0,0 +1,101 @@
+package webcam
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Export buffers as DMABUF file descriptors (VIDIOC_EXPBUF) when streaming
+// starts with IOMethodMMAP. The descriptors can be passed to other devices
+// or processes, e.g. encoders or a compositor, which then access frames
+// without copying. They are available as Frame.DMABUF and from DMABUFs,
+// and are closed when streaming stops.
+// Not allowed if streaming is already on.
+func (w *Webcam) SetDMABUFExport(enable bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.streaming {
+		return &stateError{"Cannot change DMABUF export when streaming", ErrAlreadyStreaming}
+	}
+	w.exportDMABUF = enable
+	return nil
+}
+
+// Set DMABUF file descriptors the frames are captured into with
+// IOMethodDMABUF, one descriptor per buffer. Each of them must be large
+// enough to hold a frame of the current format. The number of descriptors
+// overrides the buffer count. The descriptors are not closed by Webcam.
+// Not allowed if streaming is already on.
+func (w *Webcam) SetDMABUFs(fds []int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.streaming {
+		return &stateError{"Cannot set DMABUF file descriptors when streaming", ErrAlreadyStreaming}
+	}
+	w.imports = append([]int(nil), fds...)
+	return nil
+}
+
+// DMABUFs returns the DMABUF file descriptor of each buffer, indexed by
+// buffer index. It is empty unless streaming with exported or imported
+// DMABUF.
+func (w *Webcam) DMABUFs() []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]int(nil), w.dmabufs...)
+}
+
+func (w *Webcam) exportBuffers() error {
+	w.dmabufs = make([]int, 0, len(w.buffers))
+	for index := range w.buffers {
+		fd, err := exportBuffer(w.dev, uint32(index))
+
+		if err != nil {
+			return fmt.Errorf("Failed to export buffer: %w", err)
+		}
+
+		w.dmabufs = append(w.dmabufs, fd)
+	}
+	return nil
+}
+
+func (w *Webcam) importDMABUFs() error {
+	if len(w.imports) == 0 {
+		return &stateError{"no DMABUF file descriptors to import", unix.EINVAL}
+	}
+
+	pix, err := getImageFormat(w.dev)
+
+	if err != nil {
+		return fmt.Errorf("Failed to get image format: %w", err)
+	}
+
+	// The buffer count set by SetBufferCount is kept for other I/O methods
+	count := uint32(len(w.imports))
+	err = requestBuffers(w.dev, w.bufType, V4L2_MEMORY_DMABUF, &count)
+
+	if err != nil {
+		return fmt.Errorf("Failed to request DMABUF buffers: %w", err)
+	}
+
+	if count > uint32(len(w.imports)) {
+		count = uint32(len(w.imports))
+	}
+
+	w.dmabufs = append([]int(nil), w.imports[:count]...)
+	w.buffers = make([][]byte, count, count)
+	for index, fd := range w.dmabufs {
+		// Not every exporter allows mapping, frames
+		// are then only accessible through the descriptor
+		buffer, err := unix.Mmap(fd, 0, int(pix.Sizeimage), unix.PROT_READ, unix.MAP_SHARED)
+		if err == nil {
+			w.buffers[index] = buffer
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package webcam
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// isOpen reports whether the file descriptor is open
+func isOpen(fd int) bool {
+	_, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0)
+	return err == nil
+}
+
+func TestDMABUFExport(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+
+	if err := w.SetDMABUFExport(true); err != nil {
+		t.Fatal(err)
+	}
+	startTestStreaming(t, w, 2)
+	if err := w.SetDMABUFExport(false); !errors.Is(err, ErrAlreadyStreaming) {
+		t.Errorf("SetDMABUFExport when streaming = %v, want ErrAlreadyStreaming", err)
+	}
+
+	fds := w.DMABUFs()
+	if len(fds) != 2 {
+		t.Fatalf("DMABUFs = %v", fds)
+	}
+
+	pushTestFrame(t, d, w, "exported")
+	frame, err := w.GetFrameWithMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.DMABUF != fds[frame.Index] {
+		t.Fatalf("frame DMABUF %d, buffers %v", frame.DMABUF, fds)
+	}
+
+	// The frame is accessible through the descriptor
+	mapped, err := unix.Mmap(frame.DMABUF, 0, len(frame.Data), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mapped[:8]) != "exported" {
+		t.Errorf("mapped descriptor holds %q", mapped[:8])
+	}
+	unix.Munmap(mapped)
+	w.ReleaseFrame(frame.Index)
+
+	if err := w.StopStreaming(); err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range fds {
+		if isOpen(fd) {
+			t.Errorf("descriptor %d is open after StopStreaming", fd)
+		}
+	}
+}
+
+func TestDMABUFImport(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+
+	var fds []int
+	for i := 0; i < 2; i++ {
+		fd, err := unix.MemfdCreate("frame", unix.MFD_CLOEXEC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unix.Close(fd)
+		if err := unix.Ftruncate(fd, 640*480*2); err != nil {
+			t.Fatal(err)
+		}
+		fds = append(fds, fd)
+	}
+
+	if err := w.SetIOMethod(IOMethodDMABUF); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetDMABUFs(fds); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.StartStreaming(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []string{"first", "second", "third"} {
+		pushTestFrame(t, d, w, data)
+		frame, err := w.GetFrameWithMetadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.DMABUF != fds[frame.Index] || string(frame.Data) != data {
+			t.Fatalf("frame %q in descriptor %d, imported %v", frame.Data, frame.DMABUF, fds)
+		}
+		w.ReleaseFrame(frame.Index)
+	}
+
+	if err := w.ReleaseFrame(99); !errors.Is(err, unix.EINVAL) {
+		t.Errorf("ReleaseFrame(99) = %v, want EINVAL", err)
+	}
+
+	// Imported descriptors are owned by the caller
+	w.Close()
+	for _, fd := range fds {
+		if !isOpen(fd) {
+			t.Errorf("imported descriptor %d was closed", fd)
+		}
+	}
+}
+
+// The buffer count set for other I/O methods is kept
+// when the imported descriptors override it
+func TestDMABUFImportBufferCount(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+
+	fd, err := unix.MemfdCreate("frame", unix.MFD_CLOEXEC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Ftruncate(fd, 640*480*2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.SetBufferCount(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetIOMethod(IOMethodDMABUF); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetDMABUFs([]int{fd}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.StartStreaming(); err != nil {
+		t.Fatal(err)
+	}
+	if fds := w.DMABUFs(); len(fds) != 1 {
+		t.Fatalf("DMABUFs = %v, want the imported one", fds)
+	}
+	if err := w.StopStreaming(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.SetIOMethod(IOMethodMMAP); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.StartStreaming(); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.buffers) != 3 {
+		t.Errorf("%d buffers mapped, want 3", len(w.buffers))
+	}
+}
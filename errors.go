@@ -1,8 +1,203 @@
 package webcam
 
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Errors which can be tested for with errors.Is. Failed requests are
+// reported as IoctlError, which matches the sentinel errors
+// corresponding to its errno, e.g. EBUSY matches ErrDeviceBusy.
+var (
+	// The device is used by another process or the request
+	// is not allowed in the current state of the device
+	ErrDeviceBusy = errors.New("Device is busy")
+
+	// The device was unplugged
+	ErrDisconnected = errors.New("Device is disconnected")
+
+	// The request needs streaming to be on
+	ErrNotStreaming = errors.New("Not streaming")
+
+	// The request is not allowed when streaming
+	ErrAlreadyStreaming = errors.New("Already streaming")
+
+	// The device or its driver doesn't implement the request
+	ErrUnsupported = errors.New("Not supported by the device")
+
+	// The webcam was closed
+	ErrClosed = errors.New("Webcam is closed")
+
+	// The frame was released already
+	ErrFrameReleased = errors.New("Frame is already released")
+)
+
 // Timeout error
 type Timeout struct{}
 
 func (e *Timeout) Error() string {
 	return "Timeout occured"
 }
+
+// Timeout reports that the error is a timeout, like net.Error does
+func (e *Timeout) Timeout() bool {
+	return true
+}
+
+// Temporary reports that waiting again may succeed
+func (e *Timeout) Temporary() bool {
+	return true
+}
+
+// FrameLeakError is reported in frame debug mode for frames which were
+// garbage collected without being released, see Webcam.SetFrameDebug
+type FrameLeakError struct {
+	Index    uint32
+	Sequence uint32
+
+	// Stack trace of the goroutine which obtained the frame
+	Stack string
+}
+
+func (e *FrameLeakError) Error() string {
+	return fmt.Sprintf("Frame %d in buffer %d was not released, obtained at:\n%s", e.Sequence, e.Index, e.Stack)
+}
+
+// ExtControlError reports the control of a batch which made
+// VIDIOC_G_EXT_CTRLS, VIDIOC_S_EXT_CTRLS or VIDIOC_TRY_EXT_CTRLS fail
+type ExtControlError struct {
+	// Index of the control in the batch
+	Index int
+	ID    ControlID
+	Err   error
+}
+
+func (e *ExtControlError) Error() string {
+	return fmt.Sprintf("Control 0x%08x at index %d failed: %v", uint32(e.ID), e.Index, e.Err)
+}
+
+func (e *ExtControlError) Unwrap() error {
+	return e.Err
+}
+
+// ControlRangeError reports a value which is out of the range of
+// a control, or which is not a multiple of its step
+type ControlRangeError struct {
+	ID    ControlID
+	Name  string
+	Value int64
+	Min   int64
+	Max   int64
+	Step  int64
+}
+
+func (e *ControlRangeError) Error() string {
+	return fmt.Sprintf("Value %d of %s is not in range %d to %d by %d", e.Value, e.Name, e.Min, e.Max, e.Step)
+}
+
+// IoctlError records a failed request to the device
+type IoctlError struct {
+	// Name of the ioctl request, e.g. "VIDIOC_DQBUF", or of the
+	// system call, e.g. "mmap"
+	Op string
+
+	Errno unix.Errno
+}
+
+func (e *IoctlError) Error() string {
+	return e.Op + ": " + e.Errno.Error()
+}
+
+func (e *IoctlError) Unwrap() error {
+	return e.Errno
+}
+
+// Is reports whether the errno corresponds to one of the sentinel errors
+func (e *IoctlError) Is(target error) bool {
+	switch target {
+	case ErrDeviceBusy:
+		return e.Errno == unix.EBUSY
+	case ErrDisconnected:
+		return e.Errno == unix.ENODEV || e.Errno == unix.ENXIO
+	case ErrUnsupported:
+		return e.Errno == unix.ENOTTY || e.Errno == unix.EOPNOTSUPP
+	}
+	return false
+}
+
+// Timeout reports whether the request would block, e.g. DQBUF
+// with no frame ready
+func (e *IoctlError) Timeout() bool {
+	return e.Errno == unix.EAGAIN
+}
+
+// Temporary reports whether the request may succeed if retried
+func (e *IoctlError) Temporary() bool {
+	return e.Errno.Temporary()
+}
+
+var ioctlNames = map[uintptr]string{
+	VIDIOC_QUERYCAP:            "VIDIOC_QUERYCAP",
+	VIDIOC_ENUM_FMT:            "VIDIOC_ENUM_FMT",
+	VIDIOC_G_FMT:               "VIDIOC_G_FMT",
+	VIDIOC_S_FMT:               "VIDIOC_S_FMT",
+	VIDIOC_REQBUFS:             "VIDIOC_REQBUFS",
+	VIDIOC_QUERYBUF:            "VIDIOC_QUERYBUF",
+	VIDIOC_QBUF:                "VIDIOC_QBUF",
+	VIDIOC_EXPBUF:              "VIDIOC_EXPBUF",
+	VIDIOC_DQBUF:               "VIDIOC_DQBUF",
+	VIDIOC_G_PARM:              "VIDIOC_G_PARM",
+	VIDIOC_S_PARM:              "VIDIOC_S_PARM",
+	VIDIOC_G_CTRL:              "VIDIOC_G_CTRL",
+	VIDIOC_S_CTRL:              "VIDIOC_S_CTRL",
+	VIDIOC_QUERYCTRL:           "VIDIOC_QUERYCTRL",
+	VIDIOC_QUERYMENU:           "VIDIOC_QUERYMENU",
+	VIDIOC_QUERY_EXT_CTRL:      "VIDIOC_QUERY_EXT_CTRL",
+	VIDIOC_G_EXT_CTRLS:         "VIDIOC_G_EXT_CTRLS",
+	VIDIOC_S_EXT_CTRLS:         "VIDIOC_S_EXT_CTRLS",
+	VIDIOC_TRY_EXT_CTRLS:       "VIDIOC_TRY_EXT_CTRLS",
+	VIDIOC_DQEVENT:             "VIDIOC_DQEVENT",
+	VIDIOC_SUBSCRIBE_EVENT:     "VIDIOC_SUBSCRIBE_EVENT",
+	VIDIOC_UNSUBSCRIBE_EVENT:   "VIDIOC_UNSUBSCRIBE_EVENT",
+	VIDIOC_STREAMON:            "VIDIOC_STREAMON",
+	VIDIOC_STREAMOFF:           "VIDIOC_STREAMOFF",
+	VIDIOC_G_INPUT:             "VIDIOC_G_INPUT",
+	VIDIOC_S_INPUT:             "VIDIOC_S_INPUT",
+	VIDIOC_ENUM_FRAMESIZES:     "VIDIOC_ENUM_FRAMESIZES",
+	VIDIOC_ENUM_FRAMEINTERVALS: "VIDIOC_ENUM_FRAMEINTERVALS",
+}
+
+// ioctlError wraps errno returned by an ioctl request into IoctlError
+func ioctlError(op uintptr, err error) error {
+	name, ok := ioctlNames[op]
+	if !ok {
+		name = fmt.Sprintf("ioctl 0x%08x", op)
+	}
+	return syscallError(name, err)
+}
+
+// syscallError wraps errno returned by a system call into IoctlError.
+// Other errors are returned as they are.
+func syscallError(name string, err error) error {
+	if errno, ok := err.(unix.Errno); ok {
+		return &IoctlError{Op: name, Errno: errno}
+	}
+	return err
+}
+
+// stateError is an error with its own message
+// which matches one of the sentinel errors
+type stateError struct {
+	msg string
+	err error
+}
+
+func (e *stateError) Error() string {
+	return e.msg
+}
+
+func (e *stateError) Unwrap() error {
+	return e.err
+}
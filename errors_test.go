@@ -0,0 +1,85 @@
+package webcam
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestIoctlErrorSentinels(t *testing.T) {
+	tests := []struct {
+		errno    unix.Errno
+		sentinel error
+	}{
+		{unix.EBUSY, ErrDeviceBusy},
+		{unix.ENODEV, ErrDisconnected},
+		{unix.ENXIO, ErrDisconnected},
+		{unix.ENOTTY, ErrUnsupported},
+		{unix.EOPNOTSUPP, ErrUnsupported},
+	}
+
+	for _, test := range tests {
+		err := error(&IoctlError{Op: "VIDIOC_S_FMT", Errno: test.errno})
+		if !errors.Is(err, test.sentinel) || !errors.Is(err, test.errno) {
+			t.Errorf("%v doesn't match %v and its errno", err, test.sentinel)
+		}
+	}
+	if err := error(&IoctlError{Op: "VIDIOC_S_FMT", Errno: unix.EINVAL}); errors.Is(err, ErrDeviceBusy) {
+		t.Errorf("%v matches ErrDeviceBusy", err)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	_, err := Open(filepath.Join(t.TempDir(), "video0"))
+	var ioctlErr *IoctlError
+	if !errors.As(err, &ioctlErr) || ioctlErr.Op != "open" || !errors.Is(err, unix.ENOENT) {
+		t.Errorf("Open of a missing node = %v, want open: ENOENT", err)
+	}
+
+	// Nodes which allow a single open fail with EBUSY
+	if err := syscallError("open", unix.EBUSY); !errors.Is(err, ErrDeviceBusy) {
+		t.Errorf("%v doesn't match ErrDeviceBusy", err)
+	}
+}
+
+func TestStateErrors(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+
+	if err := w.StopStreaming(); !errors.Is(err, ErrNotStreaming) {
+		t.Errorf("StopStreaming when not streaming = %v, want ErrNotStreaming", err)
+	}
+	startTestStreaming(t, w, 2)
+	if err := w.StartStreaming(); !errors.Is(err, ErrAlreadyStreaming) {
+		t.Errorf("StartStreaming when streaming = %v, want ErrAlreadyStreaming", err)
+	}
+	if err := w.SetBufferCount(4); !errors.Is(err, ErrAlreadyStreaming) {
+		t.Errorf("SetBufferCount when streaming = %v, want ErrAlreadyStreaming", err)
+	}
+
+	w.Close()
+	if _, err := w.GetFrameWithMetadata(); !errors.Is(err, ErrClosed) {
+		t.Errorf("GetFrameWithMetadata after Close = %v, want ErrClosed", err)
+	}
+	if err := w.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("second Close = %v, want ErrClosed", err)
+	}
+
+	if _, err := WaitForAny(0); !errors.Is(err, unix.EINVAL) {
+		t.Errorf("WaitForAny without webcams = %v, want EINVAL", err)
+	}
+}
+
+func TestDMABUFImportWithoutDescriptors(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+
+	if err := w.SetIOMethod(IOMethodDMABUF); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.StartStreaming(); !errors.Is(err, unix.EINVAL) {
+		t.Errorf("StartStreaming without DMABUF descriptors = %v, want EINVAL", err)
+	}
+}
@@ -0,0 +1,192 @@
+package webcam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Number of control events waiting for the receiver
+const controlEventQueue = 64
+
+// ControlEvent reports a change of a control, see SubscribeControlEvents
+type ControlEvent struct {
+	ID ControlID
+
+	// Changed properties, a set of V4L2_EVENT_CTRL_CH_* flags
+	Changes uint32
+
+	// One of ControlType* constants, -1 for types
+	// not supported by this package
+	Type int32
+
+	// Current state of the control
+	Value   int64
+	Flags   ControlFlags
+	Min     int32
+	Max     int32
+	Step    int32
+	Default int32
+
+	// Number of the event since the device was opened
+	Sequence uint32
+
+	// Time of the event on the monotonic clock
+	Timestamp time.Duration
+}
+
+// ValueChanged reports whether the value of the control changed
+func (e *ControlEvent) ValueChanged() bool {
+	return e.Changes&V4L2_EVENT_CTRL_CH_VALUE != 0
+}
+
+// FlagsChanged reports whether flags of the control changed,
+// e.g. it became inactive
+func (e *ControlEvent) FlagsChanged() bool {
+	return e.Changes&V4L2_EVENT_CTRL_CH_FLAGS != 0
+}
+
+// RangeChanged reports whether the minimum, maximum,
+// step or default of the control changed
+func (e *ControlEvent) RangeChanged() bool {
+	return e.Changes&V4L2_EVENT_CTRL_CH_RANGE != 0
+}
+
+// SubscribeControlEvents subscribes to changes of the given controls, or
+// of all controls if none are given. The current state of each control is
+// sent first. Changes made through this webcam are not reported.
+//
+// Events are received while waiting for frames, e.g. with WaitForFrame or
+// Stream, and sent on the ControlEvents channel. When not streaming, they
+// are received with WaitForControlEvents.
+func (w *Webcam) SubscribeControlEvents(ids ...ControlID) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	if len(ids) == 0 {
+		for _, c := range queryControls(w.dev) {
+			ids = append(ids, ControlID(c.id))
+		}
+	}
+
+	for _, id := range ids {
+		err := subscribeEvent(w.dev, V4L2_EVENT_CTRL, uint32(id), V4L2_EVENT_SUB_FL_SEND_INITIAL)
+
+		if err != nil {
+			return fmt.Errorf("Failed to subscribe to control 0x%08x: %w", uint32(id), err)
+		}
+	}
+	return nil
+}
+
+// UnsubscribeControlEvents unsubscribes from changes of the given
+// controls, or from all events if none are given
+func (w *Webcam) UnsubscribeControlEvents(ids ...ControlID) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	if len(ids) == 0 {
+		return unsubscribeEvent(w.dev, V4L2_EVENT_ALL, 0)
+	}
+
+	for _, id := range ids {
+		err := unsubscribeEvent(w.dev, V4L2_EVENT_CTRL, uint32(id))
+
+		if err != nil {
+			return fmt.Errorf("Failed to unsubscribe from control 0x%08x: %w", uint32(id), err)
+		}
+	}
+	return nil
+}
+
+// ControlEvents returns the channel control events are sent on. When the
+// receiver doesn't keep up, the oldest events are dropped. The channel
+// is closed by Close.
+func (w *Webcam) ControlEvents() <-chan ControlEvent {
+	return w.events
+}
+
+// WaitForControlEvents waits until control events are received and sends
+// them on the ControlEvents channel. It returns when the context is done,
+// the webcam is closed or the device is disconnected. It's not needed
+// while waiting for frames, which receives the events as well.
+func (w *Webcam) WaitForControlEvents(ctx context.Context) error {
+	return waitContext(ctx, func(timeout int, cancel int) error {
+		return w.waitFor(unix.POLLPRI, timeout, cancel)
+	})
+}
+
+// dispatchEvents dequeues all pending events
+// and sends control events to the receiver
+func (w *Webcam) dispatchEvents() error {
+	for {
+		event, err := dequeueEvent(w.dev)
+
+		if errors.Is(err, unix.ENOENT) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if event._type == V4L2_EVENT_CTRL {
+			w.sendEvent(newControlEvent(&event))
+		}
+	}
+}
+
+// sendEvent sends the event without waiting,
+// dropping the oldest one if the channel is full
+func (w *Webcam) sendEvent(e ControlEvent) {
+	for {
+		select {
+		case w.events <- e:
+			return
+		default:
+		}
+
+		select {
+		case <-w.events:
+		default:
+		}
+	}
+}
+
+func newControlEvent(event *v4l2_event) ControlEvent {
+	ctrl := (*v4l2_event_ctrl)(unsafe.Pointer(&event.union[0]))
+
+	e := ControlEvent{
+		ID:        ControlID(event.id),
+		Changes:   ctrl.changes,
+		Type:      -1,
+		Flags:     ControlFlags(ctrl.flags),
+		Min:       ctrl.minimum,
+		Max:       ctrl.maximum,
+		Step:      ctrl.step,
+		Default:   ctrl.default_value,
+		Sequence:  event.sequence,
+		Timestamp: time.Duration(event.timestamp.Nano()),
+	}
+	if t, ok := controlTypeOf(ctrl._type); ok {
+		e.Type = int32(t)
+	}
+
+	if ctrl._type == V4L2_CTRL_TYPE_INTEGER64 {
+		e.Value = int64(NativeByteOrder.Uint64(ctrl.union[:]))
+	} else {
+		e.Value = int64(int32(NativeByteOrder.Uint32(ctrl.union[:4])))
+	}
+	return e
+}
@@ -0,0 +1,105 @@
+package webcam
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// receiveEvent waits for control events and receives the next one
+func receiveEvent(t *testing.T, w *Webcam) ControlEvent {
+	t.Helper()
+
+	select {
+	case e := <-w.ControlEvents():
+		return e
+	default:
+	}
+	if err := w.WaitForControlEvents(timeoutContext(t, 5*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-w.ControlEvents():
+		return e
+	default:
+		t.Fatal("no control event was received")
+	}
+	return ControlEvent{}
+}
+
+func TestControlEvents(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+
+	if err := w.SubscribeControlEvents(testBrightness); err != nil {
+		t.Fatal(err)
+	}
+	if e := receiveEvent(t, w); e.ID != testBrightness || e.Value != 0 || e.Min != -64 || e.Max != 64 {
+		t.Fatalf("initial event = %+v", e)
+	}
+
+	// Changes made through the webcam are not reported
+	if err := w.SetControl(testBrightness, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ChangeControl(testBrightness, 20); err != nil {
+		t.Fatal(err)
+	}
+	if e := receiveEvent(t, w); !e.ValueChanged() || e.FlagsChanged() || e.Value != 20 {
+		t.Fatalf("event = %+v, want the value changed to 20", e)
+	}
+
+	inactive := FakeControl{ID: testBrightness, Name: "Brightness", Type: V4L2_CTRL_TYPE_INTEGER, Min: -64, Max: 64, Step: 1, Flags: V4L2_CTRL_FLAG_INACTIVE}
+	if err := d.UpdateControl(inactive); err != nil {
+		t.Fatal(err)
+	}
+	if e := receiveEvent(t, w); !e.FlagsChanged() || e.RangeChanged() || !e.Flags.Inactive() {
+		t.Fatalf("event = %+v, want the control inactive", e)
+	}
+
+	if err := w.UnsubscribeControlEvents(); err != nil {
+		t.Fatal(err)
+	}
+	d.ChangeControl(testBrightness, 30)
+	err := w.WaitForControlEvents(timeoutContext(t, 20*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForControlEvents after unsubscribing = %v, want DeadlineExceeded", err)
+	}
+}
+
+// Events are received while waiting for frames
+func TestControlEventsStreaming(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 2)
+
+	if err := w.SubscribeControlEvents(); err != nil {
+		t.Fatal(err)
+	}
+	d.ChangeControl(testBrightness, -10)
+	pushTestFrame(t, d, w, "frame")
+
+	var values []int64
+	for len(w.ControlEvents()) > 0 {
+		e := <-w.ControlEvents()
+		values = append(values, e.Value)
+	}
+	// The change is merged into the pending initial event
+	if len(values) != 1 || values[0] != -10 {
+		t.Errorf("received values %v, want -10", values)
+	}
+}
+
+func TestControlEventsClose(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+
+	time.AfterFunc(20*time.Millisecond, func() { w.Close() })
+	if err := w.WaitForControlEvents(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("WaitForControlEvents interrupted by Close = %v, want ErrClosed", err)
+	}
+	if _, ok := <-w.ControlEvents(); ok {
+		t.Error("events channel is open after Close")
+	}
+}
@@ -35,6 +35,14 @@ func main() {
 	cmap := cam.GetControls()
 	fmt.Println("Available controls: ")
 	for id, c := range cmap {
-		fmt.Printf("ID:%08x %-32s Type: %1d Min: %6d Max: %6d Step: %6d\n", id, c.Name, c.Type, c.Min, c.Max, c.Step)
+		// Controls are listed by the names v4l2-ctl and ParseControls accept
+		fmt.Printf("ID:%08x %-32s Type: %1d Min: %6d Max: %6d Step: %6d Default: %6d Class: %08x", id, webcam.ControlName(c.Name), c.Type, c.Min, c.Max, c.Step, c.Default, c.Class)
+		if c.Flags != 0 {
+			fmt.Printf(" Flags: %s", c.Flags)
+		}
+		fmt.Printf("\n")
+		for _, item := range c.Menu {
+			fmt.Printf("    %d: %s\n", item.Index, item.Name)
+		}
 	}
 }
@@ -4,6 +4,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"image"
@@ -49,7 +50,7 @@ var supportedFormats = map[webcam.PixelFormat]bool{
 }
 
 func main() {
-	dev := flag.String("d", "/dev/video0", "video device to use")
+	dev := flag.String("d", "/dev/video0", "video device to use, \"testpattern\" for a virtual camera")
 	fmtstr := flag.String("f", "", "video format to use, default first supported")
 	szstr := flag.String("s", "", "frame size to use, default largest one")
 	single := flag.Bool("m", false, "single image http mode, default mjpeg video")
@@ -57,7 +58,7 @@ func main() {
 	fps := flag.Bool("p", false, "print fps info")
 	flag.Parse()
 
-	cam, err := webcam.Open(*dev)
+	cam, err := openCamera(*dev)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -134,121 +135,89 @@ FMT:
 		return
 	}
 
-	var (
-		li   chan *bytes.Buffer = make(chan *bytes.Buffer)
-		fi   chan []byte        = make(chan []byte)
-		back chan struct{}      = make(chan struct{})
-	)
-	go encodeToImage(cam, back, fi, li, w, h, f)
+	// The encoder takes the latest frame whenever it is done with the
+	// previous one, and shares the image with all connected clients
+	captured, errs := webcam.Stream(context.Background(), cam, webcam.StreamOptions{Overflow: webcam.OverflowKeepLatest})
+	images := webcam.NewBroadcaster()
 	if *single {
-		go httpImage(*addr, li)
+		go httpImage(*addr, images)
 	} else {
-		go httpVideo(*addr, li)
+		go httpVideo(*addr, images)
 	}
 
-	timeout := uint32(5) //5 seconds
 	start := time.Now()
 	var fr time.Duration
 
-	for {
-		err = cam.WaitForFrame(timeout)
-		if err != nil {
-			log.Println(err)
-			return
-		}
-
-		switch err.(type) {
-		case nil:
-		case *webcam.Timeout:
-			log.Println(err)
-			continue
-		default:
-			log.Println(err)
-			return
+	for frame := range captured {
+		// print framerate info every 10 seconds
+		fr++
+		if *fps {
+			if d := time.Since(start); d > time.Second*10 {
+				fmt.Println(float64(fr)/(float64(d)/float64(time.Second)), "fps")
+				start = time.Now()
+				fr = 0
+			}
 		}
 
-		frame, err := cam.ReadFrame()
+		img, err := encodeToImage(frame, w, h, f)
 		if err != nil {
 			log.Println(err)
 			return
 		}
-		if len(frame) != 0 {
-
-			// print framerate info every 10 seconds
-			fr++
-			if *fps {
-				if d := time.Since(start); d > time.Second*10 {
-					fmt.Println(float64(fr)/(float64(d)/float64(time.Second)), "fps")
-					start = time.Now()
-					fr = 0
-				}
-			}
+		images.Publish(img)
+	}
+	log.Println(<-errs)
+}
 
-			select {
-			case fi <- frame:
-				<-back
-			default:
-			}
-		}
+func openCamera(dev string) (webcam.Camera, error) {
+	if dev == "testpattern" {
+		return webcam.OpenTestPattern(webcam.TestPatternConfig{})
 	}
+	return webcam.Open(dev)
 }
 
-func encodeToImage(wc *webcam.Webcam, back chan struct{}, fi chan []byte, li chan *bytes.Buffer, w, h uint32, format webcam.PixelFormat) {
-
-	var (
-		frame []byte
-		img   image.Image
-	)
-	for {
-		bframe := <-fi
-		// copy frame
-		if len(frame) < len(bframe) {
-			frame = make([]byte, len(bframe))
-		}
-		copy(frame, bframe)
-		back <- struct{}{}
-
-		switch format {
-		case V4L2_PIX_FMT_YUYV:
-			yuyv := image.NewYCbCr(image.Rect(0, 0, int(w), int(h)), image.YCbCrSubsampleRatio422)
-			for i := range yuyv.Cb {
-				ii := i * 4
-				yuyv.Y[i*2] = frame[ii]
-				yuyv.Y[i*2+1] = frame[ii+2]
-				yuyv.Cb[i] = frame[ii+1]
-				yuyv.Cr[i] = frame[ii+3]
+// encodeToImage converts the frame to JPEG. The frame is only read here,
+// the stream returns its buffer once the next frame is received.
+func encodeToImage(frame *webcam.Frame, w, h uint32, format webcam.PixelFormat) (*webcam.Frame, error) {
+	var img image.Image
+
+	switch format {
+	case V4L2_PIX_FMT_YUYV:
+		yuyv := image.NewYCbCr(image.Rect(0, 0, int(w), int(h)), image.YCbCrSubsampleRatio422)
+		for i := range yuyv.Cb {
+			ii := i * 4
+			yuyv.Y[i*2] = frame.Data[ii]
+			yuyv.Y[i*2+1] = frame.Data[ii+2]
+			yuyv.Cb[i] = frame.Data[ii+1]
+			yuyv.Cr[i] = frame.Data[ii+3]
 
-			}
-			img = yuyv
-		default:
-			log.Fatal("invalid format ?")
-		}
-		//convert to jpeg
-		buf := &bytes.Buffer{}
-		if err := jpeg.Encode(buf, img, nil); err != nil {
-			log.Fatal(err)
-			return
 		}
+		img = yuyv
+	default:
+		log.Fatal("invalid format ?")
+	}
+	//convert to jpeg
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		return nil, err
+	}
 
-		const N = 50
-		// broadcast image up to N ready clients
-		nn := 0
-	FOR:
-		for ; nn < N; nn++ {
-			select {
-			case li <- buf:
-			default:
-				break FOR
-			}
-		}
-		if nn == 0 {
-			li <- buf
-		}
+	return &webcam.Frame{
+		Data:      buf.Bytes(),
+		DMABUF:    -1,
+		Sequence:  frame.Sequence,
+		Timestamp: frame.Timestamp,
+		Time:      frame.Time,
+	}, nil
+}
 
-	}
+// subscribe adds a client, which may limit the frame rate with "?fps=N"
+func subscribe(images *webcam.Broadcaster, r *http.Request) *webcam.Subscription {
+	rate, _ := strconv.ParseFloat(r.URL.Query().Get("fps"), 32)
+	return images.Subscribe(webcam.SubscribeOptions{Overflow: webcam.OverflowDropOldest, MaxRate: float32(rate)})
 }
 
-func httpImage(addr string, li chan *bytes.Buffer) {
+func httpImage(addr string, images *webcam.Broadcaster) {
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		log.Println("connect from", r.RemoteAddr, r.URL)
 		if r.URL.Path != "/" {
@@ -256,14 +225,25 @@ func httpImage(addr string, li chan *bytes.Buffer) {
 			return
 		}
 
-		//remove stale image
-		<-li
+		// The next image is taken, so it is never stale
+		sub := subscribe(images, r)
+		defer sub.Close()
 
-		img := <-li
+		var img *webcam.Frame
+		select {
+		case frame, ok := <-sub.Frames():
+			if !ok {
+				return
+			}
+			img = frame
+		case <-r.Context().Done():
+			return
+		}
+		defer img.Release()
 
 		w.Header().Set("Content-Type", "image/jpeg")
 
-		if _, err := w.Write(img.Bytes()); err != nil {
+		if _, err := w.Write(img.Data); err != nil {
 			log.Println(err)
 			return
 		}
@@ -273,7 +253,7 @@ func httpImage(addr string, li chan *bytes.Buffer) {
 	log.Fatal(http.ListenAndServe(addr, nil))
 }
 
-func httpVideo(addr string, li chan *bytes.Buffer) {
+func httpVideo(addr string, images *webcam.Broadcaster) {
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		log.Println("connect from", r.RemoteAddr, r.URL)
 		if r.URL.Path != "/" {
@@ -281,24 +261,27 @@ func httpVideo(addr string, li chan *bytes.Buffer) {
 			return
 		}
 
-		//remove stale image
-		<-li
+		sub := subscribe(images, r)
+		defer sub.Close()
+
 		const boundary = `frame`
 		w.Header().Set("Content-Type", `multipart/x-mixed-replace;boundary=`+boundary)
 		multipartWriter := multipart.NewWriter(w)
 		multipartWriter.SetBoundary(boundary)
 		for {
-			img := <-li
-			image := img.Bytes()
-			iw, err := multipartWriter.CreatePart(textproto.MIMEHeader{
-				"Content-type":   []string{"image/jpeg"},
-				"Content-length": []string{strconv.Itoa(len(image))},
-			})
-			if err != nil {
-				log.Println(err)
+			var img *webcam.Frame
+			select {
+			case frame, ok := <-sub.Frames():
+				if !ok {
+					return
+				}
+				img = frame
+			case <-r.Context().Done():
 				return
 			}
-			_, err = iw.Write(image)
+
+			err := writePart(multipartWriter, img.Data)
+			img.Release()
 			if err != nil {
 				log.Println(err)
 				return
@@ -308,3 +291,15 @@ func httpVideo(addr string, li chan *bytes.Buffer) {
 
 	log.Fatal(http.ListenAndServe(addr, nil))
 }
+
+func writePart(multipartWriter *multipart.Writer, image []byte) error {
+	iw, err := multipartWriter.CreatePart(textproto.MIMEHeader{
+		"Content-type":   []string{"image/jpeg"},
+		"Content-length": []string{strconv.Itoa(len(image))},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = iw.Write(image)
+	return err
+}
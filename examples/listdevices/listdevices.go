@@ -0,0 +1,34 @@
+// Example program that lists V4L2 devices and tells which of them can capture video.
+package main
+
+import (
+	"fmt"
+
+	"github.com/blackjack/webcam"
+)
+
+func main() {
+	devices, err := webcam.Discover()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	for _, d := range devices {
+		kind := "other"
+		switch {
+		case d.IsCapture():
+			kind = "capture"
+		case d.IsMetadata():
+			kind = "metadata"
+		case d.IsOutput():
+			kind = "output"
+		}
+		fmt.Printf("%-14s %-8s %s (%s, %s)\n", d.Path, kind, d.Name, d.Driver, d.BusInfo)
+		if d.Vendor != "" {
+			fmt.Printf("    USB %s:%s serial %q\n", d.Vendor, d.Product, d.Serial)
+		}
+		for _, link := range append(d.ByID, d.ByPath...) {
+			fmt.Printf("    %s\n", link)
+		}
+	}
+}
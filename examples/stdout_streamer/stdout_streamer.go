@@ -84,7 +84,12 @@ func main() {
 
 	println("Press Enter to start streaming")
 	fmt.Scanf("\n")
-	err = cam.StartStreaming()
+	streamFrames(cam)
+}
+
+// streamFrames writes frames of any camera to stdout
+func streamFrames(cam webcam.Camera) {
+	err := cam.StartStreaming()
 	if err != nil {
 		panic(err.Error())
 	}
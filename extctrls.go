@@ -0,0 +1,250 @@
+package webcam
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// ExtControl is a control of a batch accessed with the extended
+// controls API. Which field holds the value depends on the control type.
+type ExtControl struct {
+	ID ControlID
+
+	// Value of integer, boolean, menu, bitmask and 64-bit integer controls
+	Value int64
+
+	// Value of string controls
+	String string
+
+	// Raw value of array and compound controls, e.g. V4L2_CTRL_TYPE_U8
+	Payload []byte
+}
+
+// GetExtControls reads the values of a batch of controls in one request.
+// If a control makes the request fail, the error is an *ExtControlError.
+func (w *Webcam) GetExtControls(controls []ExtControl) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	return accessExtControls(w.dev, VIDIOC_G_EXT_CTRLS, controls)
+}
+
+// SetExtControls sets a batch of controls in one request. Values are
+// updated to the ones the driver actually set, e.g. clamped to the range.
+// Drivers apply controls of one cluster atomically, but when a control
+// fails, the controls before it may have been set already. The error
+// is then an *ExtControlError, see ApplyExtControls.
+func (w *Webcam) SetExtControls(controls []ExtControl) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	return accessExtControls(w.dev, VIDIOC_S_EXT_CTRLS, controls)
+}
+
+// TryExtControls checks whether a batch of controls could be set without
+// setting them. Values are updated to the ones the driver would set.
+func (w *Webcam) TryExtControls(controls []ExtControl) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	return accessExtControls(w.dev, VIDIOC_TRY_EXT_CTRLS, controls)
+}
+
+// ApplyExtControls sets a batch of controls all or nothing. The batch is
+// tried first, and if setting fails after some controls were set, the
+// previous values of the controls are restored. Write-only controls,
+// e.g. buttons, can't be restored.
+func (w *Webcam) ApplyExtControls(controls []ExtControl) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	err := accessExtControls(w.dev, VIDIOC_TRY_EXT_CTRLS, controls)
+
+	if err != nil {
+		return err
+	}
+
+	var saved []ExtControl
+	for _, c := range controls {
+		query, err := queryExtControl(w.dev, uint32(c.ID))
+
+		if err != nil {
+			return fmt.Errorf("Failed to query control: %w", err)
+		}
+
+		if query.flags&(V4L2_CTRL_FLAG_READ_ONLY|V4L2_CTRL_FLAG_WRITE_ONLY) == 0 {
+			saved = append(saved, ExtControl{ID: c.ID})
+		}
+	}
+
+	err = accessExtControls(w.dev, VIDIOC_G_EXT_CTRLS, saved)
+
+	if err != nil {
+		return fmt.Errorf("Failed to save controls: %w", err)
+	}
+
+	err = accessExtControls(w.dev, VIDIOC_S_EXT_CTRLS, controls)
+
+	// Without an index, the request failed before setting anything
+	var failed *ExtControlError
+	if !errors.As(err, &failed) {
+		return err
+	}
+
+	rerr := accessExtControls(w.dev, VIDIOC_S_EXT_CTRLS, saved)
+
+	if rerr != nil {
+		return fmt.Errorf("Failed to restore controls (%v) after: %w", rerr, err)
+	}
+	return err
+}
+
+// accessExtControls gets, sets or tries a batch of controls. String and
+// payload buffers which are too small for getting are grown once to the
+// size reported by the driver.
+func accessExtControls(dev device, op uintptr, controls []ExtControl) error {
+	if len(controls) == 0 {
+		return nil
+	}
+
+	ctrls := make([]v4l2_ext_control, len(controls))
+	buffers := make([][]byte, len(controls))
+	kinds := make([]extKind, len(controls))
+	for i, c := range controls {
+		query, err := queryExtControl(dev, uint32(c.ID))
+
+		if err != nil {
+			return &ExtControlError{Index: i, ID: c.ID, Err: err}
+		}
+
+		kinds[i] = extControlKind(&query)
+		ctrls[i].id = uint32(c.ID)
+		buffers[i], err = encodeExtControl(&ctrls[i], kinds[i], &query, c, op == VIDIOC_G_EXT_CTRLS)
+
+		if err != nil {
+			return &ExtControlError{Index: i, ID: c.ID, Err: err}
+		}
+	}
+
+	errorIdx, err := extControls(dev, op, ctrls)
+
+	if errors.Is(err, unix.ENOSPC) && op == VIDIOC_G_EXT_CTRLS {
+		for i := range ctrls {
+			if buffers[i] != nil && ctrls[i].size > uint32(len(buffers[i])) {
+				buffers[i] = make([]byte, ctrls[i].size)
+			}
+			if buffers[i] != nil {
+				ctrls[i].setPointer(buffers[i])
+			}
+		}
+		errorIdx, err = extControls(dev, op, ctrls)
+	}
+	runtime.KeepAlive(buffers)
+
+	if err != nil {
+		if int(errorIdx) < len(controls) {
+			return &ExtControlError{Index: int(errorIdx), ID: controls[errorIdx].ID, Err: err}
+		}
+		return err
+	}
+
+	for i := range controls {
+		decodeExtControl(&ctrls[i], kinds[i], buffers[i], &controls[i])
+	}
+	return nil
+}
+
+// extKind tells where the value of an extended control is stored
+type extKind int
+
+const (
+	extValue extKind = iota
+	extValue64
+	extString
+	extPayload
+)
+
+func extControlKind(query *v4l2_query_ext_ctrl) extKind {
+	switch {
+	case query._type == V4L2_CTRL_TYPE_STRING:
+		return extString
+	case query.flags&V4L2_CTRL_FLAG_HAS_PAYLOAD != 0:
+		return extPayload
+	case query._type == V4L2_CTRL_TYPE_INTEGER64:
+		return extValue64
+	}
+	return extValue
+}
+
+// encodeExtControl stores the value of the control in the request. The buffer
+// the request points to for strings and payloads is returned.
+func encodeExtControl(ctrl *v4l2_ext_control, kind extKind, query *v4l2_query_ext_ctrl, c ExtControl, get bool) ([]byte, error) {
+	var buf []byte
+	switch kind {
+	case extString:
+		if get {
+			buf = make([]byte, query.elem_size)
+		} else {
+			buf = append([]byte(c.String), 0)
+		}
+	case extPayload:
+		if get {
+			buf = make([]byte, query.elem_size*query.elems)
+		} else {
+			buf = append([]byte(nil), c.Payload...)
+		}
+	case extValue64:
+		NativeByteOrder.PutUint64(ctrl.union[:], uint64(c.Value))
+	default:
+		if c.Value < math.MinInt32 || c.Value > math.MaxInt32 {
+			return nil, unix.ERANGE
+		}
+		NativeByteOrder.PutUint32(ctrl.union[:4], uint32(c.Value))
+	}
+
+	if len(buf) > 0 {
+		ctrl.setPointer(buf)
+	}
+	return buf, nil
+}
+
+func decodeExtControl(ctrl *v4l2_ext_control, kind extKind, buf []byte, c *ExtControl) {
+	if ctrl.size < uint32(len(buf)) {
+		buf = buf[:ctrl.size]
+	}
+
+	switch kind {
+	case extString:
+		if i := bytes.IndexByte(buf, 0); i >= 0 {
+			buf = buf[:i]
+		}
+		c.String = string(buf)
+	case extPayload:
+		c.Payload = buf
+	case extValue64:
+		c.Value = int64(NativeByteOrder.Uint64(ctrl.union[:]))
+	default:
+		c.Value = int64(int32(NativeByteOrder.Uint32(ctrl.union[:4])))
+	}
+}
@@ -0,0 +1,105 @@
+package webcam
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// Controls of the extended controls tests
+const (
+	testLabel = ControlID(V4L2_CID_BASE + 0x1000)
+	testTable = ControlID(V4L2_CID_BASE + 0x1001)
+)
+
+// newExtDriver returns a test driver with a contrast,
+// a string and an array control
+func newExtDriver() *FakeDriver {
+	d := newTestDriver()
+	d.AddControl(FakeControl{ID: testContrast, Name: "Contrast", Type: V4L2_CTRL_TYPE_INTEGER, Min: 0, Max: 255, Step: 1, Default: 128})
+	d.AddControl(FakeControl{ID: testLabel, Name: "Label", Type: V4L2_CTRL_TYPE_STRING, Max: 16, Step: 1})
+	d.AddControl(FakeControl{ID: testTable, Name: "Table", Type: V4L2_CTRL_TYPE_U8, Max: 255, Step: 1, Elems: 4})
+	return d
+}
+
+func TestExtControls(t *testing.T) {
+	d := newExtDriver()
+	w := openTestDriver(t, d)
+
+	set := []ExtControl{
+		{ID: testBrightness, Value: 100},
+		{ID: testContrast, Value: 10},
+		{ID: testLabel, String: "camera"},
+		{ID: testTable, Payload: []byte{1, 2, 3, 4}},
+	}
+	if err := w.SetExtControls(set); err != nil {
+		t.Fatal(err)
+	}
+	// Values are updated to the ones set by the driver
+	if set[0].Value != 64 {
+		t.Errorf("brightness set to %d, want 64", set[0].Value)
+	}
+
+	get := []ExtControl{{ID: testBrightness}, {ID: testContrast}, {ID: testLabel}, {ID: testTable}}
+	if err := w.GetExtControls(get); err != nil {
+		t.Fatal(err)
+	}
+	if get[0].Value != 64 || get[1].Value != 10 || get[2].String != "camera" || !bytes.Equal(get[3].Payload, []byte{1, 2, 3, 4}) {
+		t.Errorf("GetExtControls = %+v", get)
+	}
+}
+
+func TestTryExtControls(t *testing.T) {
+	d := newExtDriver()
+	w := openTestDriver(t, d)
+
+	try := []ExtControl{{ID: testContrast, Value: 300}}
+	if err := w.TryExtControls(try); err != nil {
+		t.Fatal(err)
+	}
+	if try[0].Value != 255 {
+		t.Errorf("tried contrast %d, want 255", try[0].Value)
+	}
+	if value, _ := d.ControlValue(testContrast); value != 128 {
+		t.Errorf("TryExtControls set the contrast to %d", value)
+	}
+
+	// The index reports the control which failed
+	err := w.TryExtControls([]ExtControl{{ID: testBrightness}, {ID: testLabel, String: "a label which is too long"}})
+	var ctrlErr *ExtControlError
+	if !errors.As(err, &ctrlErr) || ctrlErr.Index != 1 || ctrlErr.ID != testLabel || !errors.Is(err, unix.ERANGE) {
+		t.Errorf("TryExtControls = %v, want ERANGE at index 1", err)
+	}
+}
+
+func TestApplyExtControls(t *testing.T) {
+	d := newExtDriver()
+	w := openTestDriver(t, d)
+
+	if err := w.SetControl(testBrightness, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	// Brightness is set before contrast fails, and is restored
+	d.FailControl(testContrast, unix.EIO)
+	err := w.ApplyExtControls([]ExtControl{{ID: testBrightness, Value: 20}, {ID: testContrast, Value: 10}})
+	var ctrlErr *ExtControlError
+	if !errors.As(err, &ctrlErr) || ctrlErr.Index != 1 || !errors.Is(err, unix.EIO) {
+		t.Fatalf("ApplyExtControls = %v, want EIO at index 1", err)
+	}
+	if value, _ := d.ControlValue(testBrightness); value != 5 {
+		t.Errorf("brightness %d after failure, want 5", value)
+	}
+	if value, _ := d.ControlValue(testContrast); value != 128 {
+		t.Errorf("contrast %d after failure, want 128", value)
+	}
+
+	if err := w.ApplyExtControls([]ExtControl{{ID: testBrightness, Value: 20}, {ID: testContrast, Value: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := d.ControlValue(testContrast); value != 10 {
+		t.Errorf("contrast %d, want 10", value)
+	}
+}
@@ -0,0 +1,1720 @@
+package webcam
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Maximum number of buffers the fake driver hands out, same as uvcvideo
+const fakeMaxBuffers = 32
+
+// Distance between mmap offsets of two consecutive fake buffers
+const fakeOffsetStride = 1 << 24
+
+// FakeControl declares a control exposed by FakeDriver.
+// Type is one of V4L2_CTRL_TYPE_* constants and Flags
+// are V4L2_CTRL_FLAG_* flags. String controls hold
+// from Min to Max characters.
+type FakeControl struct {
+	ID      ControlID
+	Name    string
+	Type    uint32
+	Min     int32
+	Max     int32
+	Step    int32
+	Default int32
+	Flags   uint32
+
+	// Items of a menu control indexed from 0, items
+	// with empty names are skipped like drivers do
+	Menu []string
+
+	// Items of an integer menu control indexed from 0
+	IntegerMenu []int64
+
+	// Number of elements of an array control of the
+	// V4L2_CTRL_TYPE_U8, U16 or U32 type, 1 if zero
+	Elems uint32
+}
+
+type fakeControl struct {
+	FakeControl
+	fakeValue
+}
+
+// fakeValue holds the value of a control according to its type
+type fakeValue struct {
+	value   int64
+	str     string
+	payload []byte
+}
+
+type fakeFormat struct {
+	code        uint32
+	description string
+	sizes       []FrameSize
+	rates       []FrameRate
+}
+
+type fakeBuffer struct {
+	// Mapping the driver writes frames into. Buffers of the mmap method
+	// are backed by a memfd, so that they can be mapped by the client and
+	// exported as DMABUF. Imported DMABUF are mapped by the driver.
+	data      []byte
+	memfd     int
+	dmabuf    int
+	bytesused uint32
+	flags     uint32
+	sequence  uint32
+	timestamp unix.Timeval
+	queued    bool
+	done      bool
+}
+
+// fakePlane is a plane of a multi-planar buffer. Planes of a buffer
+// share its memfd and start at page aligned offsets.
+type fakePlane struct {
+	offset uint32
+	size   uint32
+}
+
+// FakeDriver is an in-memory V4L2 driver which allows code built on Webcam
+// to be tested without a camera. It implements the ioctls used by this
+// package: formats, frame sizes, frame intervals and controls are declared
+// by the test, frame content is supplied with PushFrame and failures can be
+// injected for any request with InjectError.
+//
+// A FakeDriver is opened with OpenFake and can be used from several
+// goroutines at once, e.g. one pushing frames and another reading them.
+type FakeDriver struct {
+	// Values reported by VIDIOC_QUERYCAP. They must be set before OpenFake.
+	Driver       string
+	Card         string
+	BusInfo      string
+	Version      uint32
+	Capabilities uint32
+
+	mu        sync.Mutex
+	efd       int
+	closed    chan struct{}
+	formats   []*fakeFormat
+	controls  []*fakeControl
+	failures  map[uintptr][]error
+	ctrlFails map[uint32][]error
+	unplugged bool
+	replugged bool
+
+	pix          v4l2_pix_format
+	planes       []fakePlane // layout of multi-planar buffers
+	timePerFrame v4l2_fract
+	memory       uint32
+	buffers      []*fakeBuffer
+	queue        []uint32
+	done         []uint32
+	streaming    bool
+	sequence     uint32
+
+	// State of the read() I/O method, which starts
+	// with the first read or poll
+	reading bool
+	pending []byte
+
+	// Flags of control event subscriptions by control and
+	// events pending, which are per open file on a real device
+	subscriptions map[uint32]uint32
+	events        []v4l2_event
+	eventSequence uint32
+
+	// Polls in progress, each woken by its own eventfd
+	pollers []*fakePoller
+}
+
+// fakePoller is a poll waiting for some events of the device
+type fakePoller struct {
+	fd     int
+	events int16
+}
+
+// NewFakeDriver creates a fake video capture device which supports
+// the streaming I/O method, but has neither formats nor controls yet.
+func NewFakeDriver() *FakeDriver {
+	return &FakeDriver{
+		Driver:        "fake",
+		Card:          "Fake Camera",
+		BusInfo:       "platform:fake",
+		Capabilities:  V4L2_CAP_VIDEO_CAPTURE | V4L2_CAP_STREAMING,
+		efd:           -1,
+		failures:      make(map[uintptr][]error),
+		ctrlFails:     make(map[uint32][]error),
+		subscriptions: make(map[uint32]uint32),
+		timePerFrame:  v4l2_fract{Numerator: 1, Denominator: 30},
+	}
+}
+
+// OpenFake opens a webcam backed by the fake driver.
+// Only one webcam can be opened on a driver at a time.
+func OpenFake(d *FakeDriver) (*Webcam, error) {
+	d.mu.Lock()
+	if d.unplugged {
+		d.mu.Unlock()
+		return nil, unix.ENOENT
+	}
+	if d.efd >= 0 {
+		d.mu.Unlock()
+		return nil, unix.EBUSY
+	}
+	efd, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		d.mu.Unlock()
+		return nil, err
+	}
+	d.efd = efd
+	d.closed = make(chan struct{})
+	d.signal()
+	d.mu.Unlock()
+
+	w, err := openDevice(d)
+	if err != nil {
+		d.close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// AddFormat declares a pixel format with its description and the frame
+// sizes it supports. The first added format is the initial format
+// of the device.
+func (d *FakeDriver) AddFormat(code PixelFormat, description string, sizes ...FrameSize) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.formats = append(d.formats, &fakeFormat{
+		code:        uint32(code),
+		description: description,
+		sizes:       sizes,
+	})
+	if len(d.formats) == 1 {
+		var width, height uint32
+		if len(sizes) > 0 {
+			width, height = sizes[0].MaxWidth, sizes[0].MaxHeight
+		}
+		d.pix = fakePixFormat(uint32(code), width, height)
+		d.planes = fakePlanes(uint32(code), width, height, d.pix.Sizeimage)
+	}
+}
+
+// SetFrameRates declares frame rates supported by a format. The same
+// rates are reported for every frame size of the format.
+func (d *FakeDriver) SetFrameRates(code PixelFormat, rates ...FrameRate) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if f := d.findFormat(uint32(code)); f != nil {
+		f.rates = rates
+	}
+}
+
+// AddControl declares a control. The control is initially set to
+// its default value.
+func (d *FakeDriver) AddControl(c FakeControl) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ctrl := &fakeControl{FakeControl: c}
+	ctrl.reset()
+	d.controls = append(d.controls, ctrl)
+	sort.Slice(d.controls, func(i, j int) bool {
+		return d.controls[i].ID < d.controls[j].ID
+	})
+}
+
+// ControlValue returns the current value of a control
+// and whether the control exists.
+func (d *FakeDriver) ControlValue(id ControlID) (int32, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if c := d.findControl(uint32(id)); c != nil {
+		return int32(c.value), true
+	}
+	return 0, false
+}
+
+// ChangeControl sets a control the way the device itself or another
+// application does, e.g. automatic exposure changing the exposure time.
+// The value is adjusted to the range of the control, and subscribers
+// to control events are notified.
+func (d *FakeDriver) ChangeControl(id ControlID, value int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.signal()
+
+	c := d.findControl(uint32(id))
+	if c == nil || c.hasPayload() {
+		return unix.EINVAL
+	}
+	value, err := c.validate(value)
+	if err != nil {
+		return err
+	}
+	d.storeControl(c, fakeValue{value: value}, false)
+	return nil
+}
+
+// UpdateControl changes the flags and the range of a declared control,
+// e.g. making it inactive. The value is kept if it's still in the range.
+// Subscribers to control events are notified.
+func (d *FakeDriver) UpdateControl(update FakeControl) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.signal()
+
+	c := d.findControl(uint32(update.ID))
+	if c == nil || update.Type != c.Type {
+		return unix.EINVAL
+	}
+
+	var changes uint32
+	if update.Flags != c.Flags {
+		changes |= V4L2_EVENT_CTRL_CH_FLAGS
+	}
+	if update.Min != c.Min || update.Max != c.Max || update.Step != c.Step || update.Default != c.Default {
+		changes |= V4L2_EVENT_CTRL_CH_RANGE
+	}
+	c.FakeControl = update
+
+	if !c.hasPayload() {
+		if value, err := c.validate(c.value); err == nil && value != c.value {
+			c.value = value
+			changes |= V4L2_EVENT_CTRL_CH_VALUE
+		}
+	}
+	if changes != 0 {
+		d.queueControlEvent(c, changes)
+	}
+	return nil
+}
+
+// Format returns the currently selected pixel format and frame size.
+func (d *FakeDriver) Format() (PixelFormat, uint32, uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return PixelFormat(d.pix.Pixelformat), d.pix.Width, d.pix.Height
+}
+
+// FrameInterval returns the currently selected time per frame.
+func (d *FakeDriver) FrameInterval() (numerator, denominator uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.timePerFrame.Numerator, d.timePerFrame.Denominator
+}
+
+// Streaming reports whether streaming is on.
+func (d *FakeDriver) Streaming() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.streaming
+}
+
+// InjectError makes the next calls of the given ioctl (e.g. VIDIOC_DQBUF)
+// fail with errs, one error per call, e.g. unix.EAGAIN or unix.EIO.
+func (d *FakeDriver) InjectError(op uintptr, errs ...error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.failures[op] = append(d.failures[op], errs...)
+}
+
+// FailControl makes the next writes of a control fail with errs, one error
+// per write, like a device which doesn't respond. Unlike errors injected
+// for VIDIOC_S_EXT_CTRLS, the controls before it in a batch are set.
+func (d *FakeDriver) FailControl(id ControlID, errs ...error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.ctrlFails[uint32(id)] = append(d.ctrlFails[uint32(id)], errs...)
+}
+
+// ClearErrors drops all injected errors which were not returned yet.
+func (d *FakeDriver) ClearErrors() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.failures = make(map[uintptr][]error)
+	d.ctrlFails = make(map[uint32][]error)
+}
+
+// Unplug simulates disconnection of the device. All subsequent requests
+// fail with ENODEV and poll reports an error condition.
+func (d *FakeDriver) Unplug() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.unplugged = true
+	d.signal()
+}
+
+// Replug simulates the device being connected again. Like a real
+// device it comes back with the default format, frame interval and
+// control values. The webcam opened before unplugging stays
+// disconnected and must be closed before the device can be opened.
+func (d *FakeDriver) Replug() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.unplugged {
+		return
+	}
+	if d.efd >= 0 {
+		// Requests on the old webcam must keep failing
+		// until it is closed
+		d.replugged = true
+		return
+	}
+	d.replug()
+}
+
+func (d *FakeDriver) replug() {
+	d.unplugged = false
+	d.replugged = false
+	d.timePerFrame = v4l2_fract{Numerator: 1, Denominator: 30}
+	for _, c := range d.controls {
+		c.reset()
+	}
+	if len(d.formats) > 0 {
+		f := d.formats[0]
+		var width, height uint32
+		if len(f.sizes) > 0 {
+			width, height = f.sizes[0].MaxWidth, f.sizes[0].MaxHeight
+		}
+		d.pix = fakePixFormat(f.code, width, height)
+		d.planes = fakePlanes(f.code, width, height, d.pix.Sizeimage)
+	}
+}
+
+// PushFrame fills the oldest queued buffer with data and makes it
+// available for dequeueing. If no buffer is queued the frame is dropped:
+// the sequence number is still incremented and an error is returned.
+// When the device is used with read(), the frame replaces the one
+// not read yet.
+func (d *FakeDriver) PushFrame(data []byte) error {
+	return d.PushFrameFlags(data, 0)
+}
+
+// PushFrameFlags is like PushFrame, but also sets V4L2_BUF_FLAG_*
+// flags of the buffer, e.g. to mark the frame as corrupted. Frames are
+// always timestamped with the monotonic clock at the end of the frame.
+func (d *FakeDriver) PushFrameFlags(data []byte, flags uint32) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.unplugged {
+		return unix.ENODEV
+	}
+	if d.reading {
+		d.sequence++
+		d.pending = append(d.pending[:0], data...)
+		d.signal()
+		return nil
+	}
+	if !d.streaming {
+		return errors.New("fake: not streaming")
+	}
+
+	sequence := d.sequence
+	d.sequence++
+
+	if len(d.queue) == 0 {
+		return errors.New("fake: no buffer queued, frame dropped")
+	}
+	index := d.queue[0]
+	d.queue = d.queue[1:]
+
+	var ts unix.Timespec
+	unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
+
+	b := d.buffers[index]
+	b.bytesused = d.copyFrame(b, data)
+	b.flags = flags | V4L2_BUF_FLAG_TIMESTAMP_MONOTONIC | V4L2_BUF_FLAG_TSTAMP_SRC_EOF
+	b.sequence = sequence
+	b.timestamp = unix.NsecToTimeval(ts.Nano())
+	b.queued = false
+	b.done = true
+	d.done = append(d.done, index)
+	d.signal()
+
+	return nil
+}
+
+func (d *FakeDriver) fd() uintptr {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return uintptr(d.efd)
+}
+
+// Errors are wrapped the same way fileDevice does
+func (d *FakeDriver) ioctl(op uintptr, arg unsafe.Pointer) error {
+	return ioctlError(op, d.request(op, arg))
+}
+
+func (d *FakeDriver) request(op uintptr, arg unsafe.Pointer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.unplugged {
+		return unix.ENODEV
+	}
+	if errs := d.failures[op]; len(errs) > 0 {
+		d.failures[op] = errs[1:]
+		return errs[0]
+	}
+	defer d.signal()
+
+	switch op {
+	case VIDIOC_QUERYCAP:
+		return d.queryCap((*v4l2_capability)(arg))
+	case VIDIOC_ENUM_FMT:
+		return d.enumFormat((*v4l2_fmtdesc)(arg))
+	case VIDIOC_G_FMT:
+		return d.getFormat((*v4l2_format)(arg))
+	case VIDIOC_S_FMT:
+		return d.setFormat((*v4l2_format)(arg))
+	case VIDIOC_REQBUFS:
+		return d.requestBuffers((*v4l2_requestbuffers)(arg))
+	case VIDIOC_QUERYBUF:
+		return d.queryBuffer((*v4l2_buffer)(arg))
+	case VIDIOC_QBUF:
+		return d.enqueueBuffer((*v4l2_buffer)(arg))
+	case VIDIOC_EXPBUF:
+		return d.exportBuffer((*v4l2_exportbuffer)(arg))
+	case VIDIOC_DQBUF:
+		return d.dequeueBuffer((*v4l2_buffer)(arg))
+	case VIDIOC_STREAMON:
+		return d.streamOn(*(*uint32)(arg))
+	case VIDIOC_STREAMOFF:
+		return d.streamOff(*(*uint32)(arg))
+	case VIDIOC_G_PARM:
+		return d.getParm((*v4l2_streamparm)(arg))
+	case VIDIOC_S_PARM:
+		return d.setParm((*v4l2_streamparm)(arg))
+	case VIDIOC_QUERYCTRL:
+		return d.queryControl((*v4l2_queryctrl)(arg))
+	case VIDIOC_QUERYMENU:
+		return d.queryMenu((*v4l2_querymenu)(arg))
+	case VIDIOC_G_CTRL:
+		return d.getControl((*v4l2_control)(arg))
+	case VIDIOC_S_CTRL:
+		return d.setControl((*v4l2_control)(arg))
+	case VIDIOC_QUERY_EXT_CTRL:
+		return d.queryExtControl((*v4l2_query_ext_ctrl)(arg))
+	case VIDIOC_G_EXT_CTRLS, VIDIOC_S_EXT_CTRLS, VIDIOC_TRY_EXT_CTRLS:
+		return d.extControls(op, (*v4l2_ext_controls)(arg))
+	case VIDIOC_SUBSCRIBE_EVENT:
+		return d.subscribeEvent((*v4l2_event_subscription)(arg))
+	case VIDIOC_UNSUBSCRIBE_EVENT:
+		return d.unsubscribeEvent((*v4l2_event_subscription)(arg))
+	case VIDIOC_DQEVENT:
+		return d.dequeueEvent((*v4l2_event)(arg))
+	case VIDIOC_G_INPUT:
+		*(*int32)(arg) = 0
+		return nil
+	case VIDIOC_S_INPUT:
+		if *(*uint32)(arg) != 0 {
+			return unix.EINVAL
+		}
+		return nil
+	case VIDIOC_ENUM_FRAMESIZES:
+		return d.enumFrameSizes((*v4l2_frmsizeenum)(arg))
+	case VIDIOC_ENUM_FRAMEINTERVALS:
+		return d.enumFrameIntervals((*v4l2_frmivalenum)(arg))
+	}
+
+	return unix.ENOTTY
+}
+
+func (d *FakeDriver) mmap(offset int64, length int) ([]byte, error) {
+	b, err := d.mapBuffer(offset, length)
+	return b, syscallError("mmap", err)
+}
+
+func (d *FakeDriver) mapBuffer(offset int64, length int) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.unplugged {
+		return nil, unix.ENODEV
+	}
+	index := offset / fakeOffsetStride
+	start := offset % fakeOffsetStride
+	if d.memory != V4L2_MEMORY_MMAP || index >= int64(len(d.buffers)) || !d.planeStart(start) {
+		return nil, unix.EINVAL
+	}
+	b := d.buffers[index]
+	if start+int64(length) > int64(len(b.data)) {
+		return nil, unix.EINVAL
+	}
+	// Like with a real driver the mapping stays valid
+	// after the buffers are freed
+	return unix.Mmap(b.memfd, start, length, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+}
+
+func (d *FakeDriver) munmap(b []byte) error {
+	return syscallError("munmap", unix.Munmap(b))
+}
+
+func (d *FakeDriver) poll(fds []unix.PollFd, timeout int) (int, error) {
+	d.mu.Lock()
+	own := -1
+	for i := range fds {
+		if d.efd >= 0 && fds[i].Fd == int32(d.efd) {
+			own = i
+		}
+	}
+	if own < 0 {
+		d.mu.Unlock()
+		count, err := unix.Poll(fds, timeout)
+		return count, syscallError("poll", err)
+	}
+
+	events := fds[own].Events
+	if events&unix.POLLIN != 0 {
+		d.startReading()
+	}
+	efd, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		d.mu.Unlock()
+		return -1, err
+	}
+	p := &fakePoller{fd: efd, events: events}
+	d.pollers = append(d.pollers, p)
+	notify(p.fd, d.revents(p.events) != 0)
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		for i, q := range d.pollers {
+			if q == p {
+				d.pollers = append(d.pollers[:i], d.pollers[i+1:]...)
+				break
+			}
+		}
+		d.mu.Unlock()
+		unix.Close(p.fd)
+	}()
+
+	polled := append([]unix.PollFd(nil), fds...)
+	polled[own] = unix.PollFd{Fd: int32(p.fd), Events: unix.POLLIN}
+	deadline := pollDeadline(timeout)
+	for {
+		count, err := unix.Poll(polled, timeout)
+		if count <= 0 || err != nil {
+			return count, syscallError("poll", err)
+		}
+
+		// The eventfd only tells that the state of the driver changed,
+		// report the actual events of the fake device instead.
+		d.mu.Lock()
+		revents := d.revents(events)
+		d.mu.Unlock()
+
+		count = 0
+		for i := range fds {
+			fds[i].Revents = polled[i].Revents
+			if i == own {
+				fds[i].Revents = 0
+				if polled[i].Revents != 0 {
+					fds[i].Revents = revents & (events | unix.POLLERR | unix.POLLHUP)
+				}
+			}
+			if fds[i].Revents != 0 {
+				count++
+			}
+		}
+		if count > 0 || timeout == 0 {
+			return count, nil
+		}
+		timeout = pollTimeout(deadline, timeout)
+	}
+}
+
+// polled translates events of the eventfd, which is readable whenever
+// the device has any event, to the events which were requested
+func (d *FakeDriver) polled(fd unix.PollFd) int16 {
+	if fd.Revents == 0 {
+		return 0
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if fd.Events&unix.POLLIN != 0 {
+		d.startReading()
+	}
+	return d.revents(fd.Events) & (fd.Events | unix.POLLERR | unix.POLLHUP)
+}
+
+func (d *FakeDriver) read(p []byte) (int, error) {
+	n, err := d.readFrame(p)
+	return n, syscallError("read", err)
+}
+
+func (d *FakeDriver) readFrame(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.unplugged {
+		return 0, unix.ENODEV
+	}
+	if !d.startReading() {
+		return 0, unix.EBUSY
+	}
+	if d.pending == nil {
+		return 0, unix.EAGAIN
+	}
+	n := copy(p, d.pending)
+	d.pending = nil
+	d.signal()
+	return n, nil
+}
+
+// startReading starts capture with read(), like the first read or poll does
+// on a real device which is not streaming. It reports whether the device
+// is capturing with read().
+func (d *FakeDriver) startReading() bool {
+	if !d.reading && d.Capabilities&V4L2_CAP_READWRITE != 0 && !d.streaming && len(d.buffers) == 0 {
+		d.reading = true
+		d.signal()
+	}
+	return d.reading
+}
+
+func (d *FakeDriver) close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.efd < 0 {
+		return unix.EBADF
+	}
+	err := unix.Close(d.efd)
+	d.efd = -1
+	close(d.closed)
+	d.streaming = false
+	d.reading = false
+	d.pending = nil
+	d.freeBuffers()
+	d.queue = nil
+	d.done = nil
+	d.subscriptions = make(map[uint32]uint32)
+	d.events = nil
+	if d.replugged {
+		d.replug()
+	}
+	return err
+}
+
+// signal makes the eventfd readable whenever poll on a real device
+// would return immediately, and so do eventfds of polls in progress.
+func (d *FakeDriver) signal() {
+	if d.efd < 0 {
+		return
+	}
+	notify(d.efd, d.revents(unix.POLLIN|unix.POLLPRI) != 0)
+	for _, p := range d.pollers {
+		notify(p.fd, d.revents(p.events) != 0)
+	}
+}
+
+// notify makes the eventfd readable or not
+func notify(efd int, ready bool) {
+	var buf [8]byte
+	unix.Read(efd, buf[:])
+	if ready {
+		NativeByteOrder.PutUint64(buf[:], 1)
+		unix.Write(efd, buf[:])
+	}
+}
+
+// revents returns the events poll would report for the requested ones.
+// Like on a real device, errors of capture are only reported with POLLIN.
+func (d *FakeDriver) revents(events int16) int16 {
+	var pri int16
+	if len(d.events) > 0 {
+		pri = unix.POLLPRI
+	}
+
+	switch {
+	case d.unplugged:
+		return unix.POLLERR | unix.POLLHUP
+	case events&unix.POLLIN == 0:
+		return pri
+	case d.reading:
+		if d.pending != nil {
+			return unix.POLLIN | pri
+		}
+		return pri
+	case !d.streaming:
+		return unix.POLLERR | pri
+	case len(d.done) > 0:
+		return unix.POLLIN | pri
+	}
+	return pri
+}
+
+// bufType returns the buffer type the driver captures with. Devices which
+// declare V4L2_CAP_VIDEO_CAPTURE_MPLANE only use the multi-planar API.
+func (d *FakeDriver) bufType() uint32 {
+	if d.Capabilities&V4L2_CAP_VIDEO_CAPTURE == 0 && d.Capabilities&V4L2_CAP_VIDEO_CAPTURE_MPLANE != 0 {
+		return V4L2_BUF_TYPE_VIDEO_CAPTURE_MPLANE
+	}
+	return V4L2_BUF_TYPE_VIDEO_CAPTURE
+}
+
+func (d *FakeDriver) multiPlanar() bool {
+	return d.bufType() == V4L2_BUF_TYPE_VIDEO_CAPTURE_MPLANE
+}
+
+func (d *FakeDriver) findFormat(code uint32) *fakeFormat {
+	for _, f := range d.formats {
+		if f.code == code {
+			return f
+		}
+	}
+	return nil
+}
+
+func (d *FakeDriver) findControl(id uint32) *fakeControl {
+	for _, c := range d.controls {
+		if uint32(c.ID) == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (d *FakeDriver) queryCap(caps *v4l2_capability) error {
+	copy(caps.driver[:len(caps.driver)-1], d.Driver)
+	copy(caps.card[:len(caps.card)-1], d.Card)
+	copy(caps.bus_info[:len(caps.bus_info)-1], d.BusInfo)
+	caps.version = d.Version
+	caps.capabilities = d.Capabilities | V4L2_CAP_DEVICE_CAPS
+	caps.device_caps = d.Capabilities
+	return nil
+}
+
+func (d *FakeDriver) enumFormat(desc *v4l2_fmtdesc) error {
+	if desc._type != d.bufType() || desc.index >= uint32(len(d.formats)) {
+		return unix.EINVAL
+	}
+	f := d.formats[desc.index]
+	desc.pixelformat = f.code
+	copy(desc.description[:len(desc.description)-1], f.description)
+	return nil
+}
+
+func (d *FakeDriver) getFormat(format *v4l2_format) error {
+	if format._type != d.bufType() {
+		return unix.EINVAL
+	}
+	if d.multiPlanar() {
+		d.fillPixFormatMplane((*v4l2_pix_format_mplane)(unsafe.Pointer(&format.union.data[0])))
+		return nil
+	}
+	*(*v4l2_pix_format)(unsafe.Pointer(&format.union.data[0])) = d.pix
+	return nil
+}
+
+func (d *FakeDriver) setFormat(format *v4l2_format) error {
+	if format._type != d.bufType() || len(d.formats) == 0 {
+		return unix.EINVAL
+	}
+	if d.streaming || len(d.buffers) > 0 {
+		return unix.EBUSY
+	}
+
+	// Width, height and pixel format are at the same
+	// offsets in both single and multi-planar formats
+	pix := (*v4l2_pix_format)(unsafe.Pointer(&format.union.data[0]))
+
+	// Like real drivers, fall back to the first format and
+	// the closest frame size instead of failing
+	f := d.findFormat(pix.Pixelformat)
+	if f == nil {
+		f = d.formats[0]
+	}
+	width, height := fakeFitFrameSize(f.sizes, pix.Width, pix.Height)
+
+	d.pix = fakePixFormat(f.code, width, height)
+	d.planes = fakePlanes(f.code, width, height, d.pix.Sizeimage)
+	if d.multiPlanar() {
+		d.fillPixFormatMplane((*v4l2_pix_format_mplane)(unsafe.Pointer(&format.union.data[0])))
+		return nil
+	}
+	*pix = d.pix
+	return nil
+}
+
+func (d *FakeDriver) requestBuffers(req *v4l2_requestbuffers) error {
+	if req._type != d.bufType() {
+		return unix.EINVAL
+	}
+	if req.memory != V4L2_MEMORY_MMAP && req.memory != V4L2_MEMORY_USERPTR && req.memory != V4L2_MEMORY_DMABUF {
+		return unix.EINVAL
+	}
+	// Multi-planar buffers are only implemented for mmap
+	if d.multiPlanar() && req.memory != V4L2_MEMORY_MMAP {
+		return unix.EINVAL
+	}
+	if d.streaming || d.reading {
+		return unix.EBUSY
+	}
+
+	count := req.count
+	if count > fakeMaxBuffers {
+		count = fakeMaxBuffers
+	}
+	d.freeBuffers()
+	d.memory = req.memory
+	for i := uint32(0); i < count; i++ {
+		b := &fakeBuffer{memfd: -1, dmabuf: -1}
+		if d.memory == V4L2_MEMORY_MMAP {
+			if err := b.allocate(d.bufferSize()); err != nil {
+				d.freeBuffers()
+				return unix.ENOMEM
+			}
+		}
+		d.buffers = append(d.buffers, b)
+	}
+	req.count = count
+	return nil
+}
+
+func (b *fakeBuffer) allocate(size int) (err error) {
+	b.memfd, err = unix.MemfdCreate("fake-v4l2-buffer", unix.MFD_CLOEXEC)
+	if err != nil {
+		return
+	}
+	if size == 0 {
+		size = 1
+	}
+	if err = unix.Ftruncate(b.memfd, int64(size)); err != nil {
+		return
+	}
+	b.data, err = unix.Mmap(b.memfd, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	return
+}
+
+func (b *fakeBuffer) free() {
+	if b.memfd >= 0 || b.dmabuf >= 0 {
+		if b.data != nil {
+			unix.Munmap(b.data)
+		}
+		if b.memfd >= 0 {
+			unix.Close(b.memfd)
+		}
+	}
+	b.data = nil
+	b.memfd = -1
+	b.dmabuf = -1
+}
+
+func (d *FakeDriver) freeBuffers() {
+	for _, b := range d.buffers {
+		b.free()
+	}
+	d.buffers = nil
+	d.queue = nil
+	d.done = nil
+}
+
+func (d *FakeDriver) queryBuffer(buf *v4l2_buffer) error {
+	if buf._type != d.bufType() || buf.index >= uint32(len(d.buffers)) || !d.checkPlanes(buf) {
+		return unix.EINVAL
+	}
+	d.fillBuffer(buf, buf.index)
+	return nil
+}
+
+func (d *FakeDriver) enqueueBuffer(buf *v4l2_buffer) error {
+	if buf._type != d.bufType() || buf.memory != d.memory || buf.index >= uint32(len(d.buffers)) || !d.checkPlanes(buf) {
+		return unix.EINVAL
+	}
+	b := d.buffers[buf.index]
+	if b.queued || b.done {
+		return unix.EINVAL
+	}
+	if d.memory == V4L2_MEMORY_USERPTR {
+		ptr := *(*unsafe.Pointer)(unsafe.Pointer(&buf.union[0]))
+		if ptr == nil || buf.length < d.pix.Sizeimage {
+			return unix.EINVAL
+		}
+		b.data = unsafe.Slice((*byte)(ptr), buf.length)
+	}
+	if d.memory == V4L2_MEMORY_DMABUF {
+		fd := int(*(*int32)(unsafe.Pointer(&buf.union[0])))
+		if fd != b.dmabuf {
+			b.free()
+			var st unix.Stat_t
+			if err := unix.Fstat(fd, &st); err != nil || st.Size < int64(d.pix.Sizeimage) {
+				return unix.EINVAL
+			}
+			data, err := unix.Mmap(fd, 0, int(st.Size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+			if err != nil {
+				return unix.EINVAL
+			}
+			b.data = data
+			b.dmabuf = fd
+		}
+	}
+	b.queued = true
+	d.queue = append(d.queue, buf.index)
+	d.fillBuffer(buf, buf.index)
+	return nil
+}
+
+func (d *FakeDriver) dequeueBuffer(buf *v4l2_buffer) error {
+	if buf._type != d.bufType() || buf.memory != d.memory || !d.streaming || !d.checkPlanes(buf) {
+		return unix.EINVAL
+	}
+	if len(d.done) == 0 {
+		return unix.EAGAIN
+	}
+	index := d.done[0]
+	d.done = d.done[1:]
+	d.buffers[index].done = false
+	d.fillBuffer(buf, index)
+	return nil
+}
+
+func (d *FakeDriver) fillBuffer(buf *v4l2_buffer, index uint32) {
+	b := d.buffers[index]
+	buf.index = index
+	buf.memory = d.memory
+	buf.bytesused = b.bytesused
+	buf.flags = b.flags
+	buf.field = d.pix.Field
+	buf.sequence = b.sequence
+	buf.timestamp = b.timestamp
+	buf.length = uint32(len(b.data))
+	if d.multiPlanar() {
+		d.fillPlanes(buf, index)
+		return
+	}
+	switch d.memory {
+	case V4L2_MEMORY_USERPTR:
+		if len(b.data) > 0 {
+			*(*unsafe.Pointer)(unsafe.Pointer(&buf.union[0])) = unsafe.Pointer(&b.data[0])
+		}
+	case V4L2_MEMORY_DMABUF:
+		*(*int32)(unsafe.Pointer(&buf.union[0])) = int32(b.dmabuf)
+	default:
+		*(*uint32)(unsafe.Pointer(&buf.union[0])) = index * fakeOffsetStride
+	}
+}
+
+func (d *FakeDriver) exportBuffer(e *v4l2_exportbuffer) error {
+	if e._type != d.bufType() || d.memory != V4L2_MEMORY_MMAP || e.index >= uint32(len(d.buffers)) {
+		return unix.EINVAL
+	}
+	fd, err := unix.FcntlInt(uintptr(d.buffers[e.index].memfd), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	e.fd = int32(fd)
+	return nil
+}
+
+func (d *FakeDriver) streamOn(bufType uint32) error {
+	if bufType != d.bufType() || len(d.buffers) == 0 {
+		return unix.EINVAL
+	}
+	if !d.streaming {
+		d.streaming = true
+		d.sequence = 0
+	}
+	return nil
+}
+
+func (d *FakeDriver) streamOff(bufType uint32) error {
+	if bufType != d.bufType() {
+		return unix.EINVAL
+	}
+	d.streaming = false
+	d.queue = nil
+	d.done = nil
+	for _, b := range d.buffers {
+		b.queued = false
+		b.done = false
+	}
+	return nil
+}
+
+func (d *FakeDriver) getParm(param *v4l2_streamparm) error {
+	if param._type != d.bufType() {
+		return unix.EINVAL
+	}
+	param.union.time_per_frame = d.timePerFrame
+	return nil
+}
+
+func (d *FakeDriver) setParm(param *v4l2_streamparm) error {
+	if param._type != d.bufType() {
+		return unix.EINVAL
+	}
+	tf := param.union.time_per_frame
+	if tf.Numerator != 0 && tf.Denominator != 0 {
+		d.timePerFrame = tf
+	}
+	param.union.time_per_frame = d.timePerFrame
+	return nil
+}
+
+// nextControl finds the control with the id, or the
+// one after it with V4L2_CTRL_FLAG_NEXT_CTRL
+func (d *FakeDriver) nextControl(id uint32) *fakeControl {
+	if id&V4L2_CTRL_FLAG_NEXT_CTRL == 0 {
+		return d.findControl(id)
+	}
+	id &^= V4L2_CTRL_FLAG_NEXT_CTRL
+	for _, c := range d.controls {
+		if uint32(c.ID) > id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (d *FakeDriver) queryControl(query *v4l2_queryctrl) error {
+	c := d.nextControl(query.id)
+	if c == nil {
+		return unix.EINVAL
+	}
+
+	*query = v4l2_queryctrl{
+		id:            uint32(c.ID),
+		_type:         c.Type,
+		minimum:       c.Min,
+		maximum:       c.Max,
+		step:          c.Step,
+		default_value: c.Default,
+		flags:         c.Flags,
+	}
+	copy(query.name[:len(query.name)-1], c.Name)
+	return nil
+}
+
+func (d *FakeDriver) queryMenu(query *v4l2_querymenu) error {
+	c := d.findControl(query.id)
+	if c == nil || !c.hasItem(int32(query.index)) {
+		return unix.EINVAL
+	}
+
+	query.name = [32]uint8{}
+	if c.Type == V4L2_CTRL_TYPE_INTEGER_MENU {
+		NativeByteOrder.PutUint64(query.name[:8], uint64(c.IntegerMenu[query.index]))
+	} else {
+		copy(query.name[:len(query.name)-1], c.Menu[query.index])
+	}
+	return nil
+}
+
+// hasItem reports whether the menu control has an item with the index
+func (c *fakeControl) hasItem(index int32) bool {
+	if index < c.Min || index > c.Max || index < 0 {
+		return false
+	}
+	switch c.Type {
+	case V4L2_CTRL_TYPE_MENU:
+		return int(index) < len(c.Menu) && c.Menu[index] != ""
+	case V4L2_CTRL_TYPE_INTEGER_MENU:
+		return int(index) < len(c.IntegerMenu)
+	}
+	return false
+}
+
+func (d *FakeDriver) queryExtControl(query *v4l2_query_ext_ctrl) error {
+	c := d.nextControl(query.id)
+	if c == nil {
+		return unix.EINVAL
+	}
+
+	*query = v4l2_query_ext_ctrl{
+		id:            uint32(c.ID),
+		_type:         c.Type,
+		minimum:       int64(c.Min),
+		maximum:       int64(c.Max),
+		step:          uint64(c.Step),
+		default_value: int64(c.Default),
+		flags:         c.Flags,
+		elem_size:     c.elemSize(),
+		elems:         c.elems(),
+	}
+	if c.hasPayload() {
+		query.flags |= V4L2_CTRL_FLAG_HAS_PAYLOAD
+	}
+	if c.Elems > 0 {
+		query.nr_of_dims = 1
+		query.dims[0] = c.Elems
+	}
+	copy(query.name[:len(query.name)-1], c.Name)
+	return nil
+}
+
+// extControls accesses a batch of controls. All controls are validated
+// before any is set, and error_idx is set the same way as by the V4L2
+// control framework.
+func (d *FakeDriver) extControls(op uintptr, req *v4l2_ext_controls) error {
+	req.error_idx = req.count
+	if req.which != V4L2_CTRL_WHICH_CUR_VAL {
+		return unix.EINVAL
+	}
+	if req.count == 0 {
+		return nil
+	}
+
+	ctrls := unsafe.Slice((*v4l2_ext_control)(req.controls), req.count)
+	values := make([]fakeValue, len(ctrls))
+	for i := range ctrls {
+		err := d.checkExtControl(&ctrls[i], op == VIDIOC_G_EXT_CTRLS, &values[i])
+
+		if err != nil {
+			if op != VIDIOC_S_EXT_CTRLS {
+				req.error_idx = uint32(i)
+			}
+			return err
+		}
+	}
+
+	for i := range ctrls {
+		c := d.findControl(ctrls[i].id)
+		if op == VIDIOC_S_EXT_CTRLS {
+			if err := d.controlFailure(c); err != nil {
+				req.error_idx = uint32(i)
+				return err
+			}
+			d.storeControl(c, values[i], true)
+		}
+		c.writeExtControl(&ctrls[i], &values[i], op == VIDIOC_G_EXT_CTRLS)
+	}
+	return nil
+}
+
+// checkExtControl validates a control of a batch and returns its value,
+// the current one for getting or the one to set otherwise
+func (d *FakeDriver) checkExtControl(ctrl *v4l2_ext_control, get bool, v *fakeValue) error {
+	c := d.findControl(ctrl.id)
+	if c == nil {
+		return unix.EINVAL
+	}
+
+	size := c.elemSize() * c.elems()
+	if get {
+		if c.Flags&V4L2_CTRL_FLAG_WRITE_ONLY != 0 {
+			return unix.EACCES
+		}
+		if c.hasPayload() && ctrl.size < size {
+			ctrl.size = size
+			return unix.ENOSPC
+		}
+		*v = c.fakeValue
+		return nil
+	}
+
+	if c.Flags&V4L2_CTRL_FLAG_READ_ONLY != 0 {
+		return unix.EACCES
+	}
+
+	switch {
+	case c.Type == V4L2_CTRL_TYPE_STRING:
+		data := ctrl.data()
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			data = data[:i]
+		}
+		if len(data) < int(c.Min) || len(data) > int(c.Max) {
+			return unix.ERANGE
+		}
+		v.str = string(data)
+	case c.hasPayload():
+		if ctrl.size < size {
+			return unix.EFAULT
+		}
+		v.payload = append([]byte(nil), ctrl.data()[:size]...)
+	case c.Type == V4L2_CTRL_TYPE_INTEGER64:
+		value, err := c.validate(int64(NativeByteOrder.Uint64(ctrl.union[:])))
+		if err != nil {
+			return err
+		}
+		v.value = value
+	default:
+		value, err := c.validate(int64(int32(NativeByteOrder.Uint32(ctrl.union[:4]))))
+		if err != nil {
+			return err
+		}
+		v.value = value
+	}
+	return nil
+}
+
+// writeExtControl returns the value of a control in a batch. Strings and
+// payloads are only written when getting, values are adjusted otherwise.
+func (c *fakeControl) writeExtControl(ctrl *v4l2_ext_control, v *fakeValue, get bool) {
+	switch {
+	case c.Type == V4L2_CTRL_TYPE_STRING:
+		if get {
+			data := ctrl.data()
+			data[copy(data[:len(data)-1], v.str)] = 0
+		}
+	case c.hasPayload():
+		if get {
+			copy(ctrl.data(), v.payload)
+		}
+	case c.Type == V4L2_CTRL_TYPE_INTEGER64:
+		NativeByteOrder.PutUint64(ctrl.union[:], uint64(v.value))
+	default:
+		NativeByteOrder.PutUint32(ctrl.union[:4], uint32(int32(v.value)))
+	}
+}
+
+// storeControl changes the value of a control. Subscribers are notified of
+// changes made by the client only with V4L2_EVENT_SUB_FL_ALLOW_FEEDBACK.
+func (d *FakeDriver) storeControl(c *fakeControl, v fakeValue, client bool) {
+	changed := c.value != v.value || c.str != v.str || !bytes.Equal(c.payload, v.payload)
+	c.fakeValue = v
+
+	if changed || c.Flags&V4L2_CTRL_FLAG_EXECUTE_ON_WRITE != 0 {
+		flags, ok := d.subscriptions[uint32(c.ID)]
+		if ok && (!client || flags&V4L2_EVENT_SUB_FL_ALLOW_FEEDBACK != 0) {
+			d.queueControlEvent(c, V4L2_EVENT_CTRL_CH_VALUE)
+		}
+	}
+}
+
+// queueControlEvent queues an event of a subscribed control. A pending
+// event of the control is merged into the new one, like the V4L2 control
+// framework does with its single event per subscription.
+func (d *FakeDriver) queueControlEvent(c *fakeControl, changes uint32) {
+	if _, ok := d.subscriptions[uint32(c.ID)]; !ok {
+		return
+	}
+
+	for i := range d.events {
+		if d.events[i].id == uint32(c.ID) {
+			pending := (*v4l2_event_ctrl)(unsafe.Pointer(&d.events[i].union[0]))
+			changes |= pending.changes
+			d.events = append(d.events[:i], d.events[i+1:]...)
+			break
+		}
+	}
+
+	event := v4l2_event{
+		_type:    V4L2_EVENT_CTRL,
+		id:       uint32(c.ID),
+		sequence: d.eventSequence,
+	}
+	d.eventSequence++
+	unix.ClockGettime(unix.CLOCK_MONOTONIC, &event.timestamp)
+
+	ctrl := (*v4l2_event_ctrl)(unsafe.Pointer(&event.union[0]))
+	*ctrl = v4l2_event_ctrl{
+		changes:       changes,
+		_type:         c.Type,
+		flags:         c.Flags,
+		minimum:       c.Min,
+		maximum:       c.Max,
+		step:          c.Step,
+		default_value: c.Default,
+	}
+	if c.Type == V4L2_CTRL_TYPE_INTEGER64 {
+		NativeByteOrder.PutUint64(ctrl.union[:], uint64(c.value))
+	} else {
+		NativeByteOrder.PutUint32(ctrl.union[:4], uint32(int32(c.value)))
+	}
+	d.events = append(d.events, event)
+}
+
+func (d *FakeDriver) subscribeEvent(sub *v4l2_event_subscription) error {
+	c := d.findControl(sub.id)
+	if sub._type != V4L2_EVENT_CTRL || c == nil {
+		return unix.EINVAL
+	}
+	if _, ok := d.subscriptions[sub.id]; ok {
+		return nil
+	}
+	d.subscriptions[sub.id] = sub.flags
+
+	if sub.flags&V4L2_EVENT_SUB_FL_SEND_INITIAL != 0 {
+		changes := V4L2_EVENT_CTRL_CH_FLAGS
+		if c.Flags&V4L2_CTRL_FLAG_WRITE_ONLY == 0 {
+			changes |= V4L2_EVENT_CTRL_CH_VALUE
+		}
+		d.queueControlEvent(c, changes)
+	}
+	return nil
+}
+
+func (d *FakeDriver) unsubscribeEvent(sub *v4l2_event_subscription) error {
+	if sub._type == V4L2_EVENT_ALL {
+		d.subscriptions = make(map[uint32]uint32)
+		d.events = nil
+		return nil
+	}
+	delete(d.subscriptions, sub.id)
+
+	events := d.events[:0]
+	for _, e := range d.events {
+		if e.id != sub.id {
+			events = append(events, e)
+		}
+	}
+	d.events = events
+	return nil
+}
+
+func (d *FakeDriver) dequeueEvent(event *v4l2_event) error {
+	if len(d.events) == 0 {
+		return unix.ENOENT
+	}
+	*event = d.events[0]
+	d.events = d.events[1:]
+	event.pending = uint32(len(d.events))
+	return nil
+}
+
+// controlFailure returns the next error injected for writing the control
+func (d *FakeDriver) controlFailure(c *fakeControl) error {
+	errs := d.ctrlFails[uint32(c.ID)]
+	if len(errs) == 0 {
+		return nil
+	}
+	d.ctrlFails[uint32(c.ID)] = errs[1:]
+	return errs[0]
+}
+
+// reset sets the control to its default value. Arrays are zeroed
+// and strings are empty.
+func (c *fakeControl) reset() {
+	c.fakeValue = fakeValue{value: int64(c.Default)}
+	if c.hasPayload() && c.Type != V4L2_CTRL_TYPE_STRING {
+		c.payload = make([]byte, c.elemSize()*c.elems())
+	}
+}
+
+// hasPayload reports whether the value of the control is passed by pointer
+func (c *fakeControl) hasPayload() bool {
+	return c.Type == V4L2_CTRL_TYPE_STRING || c.Type >= V4L2_CTRL_TYPE_U8
+}
+
+func (c *fakeControl) elemSize() uint32 {
+	switch c.Type {
+	case V4L2_CTRL_TYPE_INTEGER64:
+		return 8
+	case V4L2_CTRL_TYPE_STRING:
+		return uint32(c.Max) + 1
+	case V4L2_CTRL_TYPE_U8:
+		return 1
+	case V4L2_CTRL_TYPE_U16:
+		return 2
+	}
+	return 4
+}
+
+func (c *fakeControl) elems() uint32 {
+	if c.Elems == 0 {
+		return 1
+	}
+	return c.Elems
+}
+
+func (d *FakeDriver) getControl(ctrl *v4l2_control) error {
+	c := d.findControl(ctrl.id)
+	if c == nil || c.hasPayload() || c.Type == V4L2_CTRL_TYPE_INTEGER64 {
+		return unix.EINVAL
+	}
+	if c.Flags&V4L2_CTRL_FLAG_WRITE_ONLY != 0 {
+		return unix.EACCES
+	}
+	ctrl.value = int32(c.value)
+	return nil
+}
+
+func (d *FakeDriver) setControl(ctrl *v4l2_control) error {
+	c := d.findControl(ctrl.id)
+	if c == nil || c.hasPayload() || c.Type == V4L2_CTRL_TYPE_INTEGER64 {
+		return unix.EINVAL
+	}
+	if c.Flags&V4L2_CTRL_FLAG_READ_ONLY != 0 {
+		return unix.EACCES
+	}
+
+	value, err := c.validate(int64(ctrl.value))
+	if err != nil {
+		return err
+	}
+	if err := d.controlFailure(c); err != nil {
+		return err
+	}
+	d.storeControl(c, fakeValue{value: value}, true)
+	ctrl.value = int32(value)
+	return nil
+}
+
+// validate adjusts a value the same way the V4L2 control framework does
+func (c *fakeControl) validate(value int64) (int64, error) {
+	min, max, step := int64(c.Min), int64(c.Max), int64(c.Step)
+	switch c.Type {
+	case V4L2_CTRL_TYPE_BOOLEAN:
+		if value != 0 {
+			value = 1
+		}
+	case V4L2_CTRL_TYPE_MENU, V4L2_CTRL_TYPE_INTEGER_MENU:
+		if value < min || value > max {
+			return 0, unix.ERANGE
+		}
+		if (len(c.Menu) > 0 || len(c.IntegerMenu) > 0) && !c.hasItem(int32(value)) {
+			return 0, unix.EINVAL
+		}
+	default:
+		if value < min {
+			value = min
+		}
+		if value > max {
+			value = max
+		}
+		if step > 1 {
+			value = min + (value-min+step/2)/step*step
+			if value > max {
+				value -= step
+			}
+		}
+	}
+	return value, nil
+}
+
+func (d *FakeDriver) enumFrameSizes(e *v4l2_frmsizeenum) error {
+	f := d.findFormat(e.pixel_format)
+	if f == nil || e.index >= uint32(len(f.sizes)) {
+		return unix.EINVAL
+	}
+	s := f.sizes[e.index]
+	if s.StepWidth == 0 && s.StepHeight == 0 {
+		e._type = V4L2_FRMSIZE_TYPE_DISCRETE
+		*(*v4l2_frmsize_discrete)(unsafe.Pointer(&e.union[0])) = v4l2_frmsize_discrete{
+			Width:  s.MaxWidth,
+			Height: s.MaxHeight,
+		}
+	} else {
+		e._type = V4L2_FRMSIZE_TYPE_STEPWISE
+		*(*v4l2_frmsize_stepwise)(unsafe.Pointer(&e.union[0])) = v4l2_frmsize_stepwise{
+			Min_width:   s.MinWidth,
+			Max_width:   s.MaxWidth,
+			Step_width:  s.StepWidth,
+			Min_height:  s.MinHeight,
+			Max_height:  s.MaxHeight,
+			Step_height: s.StepHeight,
+		}
+	}
+	return nil
+}
+
+func (d *FakeDriver) enumFrameIntervals(e *v4l2_frmivalenum) error {
+	f := d.findFormat(e.pixel_format)
+	if f == nil || e.index >= uint32(len(f.rates)) {
+		return unix.EINVAL
+	}
+	r := f.rates[e.index]
+	if r.StepNumerator == 0 && r.StepDenominator == 0 {
+		e._type = V4L2_FRMIVAL_TYPE_DISCRETE
+		*(*v4l2_fract)(unsafe.Pointer(&e.union[0])) = v4l2_fract{
+			Numerator:   r.MinNumerator,
+			Denominator: r.MinDenominator,
+		}
+	} else {
+		e._type = V4L2_FRMIVAL_TYPE_STEPWISE
+		*(*v4l2_frmival_stepwise)(unsafe.Pointer(&e.union[0])) = v4l2_frmival_stepwise{
+			min:  v4l2_fract{Numerator: r.MinNumerator, Denominator: r.MinDenominator},
+			max:  v4l2_fract{Numerator: r.MaxNumerator, Denominator: r.MaxDenominator},
+			step: v4l2_fract{Numerator: r.StepNumerator, Denominator: r.StepDenominator},
+		}
+	}
+	return nil
+}
+
+// fakeFitFrameSize picks the supported frame size closest to the requested one
+func fakeFitFrameSize(sizes []FrameSize, width, height uint32) (uint32, uint32) {
+	if len(sizes) == 0 {
+		return width, height
+	}
+
+	fit := func(v, min, max, step uint32) uint32 {
+		if v < min {
+			v = min
+		}
+		if v > max {
+			v = max
+		}
+		if step > 0 {
+			v = min + (v-min)/step*step
+		}
+		return v
+	}
+	diff := func(a, b uint32) uint32 {
+		if a > b {
+			return a - b
+		}
+		return b - a
+	}
+
+	bestWidth, bestHeight := sizes[0].MaxWidth, sizes[0].MaxHeight
+	best := ^uint32(0)
+	for _, s := range sizes {
+		w := fit(width, s.MinWidth, s.MaxWidth, s.StepWidth)
+		h := fit(height, s.MinHeight, s.MaxHeight, s.StepHeight)
+		if d := diff(w, width) + diff(h, height); d < best {
+			best = d
+			bestWidth, bestHeight = w, h
+		}
+	}
+	return bestWidth, bestHeight
+}
+
+// fakePixFormat fills in line and image sizes for a few common formats.
+// Unknown and compressed formats are given two bytes per pixel.
+func fakePixFormat(code, width, height uint32) v4l2_pix_format {
+	pix := v4l2_pix_format{
+		Width:        width,
+		Height:       height,
+		Pixelformat:  code,
+		Field:        V4L2_FIELD_NONE,
+		Bytesperline: width * 2,
+		Sizeimage:    width * height * 2,
+	}
+	switch PixelFormat(code) {
+	case V4L2_PIX_FMT_GREY:
+		pix.Bytesperline = width
+		pix.Sizeimage = width * height
+	case V4L2_PIX_FMT_RGB24, V4L2_PIX_FMT_BGR24:
+		pix.Bytesperline = width * 3
+		pix.Sizeimage = width * height * 3
+	case V4L2_PIX_FMT_NV12, V4L2_PIX_FMT_NV21, V4L2_PIX_FMT_YUV420, V4L2_PIX_FMT_YVU420,
+		V4L2_PIX_FMT_NV12M, V4L2_PIX_FMT_YUV420M:
+		pix.Bytesperline = width
+		pix.Sizeimage = width * height * 3 / 2
+	case V4L2_PIX_FMT_MJPEG, V4L2_PIX_FMT_JPEG, V4L2_PIX_FMT_H264:
+		pix.Bytesperline = 0
+	}
+	return pix
+}
+
+// fakePlanes lays out planes of a multi-planar format in a buffer.
+// Formats with a single memory plane have one plane of sizeimage bytes.
+func fakePlanes(code, width, height, sizeimage uint32) []fakePlane {
+	var sizes []uint32
+	switch PixelFormat(code) {
+	case V4L2_PIX_FMT_NV12M:
+		sizes = []uint32{width * height, width * height / 2}
+	case V4L2_PIX_FMT_YUV420M:
+		sizes = []uint32{width * height, width * height / 4, width * height / 4}
+	default:
+		sizes = []uint32{sizeimage}
+	}
+
+	page := uint32(unix.Getpagesize())
+	planes := make([]fakePlane, 0, len(sizes))
+	var offset uint32
+	for _, size := range sizes {
+		planes = append(planes, fakePlane{offset: offset, size: size})
+		offset += (size + page - 1) / page * page
+	}
+	return planes
+}
+
+func (d *FakeDriver) fillPixFormatMplane(pix *v4l2_pix_format_mplane) {
+	*pix = v4l2_pix_format_mplane{
+		Width:       d.pix.Width,
+		Height:      d.pix.Height,
+		Pixelformat: d.pix.Pixelformat,
+		Field:       d.pix.Field,
+		Num_planes:  uint8(len(d.planes)),
+	}
+	for i, p := range d.planes {
+		pix.Plane_fmt[i].Sizeimage = p.size
+		pix.Plane_fmt[i].Bytesperline = d.pix.Bytesperline
+		if i > 0 && PixelFormat(d.pix.Pixelformat) == V4L2_PIX_FMT_YUV420M {
+			pix.Plane_fmt[i].Bytesperline = d.pix.Bytesperline / 2
+		}
+	}
+}
+
+// bufferSize returns the size of memory backing a buffer
+func (d *FakeDriver) bufferSize() int {
+	if n := len(d.planes); n > 0 && d.multiPlanar() {
+		return int(d.planes[n-1].offset + d.planes[n-1].size)
+	}
+	return int(d.pix.Sizeimage)
+}
+
+// planeStart reports whether a buffer may be mapped from the offset
+func (d *FakeDriver) planeStart(offset int64) bool {
+	if !d.multiPlanar() {
+		return offset == 0
+	}
+	for _, p := range d.planes {
+		if int64(p.offset) == offset {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPlanes reports whether a multi-planar buffer
+// points to an array large enough for all the planes
+func (d *FakeDriver) checkPlanes(buf *v4l2_buffer) bool {
+	if !d.multiPlanar() {
+		return true
+	}
+	ptr := *(*unsafe.Pointer)(unsafe.Pointer(&buf.union[0]))
+	return ptr != nil && buf.length >= uint32(len(d.planes))
+}
+
+// copyFrame copies a frame into a buffer, filling its planes one after
+// another, and returns the number of bytes copied
+func (d *FakeDriver) copyFrame(b *fakeBuffer, data []byte) uint32 {
+	if !d.multiPlanar() {
+		return uint32(copy(b.data, data))
+	}
+	var n int
+	for _, p := range d.planes {
+		n += copy(b.data[p.offset:p.offset+p.size], data[n:])
+	}
+	return uint32(n)
+}
+
+func (d *FakeDriver) fillPlanes(buf *v4l2_buffer, index uint32) {
+	b := d.buffers[index]
+	ptr := *(*unsafe.Pointer)(unsafe.Pointer(&buf.union[0]))
+	planes := unsafe.Slice((*v4l2_plane)(ptr), len(d.planes))
+	buf.length = uint32(len(d.planes))
+	buf.bytesused = 0
+
+	remaining := b.bytesused
+	for i, p := range d.planes {
+		used := remaining
+		if used > p.size {
+			used = p.size
+		}
+		remaining -= used
+		planes[i] = v4l2_plane{
+			bytesused: used,
+			length:    p.size,
+		}
+		*(*uint32)(unsafe.Pointer(&planes[i].m[0])) = index*fakeOffsetStride + p.offset
+	}
+}
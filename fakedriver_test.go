@@ -0,0 +1,242 @@
+package webcam
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Controls of the test driver
+const (
+	testBrightness = ControlID(V4L2_CID_BRIGHTNESS)
+	testContrast   = ControlID(V4L2_CID_CONTRAST)
+)
+
+var testFrameSize = FrameSize{MinWidth: 640, MaxWidth: 640, MinHeight: 480, MaxHeight: 480}
+
+// newTestDriver returns a fake driver with a YUYV and a GREY format
+// and a brightness control
+func newTestDriver() *FakeDriver {
+	d := NewFakeDriver()
+	d.AddFormat(V4L2_PIX_FMT_YUYV, "YUYV 4:2:2", testFrameSize)
+	d.AddFormat(V4L2_PIX_FMT_GREY, "8-bit Greyscale", testFrameSize,
+		FrameSize{MinWidth: 160, MaxWidth: 1280, StepWidth: 16, MinHeight: 120, MaxHeight: 720, StepHeight: 8})
+	d.SetFrameRates(V4L2_PIX_FMT_YUYV,
+		FrameRate{MinNumerator: 1, MaxNumerator: 1, MinDenominator: 30, MaxDenominator: 30},
+		FrameRate{MinNumerator: 1, MaxNumerator: 1, MinDenominator: 15, MaxDenominator: 15})
+	d.AddControl(FakeControl{ID: testBrightness, Name: "Brightness", Type: V4L2_CTRL_TYPE_INTEGER, Min: -64, Max: 64, Step: 1})
+	return d
+}
+
+// openTestDriver opens a webcam on the driver,
+// which is closed when the test ends
+func openTestDriver(t *testing.T, d *FakeDriver) *Webcam {
+	t.Helper()
+
+	w, err := OpenFake(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+// startTestStreaming starts streaming with count buffers
+func startTestStreaming(t *testing.T, w *Webcam, count uint32) {
+	t.Helper()
+
+	if err := w.SetBufferCount(count); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.StartStreaming(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pushTestFrame pushes a frame and waits until it can be read
+func pushTestFrame(t *testing.T, d *FakeDriver, w *Webcam, data string) {
+	t.Helper()
+
+	if err := d.PushFrame([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WaitForFrame(1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFakeFormats(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+
+	formats := w.GetSupportedFormats()
+	if len(formats) != 2 || formats[V4L2_PIX_FMT_YUYV] != "YUYV 4:2:2" {
+		t.Fatalf("formats = %v", formats)
+	}
+
+	sizes := w.GetSupportedFrameSizes(V4L2_PIX_FMT_GREY)
+	if len(sizes) != 2 || sizes[1].StepWidth != 16 {
+		t.Fatalf("frame sizes = %v", sizes)
+	}
+
+	rates := w.GetSupportedFramerates(V4L2_PIX_FMT_YUYV, 640, 480)
+	if len(rates) != 2 || rates[1].String() != "1/15" {
+		t.Fatalf("frame rates = %v", rates)
+	}
+
+	// The driver adjusts the size to the step of the closest size
+	f, _, _, err := w.SetImageFormat(V4L2_PIX_FMT_GREY, 333, 222)
+	if err != nil || f != V4L2_PIX_FMT_GREY {
+		t.Fatalf("SetImageFormat = %v, %v", f, err)
+	}
+	if code, width, height := d.Format(); code != V4L2_PIX_FMT_GREY || width != 320 || height != 216 {
+		t.Errorf("driver format = %v %dx%d, want GREY 320x216", code, width, height)
+	}
+
+	if err := w.SetFramerate(15); err != nil {
+		t.Fatal(err)
+	}
+	if fps, err := w.GetFramerate(); err != nil || fps != 15 {
+		t.Errorf("GetFramerate = %v, %v", fps, err)
+	}
+}
+
+func TestFakeControls(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+
+	controls := w.GetControls()
+	if c, ok := controls[testBrightness]; !ok || c.Name != "Brightness" || c.Min != -64 || c.Max != 64 {
+		t.Fatalf("controls = %v", controls)
+	}
+
+	if err := w.SetControl(testBrightness, 10); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := w.GetControl(testBrightness); err != nil || value != 10 {
+		t.Errorf("GetControl = %d, %v", value, err)
+	}
+	if value, _ := d.ControlValue(testBrightness); value != 10 {
+		t.Errorf("driver value = %d", value)
+	}
+
+	if err := w.SetControl(testContrast, 1); !errors.Is(err, unix.EINVAL) {
+		t.Errorf("SetControl of a missing control = %v, want EINVAL", err)
+	}
+}
+
+func TestFakeStreaming(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 2)
+
+	if !d.Streaming() {
+		t.Fatal("driver is not streaming")
+	}
+
+	var timeout *Timeout
+	if err := w.WaitForFrame(0); !errors.As(err, &timeout) {
+		t.Fatalf("WaitForFrame without frames = %v, want timeout", err)
+	}
+
+	for _, data := range []string{"first", "second", "third"} {
+		pushTestFrame(t, d, w, data)
+		frame, err := w.ReadFrame()
+		if err != nil || string(frame) != data {
+			t.Fatalf("ReadFrame = %q, %v, want %q", frame, err, data)
+		}
+	}
+
+	// Frames are dropped when no buffer is queued
+	if _, _, err := w.GetFrame(); !errors.Is(err, unix.EAGAIN) {
+		t.Fatalf("GetFrame without frames = %v, want EAGAIN", err)
+	}
+	d.PushFrame([]byte("a"))
+	d.PushFrame([]byte("b"))
+	if err := d.PushFrame([]byte("c")); err == nil {
+		t.Error("PushFrame without queued buffers succeeded")
+	}
+
+	if err := w.StopStreaming(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Streaming() {
+		t.Error("driver is streaming after StopStreaming")
+	}
+}
+
+func TestFakeInjectError(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 2)
+
+	d.InjectError(VIDIOC_DQBUF, unix.EIO)
+	pushTestFrame(t, d, w, "frame")
+
+	_, err := w.ReadFrame()
+	var ioctlErr *IoctlError
+	if !errors.As(err, &ioctlErr) || ioctlErr.Op != "VIDIOC_DQBUF" || ioctlErr.Errno != unix.EIO {
+		t.Fatalf("ReadFrame = %v, want VIDIOC_DQBUF EIO", err)
+	}
+
+	// Errors are returned once
+	if frame, err := w.ReadFrame(); err != nil || string(frame) != "frame" {
+		t.Fatalf("ReadFrame = %q, %v", frame, err)
+	}
+}
+
+func TestFakeUnplug(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 2)
+
+	d.Unplug()
+
+	if err := w.WaitForFrame(1); !errors.Is(err, ErrDisconnected) && err != nil {
+		t.Fatalf("WaitForFrame = %v", err)
+	}
+	if _, err := w.GetControl(testBrightness); !errors.Is(err, ErrDisconnected) {
+		t.Fatalf("GetControl = %v, want ErrDisconnected", err)
+	}
+	if err := d.PushFrame([]byte("frame")); err != unix.ENODEV {
+		t.Fatalf("PushFrame = %v, want ENODEV", err)
+	}
+}
+
+func TestFakeReplug(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	if err := w.SetControl(testBrightness, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Unplug()
+	if _, err := OpenFake(d); !errors.Is(err, unix.ENOENT) {
+		t.Fatalf("OpenFake of unplugged device = %v, want ENOENT", err)
+	}
+
+	// The device comes back with default settings once closed
+	d.Replug()
+	w.Close()
+	w = openTestDriver(t, d)
+	if value, err := w.GetControl(testBrightness); err != nil || value != 0 {
+		t.Fatalf("GetControl after replug = %d, %v", value, err)
+	}
+}
+
+func TestFakeWaitTimeout(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 2)
+
+	start := time.Now()
+	var timeout *Timeout
+	if err := w.WaitForFrame(1); !errors.As(err, &timeout) {
+		t.Fatalf("WaitForFrame = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 2*time.Second {
+		t.Errorf("WaitForFrame returned after %v, want a second", elapsed)
+	}
+}
@@ -9,6 +9,24 @@ import "fmt"
 // of supported image formats
 type PixelFormat uint32
 
+// Some of the commonly used image formats
+const (
+	V4L2_PIX_FMT_GREY    PixelFormat = 0x59455247 // 'GREY'
+	V4L2_PIX_FMT_RGB24   PixelFormat = 0x33424752 // 'RGB3'
+	V4L2_PIX_FMT_BGR24   PixelFormat = 0x33524742 // 'BGR3'
+	V4L2_PIX_FMT_YUYV    PixelFormat = 0x56595559 // 'YUYV'
+	V4L2_PIX_FMT_UYVY    PixelFormat = 0x59565955 // 'UYVY'
+	V4L2_PIX_FMT_NV12    PixelFormat = 0x3231564e // 'NV12'
+	V4L2_PIX_FMT_NV21    PixelFormat = 0x3132564e // 'NV21'
+	V4L2_PIX_FMT_YUV420  PixelFormat = 0x32315559 // 'YU12'
+	V4L2_PIX_FMT_YVU420  PixelFormat = 0x32315659 // 'YV12'
+	V4L2_PIX_FMT_NV12M   PixelFormat = 0x32314d4e // 'NM12', multi-planar
+	V4L2_PIX_FMT_YUV420M PixelFormat = 0x32314d59 // 'YM12', multi-planar
+	V4L2_PIX_FMT_MJPEG   PixelFormat = 0x47504a4d // 'MJPG'
+	V4L2_PIX_FMT_JPEG    PixelFormat = 0x4745504a // 'JPEG'
+	V4L2_PIX_FMT_H264    PixelFormat = 0x34363248 // 'H264'
+)
+
 // Struct that describes frame size supported by a webcam
 // For fixed sizes min and max values will be the same and
 // step value will be equal to '0'
@@ -0,0 +1,271 @@
+package webcam
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Frame is a captured frame alongside with the metadata
+// the driver reported for it
+type Frame struct {
+	// Frame content. For mmap'ed buffers it is only valid
+	// until the frame is released.
+	Data []byte
+
+	// Planes of a multi-planar frame, see Webcam.IsMultiPlanar.
+	// Data is the first of them. Frames of single-planar
+	// devices have one plane equal to Data.
+	Planes [][]byte
+
+	// Index of the buffer holding the frame
+	Index uint32
+
+	// DMABUF file descriptor of the buffer holding the frame,
+	// or -1 if the buffer is neither exported nor imported
+	DMABUF int
+
+	// Frame counter maintained by the driver. Gaps in
+	// the sequence mean dropped frames.
+	Sequence uint32
+
+	// V4L2_BUF_FLAG_* flags of the buffer
+	Flags uint32
+
+	// V4L2_FIELD_* value telling which field(s) the frame contains
+	Field uint32
+
+	// Timestamp as reported by the driver. Its clock is
+	// defined by the timestamp type, see TimestampType.
+	Timestamp time.Duration
+
+	// Wall clock time of the capture. It is zero if the timestamp
+	// was copied from an output buffer and has no fixed clock.
+	Time time.Time
+
+	// Reference to the buffer, nil if the frame doesn't refer to one
+	ref *frameRef
+}
+
+// frameRef counts references to the buffer holding a frame
+type frameRef struct {
+	w     *Webcam
+	index uint32
+
+	mu    sync.Mutex
+	count int
+
+	// Set by cameras wrapping the webcam, like ReconnectingCamera,
+	// to return the buffer through them
+	release func() error
+
+	// Set in frame debug mode
+	report   func(error)
+	poison   bool
+	obtained []byte
+	released []byte
+}
+
+func newFrame(data []byte, buffer *v4l2_buffer) *Frame {
+	f := &Frame{
+		Data:      data,
+		Planes:    [][]byte{data},
+		Index:     buffer.index,
+		DMABUF:    -1,
+		Sequence:  buffer.sequence,
+		Flags:     buffer.flags,
+		Field:     buffer.field,
+		Timestamp: time.Duration(buffer.timestamp.Nano()),
+	}
+
+	switch f.TimestampType() {
+	case V4L2_BUF_FLAG_TIMESTAMP_MONOTONIC:
+		var now unix.Timespec
+		if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &now); err == nil {
+			f.Time = time.Now().Add(f.Timestamp - time.Duration(now.Nano()))
+		}
+	case V4L2_BUF_FLAG_TIMESTAMP_UNKNOWN:
+		// Drivers which don't tell the clock use the wall clock
+		f.Time = time.Unix(0, int64(f.Timestamp))
+	}
+
+	return f
+}
+
+// TimestampType returns one of V4L2_BUF_FLAG_TIMESTAMP_* values
+func (f *Frame) TimestampType() uint32 {
+	return f.Flags & V4L2_BUF_FLAG_TIMESTAMP_MASK
+}
+
+// TimestampSource returns V4L2_BUF_FLAG_TSTAMP_SRC_EOF if the timestamp was
+// taken at the end of the frame, or V4L2_BUF_FLAG_TSTAMP_SRC_SOE if it was
+// taken at the start of exposure
+func (f *Frame) TimestampSource() uint32 {
+	return f.Flags & V4L2_BUF_FLAG_TSTAMP_SRC_MASK
+}
+
+// IsError reports whether the driver flagged the frame as corrupted.
+// The data may still be usable, but is likely to contain artifacts.
+func (f *Frame) IsError() bool {
+	return f.Flags&V4L2_BUF_FLAG_ERROR != 0
+}
+
+// IsKeyFrame reports whether the frame is a key frame
+// of a compressed stream
+func (f *Frame) IsKeyFrame() bool {
+	return f.Flags&V4L2_BUF_FLAG_KEYFRAME != 0
+}
+
+// Retain adds a reference to the frame, so that it can be shared, e.g.
+// by several consumers. Each reference is dropped with Release. It panics
+// if the frame was already released.
+func (f *Frame) Retain() *Frame {
+	if r := f.ref; r != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.count <= 0 {
+			panic(r.usedAfterRelease("Retain of a released frame"))
+		}
+		r.count++
+	}
+	return f
+}
+
+// Release drops a reference to the frame. When the last one is released,
+// the buffer is returned to the driver and the frame must not be used
+// anymore. Frames which don't refer to a buffer, like copies made by
+// Stream, need no releasing.
+func (f *Frame) Release() error {
+	r := f.ref
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	if r.count <= 0 {
+		r.mu.Unlock()
+		return &stateError{r.usedAfterRelease("Frame is already released"), ErrFrameReleased}
+	}
+	r.count--
+	last := r.count == 0
+	if last && r.report != nil {
+		r.released = debug.Stack()
+	}
+	r.mu.Unlock()
+
+	if !last {
+		return nil
+	}
+
+	if r.report != nil {
+		if r.poison {
+			for _, plane := range f.Planes {
+				for i := range plane {
+					plane[i] = 0xdb
+				}
+			}
+		}
+		f.Data = nil
+		f.Planes = nil
+	}
+
+	return r.returnBuffer()
+}
+
+// returnBuffer returns the buffer once the last reference was dropped
+func (r *frameRef) returnBuffer() error {
+	if r.release != nil {
+		return r.release()
+	}
+	return r.w.releaseRef(r)
+}
+
+// drop marks the frame released when its buffer is
+// released with Webcam.ReleaseFrame
+func (r *frameRef) drop() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.count > 0 && r.report != nil {
+		r.released = debug.Stack()
+	}
+	r.count = 0
+}
+
+func (r *frameRef) usedAfterRelease(msg string) string {
+	if r.released == nil {
+		return msg
+	}
+	return msg + ", released at:\n" + string(r.released)
+}
+
+// leaked is the finalizer of frames in debug mode
+func (f *Frame) leaked() {
+	r := f.ref
+
+	r.mu.Lock()
+	held := r.count > 0
+	r.count = 0
+	r.mu.Unlock()
+
+	if held {
+		r.report(&FrameLeakError{Index: f.Index, Sequence: f.Sequence, Stack: string(r.obtained)})
+		r.returnBuffer()
+	}
+}
+
+// hold records that the caller holds the buffer of the frame. Leaks
+// are only tracked for frames returned to the caller, see getFrame.
+func (w *Webcam) hold(frame *Frame, returned bool) {
+	r := &frameRef{w: w, index: frame.Index, count: 1}
+	w.held[frame.Index] = r
+	frame.ref = r
+
+	if w.frameDebug != nil {
+		r.report = w.frameDebug
+		// Imported DMABUFs are mapped read-only
+		r.poison = w.ioMethod != IOMethodDMABUF
+		r.obtained = debug.Stack()
+		if returned {
+			runtime.SetFinalizer(frame, (*Frame).leaked)
+		}
+	}
+}
+
+// releaseRef returns the buffer of a frame whose last reference was dropped
+func (w *Webcam) releaseRef(r *frameRef) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if int(r.index) < len(w.held) && w.held[r.index] == r {
+		return w.enqueue(r.index)
+	}
+
+	// Buffers released with ReleaseFrame are not found
+	_, err := w.releaseOrphan(func(o *orphan) bool {
+		return o.ref == r
+	})
+	return err
+}
+
+// SetFrameDebug enables checks of frame references, which are useful in
+// development but slow capture down. Releasing a frame poisons its buffer
+// and clears Data and Planes, so that use after release is noticeable,
+// and retaining or releasing it again reports where it was released.
+// Frames garbage collected without being released are returned to the
+// driver and reported as FrameLeakError to the function, which is called
+// from another goroutine. Nil report disables the checks.
+func (w *Webcam) SetFrameDebug(report func(error)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.frameDebug = report
+}
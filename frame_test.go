@@ -0,0 +1,170 @@
+package webcam
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestFrameRetainRelease(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 2)
+
+	pushTestFrame(t, d, w, "shared")
+	frame, err := w.GetFrameWithMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame.Retain()
+	if err := frame.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The buffer is still held, so the driver has one to capture into
+	d.PushFrame([]byte("next"))
+	if err := d.PushFrame([]byte("dropped")); err == nil {
+		t.Fatal("buffer was returned before the last reference was released")
+	}
+
+	if err := frame.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := frame.Release(); !errors.Is(err, ErrFrameReleased) {
+		t.Fatalf("second Release = %v, want ErrFrameReleased", err)
+	}
+	if err := d.PushFrame([]byte("captured")); err != nil {
+		t.Fatalf("buffer was not returned: %v", err)
+	}
+}
+
+func TestFrameDebugPoison(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	w.SetFrameDebug(func(err error) { t.Error(err) })
+	startTestStreaming(t, w, 2)
+
+	pushTestFrame(t, d, w, "poisoned")
+	frame, err := w.GetFrameWithMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := frame.Data
+
+	if err := frame.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Data != nil || string(data) == "poisoned" {
+		t.Errorf("released frame is still readable: %q", data)
+	}
+}
+
+func TestFrameDebugLeak(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	leaks := make(chan error, 1)
+	w.SetFrameDebug(func(err error) { leaks <- err })
+	startTestStreaming(t, w, 2)
+
+	pushTestFrame(t, d, w, "leaked")
+	if _, err := w.GetFrameWithMetadata(); err != nil {
+		t.Fatal(err)
+	}
+
+	var leak *FrameLeakError
+	select {
+	case err := <-waitForLeak(leaks):
+		if !errors.As(err, &leak) || leak.Index != 0 {
+			t.Fatalf("reported %v, want FrameLeakError", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("leak was not reported")
+	}
+
+	// The leaked buffer is returned to the driver
+	d.PushFrame([]byte("a"))
+	if err := d.PushFrame([]byte("b")); err != nil {
+		t.Fatalf("leaked buffer was not returned: %v", err)
+	}
+}
+
+// Frames of the index API have no handle the caller could drop,
+// so they must not be reported as leaked
+func TestFrameDebugIndexAPI(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	leaks := make(chan error, 1)
+	w.SetFrameDebug(func(err error) { leaks <- err })
+	startTestStreaming(t, w, 2)
+
+	pushTestFrame(t, d, w, "held")
+	data, index, err := w.GetFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-waitForLeak(leaks):
+		t.Fatalf("frame held through the index API reported: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if string(data) != "held" {
+		t.Errorf("frame = %q", data)
+	}
+	if err := w.ReleaseFrame(index); err != nil {
+		t.Fatalf("ReleaseFrame = %v", err)
+	}
+}
+
+// waitForLeak runs the garbage collector until a leak is reported
+func waitForLeak(leaks <-chan error) <-chan error {
+	reported := make(chan error, 1)
+	go func() {
+		for i := 0; i < 50; i++ {
+			runtime.GC()
+			select {
+			case err := <-leaks:
+				reported <- err
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	return reported
+}
+
+func TestFrameMetadata(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 2)
+
+	for i, flags := range []uint32{V4L2_BUF_FLAG_KEYFRAME, V4L2_BUF_FLAG_ERROR} {
+		if err := d.PushFrameFlags([]byte("frame"), flags); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WaitForFrame(1); err != nil {
+			t.Fatal(err)
+		}
+		frame, err := w.GetFrameWithMetadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if frame.Sequence != uint32(i) {
+			t.Errorf("sequence = %d, want %d", frame.Sequence, i)
+		}
+		if frame.IsKeyFrame() != (i == 0) || frame.IsError() != (i == 1) {
+			t.Errorf("flags = %#x", frame.Flags)
+		}
+		if frame.TimestampType() != V4L2_BUF_FLAG_TIMESTAMP_MONOTONIC || frame.Timestamp <= 0 {
+			t.Errorf("timestamp %v of type %#x", frame.Timestamp, frame.TimestampType())
+		}
+		if since := time.Since(frame.Time); since < 0 || since > time.Second {
+			t.Errorf("frame captured %v ago", since)
+		}
+		frame.Release()
+	}
+}
@@ -0,0 +1,332 @@
+package webcam
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// HotplugAction tells what happened to a device
+type HotplugAction int
+
+const (
+	// The device node appeared, e.g. a camera was plugged in
+	DeviceAdded HotplugAction = iota
+
+	// The device node is gone, e.g. a camera was unplugged
+	DeviceRemoved
+)
+
+func (a HotplugAction) String() string {
+	switch a {
+	case DeviceAdded:
+		return "add"
+	case DeviceRemoved:
+		return "remove"
+	}
+	return "unknown"
+}
+
+// HotplugEvent is sent by Watcher when a video device node
+// is added or removed
+type HotplugEvent struct {
+	Action HotplugAction
+
+	// Description of the node as returned by Discover. For removed
+	// devices it is the description from the time they were added.
+	Device DeviceInfo
+}
+
+// Size of the uevent messages buffer
+const ueventBufferSize = 16 * 1024
+
+// Netlink group udev rebroadcasts kernel uevents to after its rules ran
+const udevGroup = 2
+
+// Watcher reports video4linux device nodes being added and removed.
+// It listens to uevents udev sends over netlink once it created the
+// links and set the permissions of the node, so no polling of the
+// filesystem is involved.
+type Watcher struct {
+	fd       int
+	wake     int
+	scan     func() ([]DeviceInfo, error)
+	describe func(name string) DeviceInfo
+	events   chan HotplugEvent
+
+	// Devices reported so far, indexed by node name
+	devices map[string]DeviceInfo
+
+	once    sync.Once
+	done    chan struct{}
+	stopped chan struct{}
+	err     error
+}
+
+// Watch starts watching for video devices being plugged and unplugged.
+// Devices present when watching starts are reported as added first, so
+// that no device is missed between discovery and watching.
+// The Watcher must be closed when it's not needed anymore.
+func Watch() (*Watcher, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open uevent socket: %w", err)
+	}
+
+	// The kernel sends the events to group 1 before udev set the node up
+	err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: udevGroup})
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("Failed to bind uevent socket: %w", err)
+	}
+
+	// Credentials tell whether udev sent a message
+	err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_PASSCRED, 1)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("Failed to set uevent socket options: %w", err)
+	}
+
+	w, err := newWatcher(fd, Discover, describe)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return w, nil
+}
+
+// newWatcher watches uevent messages received from fd. Devices present
+// are found with scan, which returns all device nodes, and added nodes
+// are described with describe.
+func newWatcher(fd int, scan func() ([]DeviceInfo, error), describe func(name string) DeviceInfo) (*Watcher, error) {
+	wake, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		fd:       fd,
+		wake:     wake,
+		scan:     scan,
+		describe: describe,
+		events:   make(chan HotplugEvent, 16),
+		devices:  make(map[string]DeviceInfo),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Events returns the channel events are delivered on. It is closed
+// when the watcher is closed or fails, see Err.
+func (w *Watcher) Events() <-chan HotplugEvent {
+	return w.events
+}
+
+// Err returns the error watching failed with, if any. The events
+// channel is closed when watching fails.
+func (w *Watcher) Err() error {
+	select {
+	case <-w.stopped:
+		return w.err
+	default:
+		return nil
+	}
+}
+
+// Close stops watching and closes the events channel
+func (w *Watcher) Close() error {
+	w.once.Do(func() {
+		close(w.done)
+		var one = [8]byte{1}
+		unix.Write(w.wake, one[:])
+		<-w.stopped
+		unix.Close(w.wake)
+		unix.Close(w.fd)
+	})
+	return nil
+}
+
+func (w *Watcher) run() {
+	defer close(w.stopped)
+	defer close(w.events)
+
+	if !w.resync() {
+		return
+	}
+
+	buf := make([]byte, ueventBufferSize)
+	oob := make([]byte, unix.CmsgSpace(unix.SizeofUcred))
+	fds := []unix.PollFd{
+		{Fd: int32(w.fd), Events: unix.POLLIN},
+		{Fd: int32(w.wake), Events: unix.POLLIN},
+	}
+	for {
+		_, err := unix.Poll(fds, -1)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			w.err = err
+			return
+		}
+		if fds[1].Revents != 0 {
+			return
+		}
+
+		n, oobn, _, from, err := unix.Recvmsg(w.fd, buf, oob, unix.MSG_DONTWAIT)
+		switch err {
+		case nil:
+		case unix.EAGAIN, unix.EINTR:
+			continue
+		case unix.ENOBUFS:
+			// Events were lost, compare with what is there now
+			if !w.resync() {
+				return
+			}
+			continue
+		default:
+			w.err = err
+			return
+		}
+
+		// Only udev, which runs as root, may send uevents. Sending to
+		// the group needs CAP_NET_ADMIN, which root of a user namespace
+		// has too.
+		if _, ok := from.(*unix.SockaddrNetlink); ok && !sentByRoot(oob[:oobn]) {
+			continue
+		}
+
+		if !w.handle(buf[:n]) {
+			return
+		}
+	}
+}
+
+// handle sends an event for a uevent message. It returns false
+// if the watcher was closed meanwhile.
+func (w *Watcher) handle(msg []byte) bool {
+	action, subsystem, devname := parseUevent(msg)
+	name := filepath.Base(devname)
+	if subsystem != "video4linux" || !strings.HasPrefix(name, "video") {
+		return true
+	}
+
+	switch action {
+	case "add":
+		// Other nodes are not opened, which could make
+		// processes using them fail with EBUSY
+		info := w.describe(name)
+		w.devices[name] = info
+		return w.send(HotplugEvent{Action: DeviceAdded, Device: info})
+	case "remove":
+		info, ok := w.devices[name]
+		if !ok {
+			info = DeviceInfo{Path: filepath.Join(devDir, name)}
+		}
+		delete(w.devices, name)
+		return w.send(HotplugEvent{Action: DeviceRemoved, Device: info})
+	}
+	return true
+}
+
+// resync scans devices and reports the ones which were added or removed
+// since the last scan. It returns false if the watcher was closed meanwhile.
+func (w *Watcher) resync() bool {
+	devices, err := w.scan()
+	if err != nil {
+		return true
+	}
+
+	present := make(map[string]bool)
+	for _, d := range devices {
+		name := filepath.Base(d.Path)
+		present[name] = true
+		if _, ok := w.devices[name]; ok {
+			continue
+		}
+		w.devices[name] = d
+		if !w.send(HotplugEvent{Action: DeviceAdded, Device: d}) {
+			return false
+		}
+	}
+	for name, d := range w.devices {
+		if present[name] {
+			continue
+		}
+		delete(w.devices, name)
+		if !w.send(HotplugEvent{Action: DeviceRemoved, Device: d}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *Watcher) send(e HotplugEvent) bool {
+	select {
+	case w.events <- e:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// sentByRoot reports whether the credentials in the control
+// messages of a received message are the ones of root
+func sentByRoot(oob []byte) bool {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return false
+	}
+	for _, msg := range msgs {
+		if cred, err := unix.ParseUnixCredentials(&msg); err == nil {
+			return cred.Uid == 0
+		}
+	}
+	return false
+}
+
+// Header of uevent messages sent by udev
+const (
+	udevPrefix     = "libudev\x00"
+	udevMagic      = 0xfeedcafe
+	udevHeaderSize = 40
+)
+
+// parseUevent extracts fields of a uevent message sent by udev, which
+// consists of a "libudev" header followed by KEY=value properties, each
+// terminated with NUL character. The header tells where the properties
+// are. Other messages, like the ones of the kernel, are ignored.
+func parseUevent(msg []byte) (action, subsystem, devname string) {
+	if len(msg) < udevHeaderSize || string(msg[:len(udevPrefix)]) != udevPrefix ||
+		binary.BigEndian.Uint32(msg[8:]) != udevMagic {
+		return
+	}
+	offset := NativeByteOrder.Uint32(msg[16:])
+	length := NativeByteOrder.Uint32(msg[20:])
+	if uint64(offset)+uint64(length) > uint64(len(msg)) {
+		return
+	}
+
+	for _, field := range bytes.Split(msg[offset:offset+length], []byte{0}) {
+		kv := strings.SplitN(string(field), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "ACTION":
+			action = kv[1]
+		case "SUBSYSTEM":
+			subsystem = kv[1]
+		case "DEVNAME":
+			devname = kv[1]
+		}
+	}
+	return
+}
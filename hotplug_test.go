@@ -0,0 +1,164 @@
+package webcam
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// testCamera is the capture node of the test driver
+var testCamera = DeviceInfo{
+	Path:         "/dev/video0",
+	Serial:       "0123",
+	Capabilities: CapabilityFlags(V4L2_CAP_VIDEO_CAPTURE | V4L2_CAP_STREAMING),
+}
+
+// testNodes are the nodes a test watcher scans
+type testNodes struct {
+	mu      sync.Mutex
+	devices []DeviceInfo
+	scans   int
+}
+
+func (n *testNodes) scan() ([]DeviceInfo, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.scans++
+	return append([]DeviceInfo(nil), n.devices...), nil
+}
+
+func (n *testNodes) describe(name string) DeviceInfo {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, d := range n.devices {
+		if filepath.Base(d.Path) == name {
+			return d
+		}
+	}
+	return DeviceInfo{Path: filepath.Join(devDir, name)}
+}
+
+func (n *testNodes) set(devices ...DeviceInfo) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.devices = devices
+}
+
+// startTestWatcher starts a watcher of the nodes, which receives
+// uevents sent to the returned socket
+func startTestWatcher(t *testing.T, nodes *testNodes) (*Watcher, int) {
+	t.Helper()
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { unix.Close(fds[1]) })
+
+	w, err := newWatcher(fds[0], nodes.scan, nodes.describe)
+	if err != nil {
+		unix.Close(fds[0])
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w, fds[1]
+}
+
+// udevMessage returns a uevent message udev sends with the properties
+func udevMessage(properties string) []byte {
+	msg := make([]byte, udevHeaderSize, udevHeaderSize+len(properties))
+	copy(msg, udevPrefix)
+	binary.BigEndian.PutUint32(msg[8:], udevMagic)
+	NativeByteOrder.PutUint32(msg[12:], udevHeaderSize)
+	NativeByteOrder.PutUint32(msg[16:], udevHeaderSize)
+	NativeByteOrder.PutUint32(msg[20:], uint32(len(properties)))
+	return append(msg, properties...)
+}
+
+// sendUevent sends a udev uevent about the video node
+func sendUevent(t *testing.T, sock int, action, name string) {
+	t.Helper()
+
+	msg := udevMessage("ACTION=" + action + "\x00DEVPATH=/devices/pci0000:00/usb1/1-1/1-1:1.0/video4linux/" + name +
+		"\x00SUBSYSTEM=video4linux\x00DEVNAME=/dev/" + name + "\x00")
+	if _, err := unix.Write(sock, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseUevent(t *testing.T) {
+	msg := udevMessage("ACTION=add\x00DEVPATH=/devices/usb1/1-1/1-1:1.0/video4linux/video2\x00" +
+		"SUBSYSTEM=video4linux\x00DEVNAME=/dev/video2\x00SEQNUM=4242\x00" +
+		"DEVLINKS=/dev/v4l/by-id/usb-Camera-video-index0 /dev/v4l/by-path/usb-0:1:1.0-video-index0\x00")
+	action, subsystem, devname := parseUevent(msg)
+	if action != "add" || subsystem != "video4linux" || devname != "/dev/video2" {
+		t.Errorf("parseUevent = %q, %q, %q", action, subsystem, devname)
+	}
+
+	// Kernel messages are sent before udev set the node up
+	kernel := []byte("add@/devices/usb1/1-1/1-1:1.0/video4linux/video2\x00ACTION=add\x00" +
+		"SUBSYSTEM=video4linux\x00DEVNAME=video2\x00")
+	if action, _, _ := parseUevent(kernel); action != "" {
+		t.Errorf("kernel message parsed as %q", action)
+	}
+	if action, _, _ := parseUevent(msg[:udevHeaderSize+4]); action != "" {
+		t.Errorf("truncated message parsed as %q", action)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	nodes := &testNodes{devices: []DeviceInfo{testCamera}}
+	w, sock := startTestWatcher(t, nodes)
+
+	// Nodes present are reported first
+	next := func() HotplugEvent {
+		t.Helper()
+		select {
+		case e := <-w.Events():
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+		}
+		return HotplugEvent{}
+	}
+	if e := next(); e.Action != DeviceAdded || e.Device.Serial != testCamera.Serial {
+		t.Fatalf("first event = %+v", e)
+	}
+
+	// Only the added node is described
+	second := DeviceInfo{Path: "/dev/video2", Serial: "4567"}
+	nodes.set(testCamera, second)
+	sendUevent(t, sock, "add", "video2")
+	if e := next(); e.Action != DeviceAdded || e.Device.Serial != "4567" {
+		t.Fatalf("event of added node = %+v", e)
+	}
+	nodes.mu.Lock()
+	if nodes.scans != 1 {
+		t.Errorf("nodes scanned %d times, want once", nodes.scans)
+	}
+	nodes.mu.Unlock()
+
+	// Other subsystems are ignored
+	unix.Write(sock, udevMessage("ACTION=add\x00SUBSYSTEM=input\x00DEVNAME=/dev/input/event3\x00"))
+
+	nodes.set(testCamera)
+	sendUevent(t, sock, "remove", "video2")
+	if e := next(); e.Action != DeviceRemoved || e.Device.Serial != "4567" {
+		t.Fatalf("event of removed node = %+v", e)
+	}
+
+	w.Close()
+	if _, ok := <-w.Events(); ok {
+		t.Error("events channel is open after Close")
+	}
+	if err := w.Err(); err != nil {
+		t.Errorf("Err = %v", err)
+	}
+}
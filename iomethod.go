@@ -0,0 +1,372 @@
+package webcam
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// IOMethod selects how frames are transferred from the driver
+type IOMethod int
+
+const (
+	// Buffers are allocated by the driver and mapped into the process
+	IOMethodMMAP IOMethod = iota
+
+	// Buffers are allocated by a BufferAllocator and the driver
+	// writes frames directly into them
+	IOMethodUserPtr
+
+	// Buffers are DMABUF file descriptors set with SetDMABUFs,
+	// e.g. exported by another device
+	IOMethodDMABUF
+
+	// Frames are copied into buffers allocated from Go heap with read().
+	// It is selected automatically for devices which can't stream.
+	IOMethodRead
+)
+
+func (m IOMethod) String() string {
+	switch m {
+	case IOMethodMMAP:
+		return "mmap"
+	case IOMethodUserPtr:
+		return "userptr"
+	case IOMethodDMABUF:
+		return "dmabuf"
+	case IOMethodRead:
+		return "read"
+	}
+	return "unknown"
+}
+
+// memory returns V4L2_MEMORY_* value corresponding to the method
+func (m IOMethod) memory() uint32 {
+	switch m {
+	case IOMethodUserPtr:
+		return V4L2_MEMORY_USERPTR
+	case IOMethodDMABUF:
+		return V4L2_MEMORY_DMABUF
+	}
+	return V4L2_MEMORY_MMAP
+}
+
+// BufferAllocator provides memory for frames captured with
+// IOMethodUserPtr. Buffers are allocated when streaming starts and freed
+// when it stops. Many drivers require buffers to be page aligned.
+type BufferAllocator interface {
+	Alloc(size int) ([]byte, error)
+	Free(buf []byte)
+}
+
+// PageAlignedAllocator allocates page aligned buffers from Go heap.
+// It is the default allocator.
+type PageAlignedAllocator struct{}
+
+// Alloc returns a buffer starting at a page boundary
+// with length rounded up to a whole number of pages
+func (PageAlignedAllocator) Alloc(size int) ([]byte, error) {
+	page := unix.Getpagesize()
+	size = (size + page - 1) / page * page
+	raw := make([]byte, size+page)
+	offset := int(-uintptr(unsafe.Pointer(&raw[0])) & uintptr(page-1))
+	return raw[offset : offset+size : offset+size], nil
+}
+
+// Free does nothing, buffers are garbage collected
+func (PageAlignedAllocator) Free(buf []byte) {}
+
+// Set the I/O method used to transfer frames. Methods the device
+// doesn't support fail with an error matching ErrUnsupported.
+// Not allowed if streaming is already on.
+func (w *Webcam) SetIOMethod(method IOMethod) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.streaming {
+		return &stateError{"Cannot set I/O method when streaming", ErrAlreadyStreaming}
+	}
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	caps, err := checkCapabilities(w.dev)
+
+	if err != nil {
+		return err
+	}
+
+	switch method {
+	case IOMethodMMAP, IOMethodUserPtr, IOMethodDMABUF:
+		if caps&V4L2_CAP_STREAMING == 0 {
+			return &stateError{"device doesn't support the streaming I/O methods", ErrUnsupported}
+		}
+	case IOMethodRead:
+		// See openDevice
+		if caps&V4L2_CAP_READWRITE == 0 || w.IsMultiPlanar() {
+			return &stateError{"device doesn't support the read I/O method", ErrUnsupported}
+		}
+	default:
+		return &stateError{fmt.Sprintf("unknown I/O method %d", int(method)), unix.EINVAL}
+	}
+
+	w.ioMethod = method
+	return nil
+}
+
+// Get the I/O method used to transfer frames.
+func (w *Webcam) GetIOMethod() IOMethod {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.ioMethod
+}
+
+// Set the allocator of buffers for IOMethodUserPtr.
+// Not allowed if streaming is already on.
+func (w *Webcam) SetBufferAllocator(allocator BufferAllocator) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.streaming {
+		return &stateError{"Cannot set buffer allocator when streaming", ErrAlreadyStreaming}
+	}
+	w.allocator = allocator
+	return nil
+}
+
+func (w *Webcam) mapBuffers() error {
+	err := requestBuffers(w.dev, w.bufType, V4L2_MEMORY_MMAP, &w.bufcount)
+
+	if err != nil {
+		return fmt.Errorf("Failed to map request buffers: %w", err)
+	}
+
+	w.buffers = make([][]byte, w.bufcount, w.bufcount)
+	for index, _ := range w.buffers {
+		var length uint32
+
+		buffer, err := mmapQueryBuffer(w.dev, uint32(index), &length)
+
+		if err != nil {
+			return fmt.Errorf("Failed to map memory: %w", err)
+		}
+
+		w.buffers[index] = buffer
+	}
+
+	if w.exportDMABUF {
+		return w.exportBuffers()
+	}
+
+	return nil
+}
+
+func (w *Webcam) allocUserBuffers() error {
+	pix, err := getImageFormat(w.dev)
+
+	if err != nil {
+		return fmt.Errorf("Failed to get image format: %w", err)
+	}
+
+	err = requestBuffers(w.dev, w.bufType, V4L2_MEMORY_USERPTR, &w.bufcount)
+
+	if err != nil {
+		return fmt.Errorf("Failed to request user pointer buffers: %w", err)
+	}
+
+	w.buffers = make([][]byte, w.bufcount, w.bufcount)
+	for index, _ := range w.buffers {
+		buffer, err := w.allocator.Alloc(int(pix.Sizeimage))
+
+		if err != nil {
+			return fmt.Errorf("Failed to allocate buffer: %w", err)
+		}
+
+		w.buffers[index] = buffer
+	}
+
+	return nil
+}
+
+// orphan is a buffer which was held by the caller when streaming
+// stopped. It stays mapped until the caller releases it.
+type orphan struct {
+	ref       *frameRef
+	index     uint32
+	method    IOMethod
+	allocator BufferAllocator
+	buffer    []byte
+	planes    [][]byte
+	dmabuf    int
+}
+
+// releaseBuffers frees the buffers of the stopped stream. Buffers held by
+// the caller become orphans, and the buffers of the driver are freed
+// with REQBUFS once none of them is mapped.
+func (w *Webcam) releaseBuffers() error {
+	var err error
+	for index, buffer := range w.buffers {
+		b := orphan{
+			index:     uint32(index),
+			method:    w.ioMethod,
+			allocator: w.allocator,
+			buffer:    buffer,
+			dmabuf:    -1,
+		}
+		if index < len(w.planes) {
+			b.planes = w.planes[index]
+		}
+		if index < len(w.dmabufs) && w.ioMethod == IOMethodMMAP {
+			b.dmabuf = w.dmabufs[index]
+		}
+
+		if index < len(w.held) && w.held[index] != nil {
+			b.ref = w.held[index]
+			w.orphans = append(w.orphans, b)
+		} else if e := b.free(w.dev); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	w.buffers = nil
+	w.planes = nil
+	w.dmabufs = nil
+	w.held = nil
+	w.readFree = nil
+
+	// Buffers of read() are not queued in the driver
+	if w.ioMethod != IOMethodRead {
+		w.queueMemory = w.ioMethod.memory()
+	}
+	if e := w.freeQueue(); e != nil && err == nil {
+		err = e
+	}
+
+	return err
+}
+
+// releaseOrphan frees the most recently orphaned buffer which matches.
+// It reports false if there is no such buffer.
+func (w *Webcam) releaseOrphan(match func(o *orphan) bool) (bool, error) {
+	for i := len(w.orphans) - 1; i >= 0; i-- {
+		b := w.orphans[i]
+		if !match(&b) {
+			continue
+		}
+		w.orphans = append(w.orphans[:i], w.orphans[i+1:]...)
+		b.ref.drop()
+		err := b.free(w.dev)
+
+		// The queue is freed when the device is closed
+		if !w.closed {
+			if e := w.freeQueue(); e != nil && err == nil {
+				err = e
+			}
+		}
+		return true, err
+	}
+	return false, nil
+}
+
+// freeQueue frees the buffers of the driver if streaming was stopped
+// and no buffer is mapped any more. Drivers refuse to free buffers
+// which are still mapped.
+func (w *Webcam) freeQueue() error {
+	if w.queueMemory == 0 || w.buffers != nil || len(w.orphans) > 0 {
+		return nil
+	}
+
+	var count uint32
+	err := requestBuffers(w.dev, w.bufType, w.queueMemory, &count)
+	w.queueMemory = 0
+
+	if err != nil {
+		return fmt.Errorf("Failed to free buffers: %w", err)
+	}
+	return nil
+}
+
+func (b *orphan) free(dev device) error {
+	switch b.method {
+	case IOMethodUserPtr:
+		b.allocator.Free(b.buffer)
+	case IOMethodDMABUF:
+		// Imported descriptors are owned by the caller
+		if b.buffer != nil {
+			unix.Munmap(b.buffer)
+		}
+	case IOMethodRead:
+	default:
+		planes := b.planes
+		if planes == nil {
+			planes = [][]byte{b.buffer}
+		}
+
+		var err error
+		for _, plane := range planes {
+			if e := mmapReleaseBuffer(dev, plane); e != nil && err == nil {
+				err = e
+			}
+		}
+		if b.dmabuf >= 0 {
+			unix.Close(b.dmabuf)
+		}
+		return err
+	}
+	return nil
+}
+
+func (w *Webcam) allocReadBuffers() error {
+	pix, err := getImageFormat(w.dev)
+
+	if err != nil {
+		return fmt.Errorf("Failed to get image format: %w", err)
+	}
+
+	// Buffers are allocated on demand in readFrame,
+	// as only frames held by the caller need one
+	w.readSize = pix.Sizeimage
+	w.readSequence = 0
+	w.buffers = make([][]byte, 0, w.bufcount)
+	return nil
+}
+
+// readFrame reads a frame into a free buffer. The driver does not report
+// metadata with read(), so the frame is timestamped when it's read.
+func (w *Webcam) readFrame() (*Frame, error) {
+	var index uint32
+	if n := len(w.readFree); n > 0 {
+		index = w.readFree[n-1]
+		w.readFree = w.readFree[:n-1]
+	} else if uint32(len(w.buffers)) < w.bufcount {
+		index = uint32(len(w.buffers))
+		w.buffers = append(w.buffers, make([]byte, w.readSize))
+	} else {
+		return nil, &stateError{"no free buffer, frames must be released", ErrDeviceBusy}
+	}
+
+	n, err := w.dev.read(w.buffers[index])
+
+	if err != nil {
+		w.readFree = append(w.readFree, index)
+		return nil, err
+	}
+
+	var now unix.Timespec
+	unix.ClockGettime(unix.CLOCK_MONOTONIC, &now)
+
+	buffer := &v4l2_buffer{
+		index:     index,
+		bytesused: uint32(n),
+		flags:     V4L2_BUF_FLAG_TIMESTAMP_MONOTONIC,
+		field:     V4L2_FIELD_NONE,
+		timestamp: unix.NsecToTimeval(now.Nano()),
+		sequence:  w.readSequence,
+	}
+	w.readSequence++
+
+	return newFrame(w.buffers[index][:n], buffer), nil
+}
@@ -0,0 +1,205 @@
+package webcam
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// countingAllocator counts buffers allocated and not freed yet
+type countingAllocator struct {
+	PageAlignedAllocator
+	live int
+}
+
+func (a *countingAllocator) Alloc(size int) ([]byte, error) {
+	a.live++
+	return a.PageAlignedAllocator.Alloc(size)
+}
+
+func (a *countingAllocator) Free(buf []byte) {
+	a.live--
+}
+
+func TestUserPtr(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+
+	allocator := &countingAllocator{}
+	if err := w.SetIOMethod(IOMethodUserPtr); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetBufferAllocator(allocator); err != nil {
+		t.Fatal(err)
+	}
+	startTestStreaming(t, w, 3)
+
+	if allocator.live != 3 {
+		t.Fatalf("%d buffers allocated, want 3", allocator.live)
+	}
+	if err := w.SetIOMethod(IOMethodMMAP); !errors.Is(err, ErrAlreadyStreaming) {
+		t.Errorf("SetIOMethod when streaming = %v, want ErrAlreadyStreaming", err)
+	}
+
+	for _, data := range []string{"first", "second", "third", "fourth"} {
+		pushTestFrame(t, d, w, data)
+		frame, err := w.ReadFrame()
+		if err != nil || string(frame) != data {
+			t.Fatalf("ReadFrame = %q, %v, want %q", frame, err, data)
+		}
+	}
+
+	if err := w.StopStreaming(); err != nil {
+		t.Fatal(err)
+	}
+	if allocator.live != 0 {
+		t.Errorf("%d buffers not freed", allocator.live)
+	}
+}
+
+func TestUserPtrReleaseInvalidIndex(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	if err := w.SetIOMethod(IOMethodUserPtr); err != nil {
+		t.Fatal(err)
+	}
+	startTestStreaming(t, w, 2)
+
+	if err := w.ReleaseFrame(99); !errors.Is(err, unix.EINVAL) {
+		t.Fatalf("ReleaseFrame(99) = %v, want EINVAL", err)
+	}
+}
+
+// newReadDriver returns a driver which supports only read()
+func newReadDriver() *FakeDriver {
+	d := newTestDriver()
+	d.Capabilities = V4L2_CAP_VIDEO_CAPTURE | V4L2_CAP_READWRITE
+	return d
+}
+
+// startTestReading starts capture with read(),
+// which the first poll does
+func startTestReading(t *testing.T, w *Webcam, count uint32) {
+	t.Helper()
+
+	startTestStreaming(t, w, count)
+	var timeout *Timeout
+	if err := w.WaitForFrame(0); !errors.As(err, &timeout) {
+		t.Fatalf("WaitForFrame = %v, want timeout", err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	d := newReadDriver()
+	w := openTestDriver(t, d)
+
+	if method := w.GetIOMethod(); method != IOMethodRead {
+		t.Fatalf("I/O method = %v, want read", method)
+	}
+	startTestReading(t, w, 2)
+
+	for i, data := range []string{"first", "second", "third"} {
+		pushTestFrame(t, d, w, data)
+		frame, err := w.GetFrameWithMetadata()
+		if err != nil || string(frame.Data) != data {
+			t.Fatalf("GetFrameWithMetadata = %v, %v, want %q", frame, err, data)
+		}
+		if frame.Sequence != uint32(i) {
+			t.Errorf("sequence = %d, want %d", frame.Sequence, i)
+		}
+		if err := w.ReleaseFrame(frame.Index); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadHeldBuffers(t *testing.T) {
+	d := newReadDriver()
+	w := openTestDriver(t, d)
+	startTestReading(t, w, 2)
+
+	// Each frame held has its own buffer
+	pushTestFrame(t, d, w, "first")
+	first, i, err := w.GetFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushTestFrame(t, d, w, "second")
+	second, j, err := w.GetFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i == j || string(first) != "first" || string(second) != "second" {
+		t.Errorf("frames %q in buffer %d and %q in buffer %d", first, i, second, j)
+	}
+
+	// All buffers are held
+	pushTestFrame(t, d, w, "third")
+	if _, _, err := w.GetFrame(); !errors.Is(err, ErrDeviceBusy) {
+		t.Errorf("GetFrame without free buffers = %v, want ErrDeviceBusy", err)
+	}
+	if err := w.ReleaseFrame(i); err != nil {
+		t.Fatal(err)
+	}
+	if frame, err := w.ReadFrame(); err != nil || string(frame) != "third" {
+		t.Errorf("ReadFrame after release = %q, %v", frame, err)
+	}
+}
+
+func TestReadReleaseNotHeld(t *testing.T) {
+	d := newReadDriver()
+	w := openTestDriver(t, d)
+	startTestReading(t, w, 2)
+
+	pushTestFrame(t, d, w, "first")
+	_, index, err := w.GetFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.ReleaseFrame(index); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.ReleaseFrame(index); !errors.Is(err, unix.EINVAL) {
+		t.Fatalf("second ReleaseFrame = %v, want EINVAL", err)
+	}
+
+	// The buffer is free once, so both frames get their own
+	pushTestFrame(t, d, w, "second")
+	_, i, err := w.GetFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushTestFrame(t, d, w, "third")
+	_, j, err := w.GetFrame()
+	if err != nil || i == j {
+		t.Fatalf("GetFrame = buffer %d, %v, first frame in buffer %d", j, err, i)
+	}
+}
+
+func TestSetIOMethodUnsupported(t *testing.T) {
+	tests := []struct {
+		caps   uint32
+		method IOMethod
+	}{
+		{V4L2_CAP_VIDEO_CAPTURE | V4L2_CAP_STREAMING, IOMethodRead},
+		{V4L2_CAP_VIDEO_CAPTURE | V4L2_CAP_READWRITE, IOMethodMMAP},
+		{V4L2_CAP_VIDEO_CAPTURE | V4L2_CAP_READWRITE, IOMethodUserPtr},
+		{V4L2_CAP_VIDEO_CAPTURE | V4L2_CAP_READWRITE, IOMethodDMABUF},
+		{V4L2_CAP_VIDEO_CAPTURE_MPLANE | V4L2_CAP_STREAMING | V4L2_CAP_READWRITE, IOMethodRead},
+	}
+
+	for _, test := range tests {
+		d := newTestDriver()
+		d.Capabilities = test.caps
+		w := openTestDriver(t, d)
+
+		before := w.GetIOMethod()
+		if err := w.SetIOMethod(test.method); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("SetIOMethod(%v) with capabilities %v = %v, want ErrUnsupported", test.method, CapabilityFlags(test.caps), err)
+		}
+		if method := w.GetIOMethod(); method != before {
+			t.Errorf("I/O method changed to %v", method)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package webcam
+
+import (
+	"fmt"
+)
+
+// IsMultiPlanar reports whether the device captures with the multi-planar
+// API. Planes of such frames are returned in Frame.Planes, as formats like
+// V4L2_PIX_FMT_NV12M store them in separate buffers. Only IOMethodMMAP is
+// supported for multi-planar capture.
+func (w *Webcam) IsMultiPlanar() bool {
+	return w.bufType == V4L2_BUF_TYPE_VIDEO_CAPTURE_MPLANE
+}
+
+func (w *Webcam) mapPlanes() error {
+	if w.ioMethod != IOMethodMMAP || w.exportDMABUF {
+		return &stateError{"Multi-planar capture supports only the mmap I/O method", ErrUnsupported}
+	}
+
+	pix, err := mplaneGetImageFormat(w.dev)
+
+	if err != nil {
+		return fmt.Errorf("Failed to get image format: %w", err)
+	}
+
+	w.numPlanes = uint32(pix.Num_planes)
+	if w.numPlanes == 0 || w.numPlanes > VIDEO_MAX_PLANES {
+		return &stateError{"invalid number of planes", ErrUnsupported}
+	}
+
+	err = requestBuffers(w.dev, w.bufType, V4L2_MEMORY_MMAP, &w.bufcount)
+
+	if err != nil {
+		return fmt.Errorf("Failed to map request buffers: %w", err)
+	}
+
+	w.planes = make([][][]byte, w.bufcount, w.bufcount)
+	w.buffers = make([][]byte, w.bufcount, w.bufcount)
+	for index := range w.planes {
+		planes, err := mplaneQueryBuffer(w.dev, uint32(index), w.numPlanes)
+
+		if err != nil {
+			return fmt.Errorf("Failed to map memory: %w", err)
+		}
+
+		w.planes[index] = planes
+		w.buffers[index] = planes[0]
+	}
+
+	return nil
+}
+
+func (w *Webcam) dequeuePlanes() (*Frame, error) {
+	buffer := &v4l2_buffer{}
+	planes := make([]v4l2_plane, w.numPlanes)
+
+	err := mplaneDequeueBuffer(w.dev, buffer, planes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mapped := w.planes[buffer.index]
+	data := make([][]byte, 0, buffer.length)
+	for i, plane := range planes[:buffer.length] {
+		// Payload of a plane may start after a header
+		start, end := plane.data_offset, plane.bytesused
+		if start > end {
+			start = end
+		}
+		data = append(data, mapped[i][start:end])
+	}
+
+	frame := newFrame(data[0], buffer)
+	frame.Planes = data
+
+	return frame, nil
+}
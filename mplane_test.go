@@ -0,0 +1,65 @@
+package webcam
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// newMplaneDriver returns a driver which captures NV12M
+// with the multi-planar API only
+func newMplaneDriver() *FakeDriver {
+	d := NewFakeDriver()
+	d.Capabilities = V4L2_CAP_VIDEO_CAPTURE_MPLANE | V4L2_CAP_STREAMING
+	d.AddFormat(V4L2_PIX_FMT_NV12M, "Y/CbCr 4:2:0 (N-C)", FrameSize{MinWidth: 64, MaxWidth: 64, MinHeight: 32, MaxHeight: 32})
+	return d
+}
+
+func TestMultiPlanar(t *testing.T) {
+	d := newMplaneDriver()
+	w := openTestDriver(t, d)
+
+	if !w.IsMultiPlanar() {
+		t.Fatal("device is not multi-planar")
+	}
+	if formats := w.GetSupportedFormats(); len(formats) != 1 {
+		t.Fatalf("formats = %v", formats)
+	}
+	if f, width, height, err := w.SetImageFormat(V4L2_PIX_FMT_NV12M, 64, 32); err != nil || f != V4L2_PIX_FMT_NV12M || width != 64 || height != 32 {
+		t.Fatalf("SetImageFormat = %v %dx%d, %v", f, width, height, err)
+	}
+	startTestStreaming(t, w, 2)
+
+	// Luma is followed by the interleaved chroma plane
+	luma := bytes.Repeat([]byte{'y'}, 64*32)
+	chroma := bytes.Repeat([]byte{'c'}, 64*32/2)
+	pushTestFrame(t, d, w, string(luma)+string(chroma))
+
+	frame, err := w.GetFrameWithMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.ReleaseFrame(frame.Index)
+
+	if len(frame.Planes) != 2 {
+		t.Fatalf("%d planes, want 2", len(frame.Planes))
+	}
+	if !bytes.Equal(frame.Planes[0], luma) || !bytes.Equal(frame.Planes[1], chroma) {
+		t.Errorf("planes of %d and %d bytes hold wrong data", len(frame.Planes[0]), len(frame.Planes[1]))
+	}
+	if !bytes.Equal(frame.Data, luma) {
+		t.Error("Data is not the first plane")
+	}
+}
+
+func TestMultiPlanarIOMethod(t *testing.T) {
+	d := newMplaneDriver()
+	w := openTestDriver(t, d)
+
+	if err := w.SetIOMethod(IOMethodUserPtr); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.StartStreaming(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("StartStreaming with USERPTR = %v, want ErrUnsupported", err)
+	}
+}
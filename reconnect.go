@@ -0,0 +1,726 @@
+package webcam
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeviceIdentity identifies a camera regardless of the node it gets
+// after being plugged in again, see IdentityOf.
+type DeviceIdentity struct {
+	// USB serial number. Vendor and product IDs, if set, must match
+	// too, as serial numbers are only unique for a product.
+	Serial  string
+	Vendor  string
+	Product string
+
+	// Link in /dev/v4l/by-path, which identifies the port the camera
+	// is plugged into. It is used if the camera has no serial number.
+	ByPath string
+}
+
+// IdentityOf returns the identity of a discovered device. Serial number
+// is preferred, so that the camera can be moved to another port.
+func IdentityOf(d DeviceInfo) DeviceIdentity {
+	if d.Serial != "" {
+		return DeviceIdentity{Serial: d.Serial, Vendor: d.Vendor, Product: d.Product}
+	}
+	var id DeviceIdentity
+	if len(d.ByPath) > 0 {
+		id.ByPath = d.ByPath[0]
+	}
+	return id
+}
+
+// Matches reports whether the device has the identity
+func (id DeviceIdentity) Matches(d DeviceInfo) bool {
+	if id.Serial != "" {
+		return d.Serial == id.Serial &&
+			(id.Vendor == "" || d.Vendor == id.Vendor) &&
+			(id.Product == "" || d.Product == id.Product)
+	}
+	if id.ByPath != "" {
+		for _, link := range d.ByPath {
+			if link == id.ByPath {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ConnectionState is the state of a ReconnectingCamera
+type ConnectionState int
+
+const (
+	StateConnected ConnectionState = iota
+	StateDisconnected
+	StateReconnecting
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// StateEvent reports a change of the connection state
+type StateEvent struct {
+	State ConnectionState
+
+	// Error which caused disconnection or failed reconnection
+	Err error
+}
+
+// Default time between attempts to reopen a disconnected camera
+const DefaultRetryInterval = time.Second
+
+// errDeviceNotFound is returned when no device has the identity,
+// which callers match as ErrDisconnected
+var errDeviceNotFound = &stateError{"device not found", ErrDisconnected}
+
+// ReconnectingCamera is a Camera which survives the device being
+// unplugged. When a request fails because the device is gone, the
+// camera is closed and reopened once the device is back, and the image
+// format, frame rate, buffer count, control values and streaming state
+// set through the ReconnectingCamera are applied again.
+//
+// While the device is disconnected WaitForFrame reports timeouts, frame
+// requests fail with "Device is disconnected" error, and settings are
+// recorded to be applied on reconnection.
+type ReconnectingCamera struct {
+	mu     sync.Mutex
+	id     DeviceIdentity
+	open   func(path string) (*Webcam, error)
+	cam    *Webcam
+	state  ConnectionState
+	events chan StateEvent
+
+	// Device nodes reported by the watcher, indexed by path. If
+	// watching failed, all nodes are scanned on every attempt.
+	watcher  *Watcher
+	present  map[string]DeviceInfo
+	watchErr error
+
+	// Closed and replaced when a node is added or removed
+	changed chan struct{}
+
+	retry       time.Duration
+	lastAttempt time.Time
+
+	// Settings to apply after reconnection
+	format    PixelFormat
+	width     uint32
+	height    uint32
+	hasFormat bool
+	fps       float32
+	hasFps    bool
+	bufcount  uint32
+	controls  []ControlID
+	values    map[ControlID]int32
+	streaming bool
+
+	// Buffers dequeued from the current device, and the ones held
+	// when a previous device was closed. The closed device keeps those
+	// mapped until they are released.
+	held  map[uint32]int
+	stale []staleFrame
+}
+
+// staleFrame is a buffer held when its device was closed
+type staleFrame struct {
+	cam   *Webcam
+	index uint32
+}
+
+var _ Camera = (*ReconnectingCamera)(nil)
+
+// OpenReconnecting opens the camera with the identity, which must be
+// connected at the time. The camera is reopened when a Watcher reports
+// a node with the identity was added.
+func OpenReconnecting(id DeviceIdentity) (*ReconnectingCamera, error) {
+	watcher, err := Watch()
+	if err != nil {
+		return nil, err
+	}
+	c, err := newReconnectingCamera(id, watcher, Open)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// newReconnectingCamera opens the camera with open, which is given
+// the path of a node with the identity. It takes the watcher over.
+func newReconnectingCamera(id DeviceIdentity, watcher *Watcher, open func(path string) (*Webcam, error)) (*ReconnectingCamera, error) {
+	c := &ReconnectingCamera{
+		id:      id,
+		open:    open,
+		state:   StateConnected,
+		events:  make(chan StateEvent, 16),
+		watcher: watcher,
+		present: make(map[string]DeviceInfo),
+		changed: make(chan struct{}),
+		retry:   DefaultRetryInterval,
+		values:  make(map[ControlID]int32),
+		held:    make(map[uint32]int),
+	}
+
+	// The watcher reports the nodes present as added too,
+	// but the camera must be opened now
+	devices, err := watcher.scan()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range devices {
+		c.present[d.Path] = d
+	}
+	if c.cam, err = c.reopen(); err != nil {
+		return nil, err
+	}
+
+	go c.watch()
+	return c, nil
+}
+
+// watch keeps track of the nodes present, and makes a disconnected camera
+// try to reopen as soon as a node is added
+func (c *ReconnectingCamera) watch() {
+	for e := range c.watcher.Events() {
+		c.mu.Lock()
+		if c.state == StateClosed {
+			c.mu.Unlock()
+			return
+		}
+		switch e.Action {
+		case DeviceAdded:
+			c.present[e.Device.Path] = e.Device
+			c.lastAttempt = time.Time{}
+		case DeviceRemoved:
+			delete(c.present, e.Device.Path)
+		}
+		c.notify()
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state != StateClosed {
+		c.watchErr = c.watcher.Err()
+		c.notify()
+	}
+}
+
+// notify wakes up WaitForFrame
+func (c *ReconnectingCamera) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// candidates returns capture nodes which have the identity,
+// ordered by node number
+func (c *ReconnectingCamera) candidates() []DeviceInfo {
+	var devices []DeviceInfo
+	if c.watchErr != nil {
+		devices, _ = c.watcher.scan()
+	} else {
+		for _, d := range c.present {
+			devices = append(devices, d)
+		}
+	}
+
+	var result []DeviceInfo
+	for _, d := range devices {
+		// Nodes which couldn't be queried, e.g. as another
+		// process had them open, are tried too
+		if (d.Capabilities == 0 || d.IsCapture()) && c.id.Matches(d) {
+			result = append(result, d)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return nodeLess(filepath.Base(result[i].Path), filepath.Base(result[j].Path))
+	})
+	return result
+}
+
+// reopen opens the first candidate node which can be opened
+func (c *ReconnectingCamera) reopen() (*Webcam, error) {
+	err := error(errDeviceNotFound)
+	for _, d := range c.candidates() {
+		var cam *Webcam
+		if cam, err = c.open(d.Path); err == nil {
+			return cam, nil
+		}
+	}
+	return nil, err
+}
+
+// Events returns the channel connection state changes are reported on.
+// Events are dropped if the channel is not read.
+func (c *ReconnectingCamera) Events() <-chan StateEvent {
+	return c.events
+}
+
+// State returns the current connection state
+func (c *ReconnectingCamera) State() ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
+}
+
+// Set the time between attempts to reopen a disconnected camera. The
+// camera is reopened right away when the device node is added, and
+// again after the interval if that failed, e.g. as the node was not
+// accessible yet.
+func (c *ReconnectingCamera) SetRetryInterval(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.retry = d
+}
+
+func (c *ReconnectingCamera) setState(state ConnectionState, err error) {
+	c.state = state
+	select {
+	case c.events <- StateEvent{State: state, Err: err}:
+	default:
+	}
+}
+
+// isDisconnect reports whether err means the device is gone
+func isDisconnect(err error) bool {
+	return errors.Is(err, ErrDisconnected)
+}
+
+// check closes the camera if err tells it was disconnected, and
+// returns err
+func (c *ReconnectingCamera) check(err error) error {
+	if err != nil && c.cam != nil && isDisconnect(err) {
+		c.detach()
+		c.setState(StateDisconnected, err)
+	}
+	return err
+}
+
+// detach closes the current device. Buffers held by the caller
+// are released to the closed device later.
+func (c *ReconnectingCamera) detach() error {
+	for index, count := range c.held {
+		for i := 0; i < count; i++ {
+			c.stale = append(c.stale, staleFrame{c.cam, index})
+		}
+	}
+	c.held = make(map[uint32]int)
+
+	err := c.cam.Close()
+	c.cam = nil
+	return err
+}
+
+// connected returns the camera, trying to reopen it if it's disconnected
+// and the retry interval passed
+func (c *ReconnectingCamera) connected() *Webcam {
+	if c.cam != nil || c.state == StateClosed || !c.pending() {
+		return c.cam
+	}
+	c.lastAttempt = time.Now()
+
+	cam, err := c.reopen()
+	if err != nil {
+		// The device is not back yet
+		return nil
+	}
+
+	c.setState(StateReconnecting, nil)
+	if err := c.restore(cam); err != nil {
+		cam.Close()
+		c.setState(StateDisconnected, err)
+		return nil
+	}
+
+	c.cam = cam
+	c.setState(StateConnected, nil)
+	return cam
+}
+
+// pending reports whether reopening should be tried, which it is
+// when a node is present and the retry interval passed
+func (c *ReconnectingCamera) pending() bool {
+	if c.watchErr == nil && len(c.present) == 0 {
+		return false
+	}
+	return time.Since(c.lastAttempt) >= c.retry
+}
+
+// restore applies recorded settings to a reopened camera
+func (c *ReconnectingCamera) restore(cam *Webcam) error {
+	if c.bufcount != 0 {
+		if err := cam.SetBufferCount(c.bufcount); err != nil {
+			return err
+		}
+	}
+	if c.hasFormat {
+		if _, _, _, err := cam.SetImageFormat(c.format, c.width, c.height); err != nil {
+			return err
+		}
+	}
+	if c.hasFps {
+		if err := cam.SetFramerate(c.fps); err != nil {
+			return err
+		}
+	}
+	for _, id := range c.controls {
+		if err := cam.SetControl(id, c.values[id]); err != nil {
+			return err
+		}
+	}
+	if c.streaming {
+		return cam.StartStreaming()
+	}
+	return nil
+}
+
+func (c *ReconnectingCamera) GetSupportedFormats() map[PixelFormat]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cam := c.connected(); cam != nil {
+		return cam.GetSupportedFormats()
+	}
+	return make(map[PixelFormat]string)
+}
+
+func (c *ReconnectingCamera) GetSupportedFrameSizes(f PixelFormat) []FrameSize {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cam := c.connected(); cam != nil {
+		return cam.GetSupportedFrameSizes(f)
+	}
+	return make([]FrameSize, 0)
+}
+
+func (c *ReconnectingCamera) GetSupportedFramerates(fp PixelFormat, width uint32, height uint32) []FrameRate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cam := c.connected(); cam != nil {
+		return cam.GetSupportedFramerates(fp, width, height)
+	}
+	return nil
+}
+
+// SetImageFormat sets the image format now and after reconnection.
+// While disconnected the requested values are returned.
+func (c *ReconnectingCamera) SetImageFormat(f PixelFormat, width, height uint32) (PixelFormat, uint32, uint32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cam := c.connected(); cam != nil {
+		rf, rw, rh, err := cam.SetImageFormat(f, width, height)
+		if err = c.check(err); err == nil {
+			c.format, c.width, c.height, c.hasFormat = f, width, height, true
+			return rf, rw, rh, nil
+		} else if !isDisconnect(err) {
+			return rf, rw, rh, err
+		}
+	}
+	// The format is applied on reconnection
+	c.format, c.width, c.height, c.hasFormat = f, width, height, true
+	return f, width, height, nil
+}
+
+func (c *ReconnectingCamera) GetFramerate() (float32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cam := c.connected(); cam != nil {
+		fps, err := cam.GetFramerate()
+		return fps, c.check(err)
+	}
+	return 0, ErrDisconnected
+}
+
+// SetFramerate sets the frame rate now and after reconnection
+func (c *ReconnectingCamera) SetFramerate(fps float32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cam := c.connected(); cam != nil {
+		if err := c.check(cam.SetFramerate(fps)); err != nil && !isDisconnect(err) {
+			return err
+		}
+	}
+	c.fps, c.hasFps = fps, true
+	return nil
+}
+
+// SetBufferCount sets the number of buffers now and after reconnection
+func (c *ReconnectingCamera) SetBufferCount(count uint32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cam := c.connected(); cam != nil {
+		if err := c.check(cam.SetBufferCount(count)); err != nil && !isDisconnect(err) {
+			return err
+		}
+	}
+	c.bufcount = count
+	return nil
+}
+
+// StartStreaming starts streaming now, or as soon as the device
+// is reconnected
+func (c *ReconnectingCamera) StartStreaming() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.streaming {
+		return ErrAlreadyStreaming
+	}
+	if cam := c.connected(); cam != nil {
+		if err := c.check(cam.StartStreaming()); err != nil && !isDisconnect(err) {
+			return err
+		}
+	}
+	c.streaming = true
+	return nil
+}
+
+func (c *ReconnectingCamera) StopStreaming() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.streaming {
+		return &stateError{"Request to stop streaming when not streaming", ErrNotStreaming}
+	}
+	c.streaming = false
+	if c.cam != nil {
+		if err := c.check(c.cam.StopStreaming()); err != nil && !isDisconnect(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitForFrame waits until a frame can be read. While the device is
+// disconnected it waits for reconnection and reports a timeout.
+func (c *ReconnectingCamera) WaitForFrame(timeout uint32) error {
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+
+	for {
+		c.mu.Lock()
+		cam := c.connected()
+		next := deadline
+		if c.watchErr != nil || len(c.present) > 0 {
+			// Reopening failed, e.g. the node is not accessible yet
+			if retry := c.lastAttempt.Add(c.retry); retry.Before(next) {
+				next = retry
+			}
+		}
+		changed := c.changed
+		closed := c.state == StateClosed
+		c.mu.Unlock()
+
+		if closed {
+			return ErrClosed
+		}
+
+		if cam != nil {
+			// Time spent disconnected counts against the timeout
+			err := cam.wait(pollTimeout(deadline, timeoutMs(timeout)), -1)
+			c.mu.Lock()
+			if c.cam == cam {
+				c.check(err)
+			}
+			c.mu.Unlock()
+			if !isDisconnect(err) {
+				return err
+			}
+			if !time.Now().Before(deadline) {
+				return new(Timeout)
+			}
+			continue
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+		if !time.Now().Before(deadline) {
+			return new(Timeout)
+		}
+	}
+}
+
+func (c *ReconnectingCamera) ReadFrame() ([]byte, error) {
+	result, index, err := c.GetFrame()
+	if err == nil {
+		c.ReleaseFrame(index)
+	}
+	return result, err
+}
+
+func (c *ReconnectingCamera) GetFrame() ([]byte, uint32, error) {
+	frame, err := c.getFrame(false)
+	if err != nil {
+		return nil, 0, err
+	}
+	return frame.Data, frame.Index, nil
+}
+
+func (c *ReconnectingCamera) GetFrameWithMetadata() (*Frame, error) {
+	return c.getFrame(true)
+}
+
+// getFrame gets a frame from the current device, see Webcam.getFrame
+func (c *ReconnectingCamera) getFrame(returned bool) (*Frame, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cam := c.connected()
+	if cam == nil {
+		return nil, ErrDisconnected
+	}
+	frame, err := cam.getFrame(returned)
+	if c.check(err) != nil {
+		if isDisconnect(err) {
+			return nil, ErrDisconnected
+		}
+		return nil, err
+	}
+	c.held[frame.Index]++
+
+	// Frame.Release must update the held buffers too
+	ref := frame.ref
+	ref.release = func() error {
+		return c.releaseRef(cam, ref)
+	}
+	return frame, nil
+}
+
+// releaseRef returns the buffer of a frame released with Frame.Release
+// to the device it was obtained from
+func (c *ReconnectingCamera) releaseRef(cam *Webcam, r *frameRef) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cam == c.cam && c.held[r.index] > 0 {
+		if c.held[r.index]--; c.held[r.index] == 0 {
+			delete(c.held, r.index)
+		}
+		return c.check(cam.releaseRef(r))
+	}
+
+	for i, f := range c.stale {
+		if f.cam == cam && f.index == r.index {
+			c.stale = append(c.stale[:i], c.stale[i+1:]...)
+			break
+		}
+	}
+	return cam.releaseRef(r)
+}
+
+// ReleaseFrame returns the buffer to the device. Frames obtained before
+// the device was reconnected are unmapped, unknown indexes are ignored.
+func (c *ReconnectingCamera) ReleaseFrame(index uint32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.held[index] > 0 {
+		if c.held[index]--; c.held[index] == 0 {
+			delete(c.held, index)
+		}
+		return c.check(c.cam.ReleaseFrame(index))
+	}
+
+	for i := len(c.stale) - 1; i >= 0; i-- {
+		if f := c.stale[i]; f.index == index {
+			c.stale = append(c.stale[:i], c.stale[i+1:]...)
+
+			// Frame.Release may have released it already
+			if err := f.cam.ReleaseFrame(index); !errors.Is(err, ErrClosed) {
+				return err
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func (c *ReconnectingCamera) GetControls() map[ControlID]Control {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cam := c.connected(); cam != nil {
+		return cam.GetControls()
+	}
+	return make(map[ControlID]Control)
+}
+
+func (c *ReconnectingCamera) GetControl(id ControlID) (int32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cam := c.connected(); cam != nil {
+		value, err := cam.GetControl(id)
+		return value, c.check(err)
+	}
+	return 0, ErrDisconnected
+}
+
+// SetControl sets the control now and after reconnection. Controls
+// are set again in the order they were first set.
+func (c *ReconnectingCamera) SetControl(id ControlID, value int32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cam := c.connected(); cam != nil {
+		if err := c.check(cam.SetControl(id, value)); err != nil && !isDisconnect(err) {
+			return err
+		}
+	}
+	if _, ok := c.values[id]; !ok {
+		c.controls = append(c.controls, id)
+	}
+	c.values[id] = value
+	return nil
+}
+
+// Close closes the camera and stops reconnecting
+func (c *ReconnectingCamera) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == StateClosed {
+		return nil
+	}
+	var err error
+	if c.cam != nil {
+		err = c.detach()
+	}
+	c.setState(StateClosed, nil)
+	close(c.events)
+	c.notify()
+
+	// The watcher stops sending events once closed
+	c.watcher.Close()
+	return err
+}
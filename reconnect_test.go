@@ -0,0 +1,380 @@
+package webcam
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// openTestReconnecting opens a reconnecting camera on the driver, which
+// is closed when the test ends. The returned socket sends uevents.
+func openTestReconnecting(t *testing.T, d *FakeDriver, nodes *testNodes) (*ReconnectingCamera, int) {
+	t.Helper()
+
+	watcher, sock := startTestWatcher(t, nodes)
+	c, err := newReconnectingCamera(DeviceIdentity{Serial: testCamera.Serial}, watcher, func(path string) (*Webcam, error) {
+		return OpenFake(d)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetRetryInterval(0)
+	t.Cleanup(func() { c.Close() })
+	return c, sock
+}
+
+// readReconnecting reads a frame pushed to the driver
+func readReconnecting(t *testing.T, d *FakeDriver, c *ReconnectingCamera, data string) {
+	t.Helper()
+
+	if err := d.PushFrame([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitForFrame(1); err != nil {
+		t.Fatal(err)
+	}
+	if frame, err := c.ReadFrame(); err != nil || string(frame) != data {
+		t.Fatalf("ReadFrame = %q, %v, want %q", frame, err, data)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	d := newTestDriver()
+	c, _ := openTestReconnecting(t, d, &testNodes{devices: []DeviceInfo{testCamera}})
+
+	if _, _, _, err := c.SetImageFormat(V4L2_PIX_FMT_GREY, 320, 240); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetControl(testBrightness, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetBufferCount(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StartStreaming(); err != nil {
+		t.Fatal(err)
+	}
+	readReconnecting(t, d, c, "first")
+
+	d.Unplug()
+	if _, err := c.ReadFrame(); !errors.Is(err, ErrDisconnected) {
+		t.Fatalf("ReadFrame of unplugged device = %v, want ErrDisconnected", err)
+	}
+	if state := c.State(); state != StateDisconnected {
+		t.Fatalf("state = %v, want disconnected", state)
+	}
+	if e := <-c.Events(); e.State != StateDisconnected || e.Err == nil {
+		t.Errorf("event = %+v, want disconnected with the error", e)
+	}
+
+	// Settings and streaming are restored once the device is back
+	d.Replug()
+	if formats := c.GetSupportedFormats(); len(formats) != 2 {
+		t.Fatalf("formats after reconnection = %v", formats)
+	}
+	for _, want := range []ConnectionState{StateReconnecting, StateConnected} {
+		if e := <-c.Events(); e.State != want {
+			t.Errorf("event = %+v, want %v", e, want)
+		}
+	}
+	if code, width, height := d.Format(); code != V4L2_PIX_FMT_GREY || width != 320 || height != 240 {
+		t.Errorf("driver format = %v %dx%d, want GREY 320x240", code, width, height)
+	}
+	if value, _ := d.ControlValue(testBrightness); value != 10 {
+		t.Errorf("brightness = %d, want 10", value)
+	}
+	if !d.Streaming() {
+		t.Fatal("driver is not streaming after reconnection")
+	}
+	readReconnecting(t, d, c, "second")
+}
+
+func TestReconnectRestoresSettings(t *testing.T) {
+	d := newTestDriver()
+	c, _ := openTestReconnecting(t, d, &testNodes{devices: []DeviceInfo{testCamera}})
+
+	// The device is found gone by SetImageFormat
+	d.Unplug()
+	f, width, height, err := c.SetImageFormat(V4L2_PIX_FMT_GREY, 320, 240)
+	if err != nil || f != V4L2_PIX_FMT_GREY || width != 320 || height != 240 {
+		t.Fatalf("SetImageFormat = %v %dx%d, %v", f, width, height, err)
+	}
+	if err := c.SetControl(testBrightness, 10); err != nil {
+		t.Fatal(err)
+	}
+	if state := c.State(); state != StateDisconnected {
+		t.Fatalf("state = %v, want disconnected", state)
+	}
+
+	d.Replug()
+	if formats := c.GetSupportedFormats(); len(formats) != 2 {
+		t.Fatalf("formats after reconnection = %v", formats)
+	}
+	if state := c.State(); state != StateConnected {
+		t.Fatalf("state = %v, want connected", state)
+	}
+	if code, width, height := d.Format(); code != V4L2_PIX_FMT_GREY || width != 320 || height != 240 {
+		t.Errorf("driver format = %v %dx%d, want GREY 320x240", code, width, height)
+	}
+	if value, _ := d.ControlValue(testBrightness); value != 10 {
+		t.Errorf("brightness = %d, want 10", value)
+	}
+}
+
+func TestReconnectErrors(t *testing.T) {
+	d := newTestDriver()
+	c, _ := openTestReconnecting(t, d, &testNodes{devices: []DeviceInfo{testCamera}})
+
+	c.Close()
+	if err := c.WaitForFrame(1); !errors.Is(err, ErrClosed) {
+		t.Errorf("WaitForFrame after Close = %v, want ErrClosed", err)
+	}
+
+	watcher, _ := startTestWatcher(t, &testNodes{devices: []DeviceInfo{testCamera}})
+	_, err := newReconnectingCamera(DeviceIdentity{Serial: "missing"}, watcher, func(path string) (*Webcam, error) {
+		return OpenFake(d)
+	})
+	if !errors.Is(err, ErrDisconnected) {
+		t.Errorf("opening a missing device = %v, want ErrDisconnected", err)
+	}
+}
+
+func TestReconnectReleaseStaleFrame(t *testing.T) {
+	d := newTestDriver()
+	c, _ := openTestReconnecting(t, d, &testNodes{devices: []DeviceInfo{testCamera}})
+	if err := c.SetBufferCount(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StartStreaming(); err != nil {
+		t.Fatal(err)
+	}
+
+	d.PushFrame([]byte("stale"))
+	if err := c.WaitForFrame(1); err != nil {
+		t.Fatal(err)
+	}
+	_, index, err := c.GetFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Unplug()
+	if _, _, err := c.GetFrame(); !errors.Is(err, ErrDisconnected) {
+		t.Fatalf("GetFrame of unplugged device = %v, want ErrDisconnected", err)
+	}
+	if len(c.stale) != 1 {
+		t.Fatalf("%d stale frames, want 1", len(c.stale))
+	}
+	old := c.stale[0].cam
+	if len(old.orphans) != 1 {
+		t.Fatalf("closed device keeps %d buffers, want 1", len(old.orphans))
+	}
+
+	// The frame of the closed device is released after reconnection
+	d.Replug()
+	c.GetSupportedFormats()
+	if err := d.PushFrame([]byte("fresh")); err != nil {
+		t.Fatalf("streaming was not restarted: %v", err)
+	}
+	if err := c.WaitForFrame(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ReleaseFrame(index); err != nil {
+		t.Fatal(err)
+	}
+	if len(old.orphans) != 0 {
+		t.Errorf("buffer of the closed device was not unmapped")
+	}
+
+	frame, err := c.ReadFrame()
+	if err != nil || string(frame) != "fresh" {
+		t.Errorf("ReadFrame after reconnection = %q, %v", frame, err)
+	}
+}
+
+// Frames released with Frame.Release are not kept held by the
+// camera, including the ones obtained before the device was unplugged
+func TestReconnectStream(t *testing.T) {
+	d := newTestDriver()
+	c, _ := openTestReconnecting(t, d, &testNodes{devices: []DeviceInfo{testCamera}})
+	if err := c.SetBufferCount(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StartStreaming(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, errs := Stream(context.Background(), c, StreamOptions{Return: ReturnManual})
+	for _, data := range []string{"first", "second", "held"} {
+		if err := d.PushFrame([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		frame := receiveFrame(t, frames)
+		if string(frame.Data) != data {
+			t.Fatalf("frame = %q, want %q", frame.Data, data)
+		}
+		if data != "held" {
+			frame.Release()
+			continue
+		}
+
+		// The stream ends when the device is unplugged
+		d.Unplug()
+		for range frames {
+		}
+		if err := <-errs; !errors.Is(err, ErrDisconnected) {
+			t.Fatalf("stream ended with %v, want ErrDisconnected", err)
+		}
+		if err := frame.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d.Replug()
+	c.GetSupportedFormats()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	frames, errs = Stream(ctx, c, StreamOptions{Return: ReturnManual})
+	if err := d.PushFrame([]byte("third")); err != nil {
+		t.Fatalf("streaming was not restarted: %v", err)
+	}
+	frame := receiveFrame(t, frames)
+	if string(frame.Data) != "third" {
+		t.Fatalf("frame after reconnection = %q", frame.Data)
+	}
+	frame.Release()
+
+	cancel()
+	for range frames {
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("stream ended with %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.held) != 0 || len(c.stale) != 0 {
+		t.Errorf("held buffers %v and stale frames %v after releasing all", c.held, c.stale)
+	}
+}
+
+// The device is reopened when the watcher reports it was added,
+// and not before
+func TestReconnectWatch(t *testing.T) {
+	d := newTestDriver()
+	nodes := &testNodes{devices: []DeviceInfo{testCamera}}
+	opens := 0
+	watcher, sock := startTestWatcher(t, nodes)
+	c, err := newReconnectingCamera(DeviceIdentity{Serial: testCamera.Serial}, watcher, func(path string) (*Webcam, error) {
+		opens++
+		if path != testCamera.Path {
+			t.Errorf("opened %s", path)
+		}
+		return OpenFake(d)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetRetryInterval(0)
+	if err := c.SetBufferCount(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StartStreaming(); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Unplug()
+	nodes.set()
+	sendUevent(t, sock, "remove", "video0")
+	if _, _, err := c.GetFrame(); !errors.Is(err, ErrDisconnected) {
+		t.Fatalf("GetFrame of unplugged device = %v, want ErrDisconnected", err)
+	}
+	waitForNodes(t, c, 0)
+
+	var timeout *Timeout
+	if err := c.WaitForFrame(0); !errors.As(err, &timeout) {
+		t.Fatalf("WaitForFrame while unplugged = %v, want timeout", err)
+	}
+	if opens != 1 {
+		t.Fatalf("device opened %d times while unplugged", opens-1)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- c.WaitForFrame(5) }()
+
+	d.Replug()
+	nodes.set(testCamera)
+	sendUevent(t, sock, "add", "video0")
+	for e := range c.Events() {
+		if e.State == StateConnected {
+			break
+		}
+	}
+	if err := d.PushFrame([]byte("frame")); err != nil {
+		t.Fatalf("streaming was not restarted: %v", err)
+	}
+	if err := <-waited; err != nil {
+		t.Fatalf("WaitForFrame = %v", err)
+	}
+	if opens != 2 {
+		t.Errorf("device opened %d times, want 2", opens)
+	}
+}
+
+// The timeout of WaitForFrame includes the time spent reconnecting
+func TestReconnectWaitTimeout(t *testing.T) {
+	d := newTestDriver()
+	nodes := &testNodes{devices: []DeviceInfo{testCamera}}
+	c, sock := openTestReconnecting(t, d, nodes)
+	if err := c.SetBufferCount(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StartStreaming(); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Unplug()
+	nodes.set()
+	sendUevent(t, sock, "remove", "video0")
+	if _, _, err := c.GetFrame(); !errors.Is(err, ErrDisconnected) {
+		t.Fatalf("GetFrame of unplugged device = %v, want ErrDisconnected", err)
+	}
+	waitForNodes(t, c, 0)
+
+	start := time.Now()
+	waited := make(chan error, 1)
+	go func() { waited <- c.WaitForFrame(1) }()
+
+	time.Sleep(700 * time.Millisecond)
+	d.Replug()
+	nodes.set(testCamera)
+	sendUevent(t, sock, "add", "video0")
+
+	var timeout *Timeout
+	if err := <-waited; !errors.As(err, &timeout) {
+		t.Fatalf("WaitForFrame without a frame = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+		t.Errorf("WaitForFrame(1) returned after %v", elapsed)
+	}
+	if state := c.State(); state != StateConnected {
+		t.Errorf("state = %v, want connected", state)
+	}
+}
+
+// waitForNodes waits until the camera knows of count nodes
+func waitForNodes(t *testing.T, c *ReconnectingCamera, count int) {
+	t.Helper()
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		n := len(c.present)
+		c.mu.Unlock()
+		if n == count {
+			return
+		}
+	}
+	t.Fatalf("camera doesn't know of %d nodes", count)
+}
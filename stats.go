@@ -0,0 +1,134 @@
+package webcam
+
+import (
+	"sync"
+	"time"
+)
+
+// Weight of a new sample in moving averages, same as RFC 3550 jitter uses
+const statsSmoothing = 1.0 / 16
+
+// CaptureStats is a snapshot of capture statistics
+// collected since streaming was started
+type CaptureStats struct {
+	// Number of frames dequeued from the driver
+	Frames uint64
+
+	// Number of frames the driver skipped in the sequence,
+	// e.g. because no buffer was queued or the bus was overloaded
+	Dropped uint64
+
+	// Number of frames flagged with V4L2_BUF_FLAG_ERROR
+	Errors uint64
+
+	// Sequence number of the last frame
+	LastSequence uint32
+
+	// Measured frame rate
+	FPS float64
+
+	// Mean deviation of the time between frames from its average
+	Jitter time.Duration
+
+	// Average and maximum time a buffer stayed queued
+	// in the driver before it was dequeued
+	QueueTime    time.Duration
+	MaxQueueTime time.Duration
+}
+
+type captureStats struct {
+	mu       sync.Mutex
+	stats    CaptureStats
+	last     time.Duration
+	interval float64
+	jitter   float64
+	queue    float64
+	queuedAt []time.Time
+}
+
+func (s *captureStats) reset(bufcount uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats = CaptureStats{}
+	s.last = 0
+	s.interval = 0
+	s.jitter = 0
+	s.queue = 0
+	s.queuedAt = make([]time.Time, bufcount)
+}
+
+func (s *captureStats) enqueued(index uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if int(index) < len(s.queuedAt) {
+		s.queuedAt[index] = time.Now()
+	}
+}
+
+func (s *captureStats) dequeued(f *Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if int(f.Index) < len(s.queuedAt) && !s.queuedAt[f.Index].IsZero() {
+		q := float64(now.Sub(s.queuedAt[f.Index]))
+		s.queue = smooth(s.queue, q, s.stats.Frames == 0)
+		if d := time.Duration(q); d > s.stats.MaxQueueTime {
+			s.stats.MaxQueueTime = d
+		}
+		s.queuedAt[f.Index] = time.Time{}
+	}
+
+	// Frames without a timestamp are measured by the time they were dequeued
+	ts := f.Timestamp
+	if f.TimestampType() == V4L2_BUF_FLAG_TIMESTAMP_COPY || ts == 0 {
+		ts = time.Duration(now.UnixNano())
+	}
+
+	if s.stats.Frames > 0 {
+		// Sequence numbers wrap around, a jump backwards means
+		// the driver restarted the counter
+		if gap := f.Sequence - s.stats.LastSequence; gap > 1 && gap < 1<<31 {
+			s.stats.Dropped += uint64(gap - 1)
+		}
+		if interval := float64(ts - s.last); interval > 0 {
+			if s.interval > 0 {
+				deviation := interval - s.interval
+				if deviation < 0 {
+					deviation = -deviation
+				}
+				s.jitter = smooth(s.jitter, deviation, false)
+			}
+			s.interval = smooth(s.interval, interval, s.interval == 0)
+		}
+	}
+
+	s.stats.Frames++
+	if f.IsError() {
+		s.stats.Errors++
+	}
+	s.stats.LastSequence = f.Sequence
+	s.last = ts
+}
+
+func (s *captureStats) snapshot() CaptureStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	if s.interval > 0 {
+		stats.FPS = float64(time.Second) / s.interval
+	}
+	stats.Jitter = time.Duration(s.jitter)
+	stats.QueueTime = time.Duration(s.queue)
+	return stats
+}
+
+func smooth(average, sample float64, first bool) float64 {
+	if first {
+		return sample
+	}
+	return average + (sample-average)*statsSmoothing
+}
@@ -0,0 +1,47 @@
+package webcam
+
+import "testing"
+
+func TestStats(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 2)
+
+	pushTestFrame(t, d, w, "first")
+	if _, err := w.ReadFrame(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The third frame is dropped, as no buffer is queued
+	d.PushFrame([]byte("second"))
+	d.PushFrameFlags([]byte("error"), V4L2_BUF_FLAG_ERROR)
+	d.PushFrame([]byte("dropped"))
+	for i := 0; i < 2; i++ {
+		if _, err := w.ReadFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pushTestFrame(t, d, w, "last")
+	if _, err := w.ReadFrame(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := w.Stats()
+	if stats.Frames != 4 || stats.Dropped != 1 || stats.Errors != 1 || stats.LastSequence != 4 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats.FPS <= 0 || stats.MaxQueueTime <= 0 {
+		t.Errorf("timing = %+v", stats)
+	}
+
+	// Statistics restart with streaming
+	if err := w.StopStreaming(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.StartStreaming(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := w.Stats(); stats.Frames != 0 || stats.Dropped != 0 {
+		t.Errorf("stats after restart = %+v", stats)
+	}
+}
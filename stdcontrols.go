@@ -0,0 +1,330 @@
+package webcam
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ExposureMode is an item of the V4L2_CID_EXPOSURE_AUTO menu
+type ExposureMode int32
+
+const (
+	ExposureAuto             = ExposureMode(V4L2_EXPOSURE_AUTO)
+	ExposureManual           = ExposureMode(V4L2_EXPOSURE_MANUAL)
+	ExposureShutterPriority  = ExposureMode(V4L2_EXPOSURE_SHUTTER_PRIORITY)
+	ExposureAperturePriority = ExposureMode(V4L2_EXPOSURE_APERTURE_PRIORITY)
+)
+
+// PowerLineFrequency is an item of the V4L2_CID_POWER_LINE_FREQUENCY menu
+type PowerLineFrequency int32
+
+const (
+	PowerLineDisabled = PowerLineFrequency(V4L2_CID_POWER_LINE_FREQUENCY_DISABLED)
+	PowerLine50Hz     = PowerLineFrequency(V4L2_CID_POWER_LINE_FREQUENCY_50HZ)
+	PowerLine60Hz     = PowerLineFrequency(V4L2_CID_POWER_LINE_FREQUENCY_60HZ)
+	PowerLineAuto     = PowerLineFrequency(V4L2_CID_POWER_LINE_FREQUENCY_AUTO)
+)
+
+// Unit of V4L2_CID_EXPOSURE_ABSOLUTE
+const exposureUnit = 100 * time.Microsecond
+
+// Units of V4L2_CID_PAN_ABSOLUTE and V4L2_CID_TILT_ABSOLUTE per degree
+const arcSeconds = 3600
+
+// Controls accesses the standard controls of a webcam with the types and
+// units they are defined with. Values are checked against the range the
+// driver reports before they are set, failing with *ControlRangeError.
+// Controls the device lacks fail with an error matching ErrUnsupported.
+type Controls struct {
+	w *Webcam
+}
+
+// Controls returns the standard controls of the webcam
+func (w *Webcam) Controls() *Controls {
+	return &Controls{w}
+}
+
+// Brightness returns the black level of the picture
+func (c *Controls) Brightness() (int32, error) {
+	return c.int(V4L2_CID_BRIGHTNESS)
+}
+
+// SetBrightness sets the black level of the picture
+func (c *Controls) SetBrightness(value int32) error {
+	return c.set(V4L2_CID_BRIGHTNESS, int64(value))
+}
+
+// Contrast returns the contrast of the picture
+func (c *Controls) Contrast() (int32, error) {
+	return c.int(V4L2_CID_CONTRAST)
+}
+
+// SetContrast sets the contrast of the picture
+func (c *Controls) SetContrast(value int32) error {
+	return c.set(V4L2_CID_CONTRAST, int64(value))
+}
+
+// Saturation returns the color saturation of the picture
+func (c *Controls) Saturation() (int32, error) {
+	return c.int(V4L2_CID_SATURATION)
+}
+
+// SetSaturation sets the color saturation of the picture
+func (c *Controls) SetSaturation(value int32) error {
+	return c.set(V4L2_CID_SATURATION, int64(value))
+}
+
+// Hue returns the hue or color balance of the picture
+func (c *Controls) Hue() (int32, error) {
+	return c.int(V4L2_CID_HUE)
+}
+
+// SetHue sets the hue or color balance of the picture
+func (c *Controls) SetHue(value int32) error {
+	return c.set(V4L2_CID_HUE, int64(value))
+}
+
+// Gamma returns the gamma adjustment of the picture
+func (c *Controls) Gamma() (int32, error) {
+	return c.int(V4L2_CID_GAMMA)
+}
+
+// SetGamma sets the gamma adjustment of the picture
+func (c *Controls) SetGamma(value int32) error {
+	return c.set(V4L2_CID_GAMMA, int64(value))
+}
+
+// Gain returns the gain, which is set by the device
+// if automatic exposure is on
+func (c *Controls) Gain() (int32, error) {
+	return c.int(V4L2_CID_GAIN)
+}
+
+// SetGain sets the gain
+func (c *Controls) SetGain(value int32) error {
+	return c.set(V4L2_CID_GAIN, int64(value))
+}
+
+// Sharpness returns the sharpness filter level
+func (c *Controls) Sharpness() (int32, error) {
+	return c.int(V4L2_CID_SHARPNESS)
+}
+
+// SetSharpness sets the sharpness filter level
+func (c *Controls) SetSharpness(value int32) error {
+	return c.set(V4L2_CID_SHARPNESS, int64(value))
+}
+
+// BacklightCompensation returns the backlight compensation level
+func (c *Controls) BacklightCompensation() (int32, error) {
+	return c.int(V4L2_CID_BACKLIGHT_COMPENSATION)
+}
+
+// SetBacklightCompensation sets the backlight compensation level
+func (c *Controls) SetBacklightCompensation(value int32) error {
+	return c.set(V4L2_CID_BACKLIGHT_COMPENSATION, int64(value))
+}
+
+// AutoWhiteBalance reports whether automatic white balance is on
+func (c *Controls) AutoWhiteBalance() (bool, error) {
+	return c.bool(V4L2_CID_AUTO_WHITE_BALANCE)
+}
+
+// SetAutoWhiteBalance turns automatic white balance on or off
+func (c *Controls) SetAutoWhiteBalance(on bool) error {
+	return c.setBool(V4L2_CID_AUTO_WHITE_BALANCE, on)
+}
+
+// WhiteBalanceTemperature returns the white balance in Kelvin
+func (c *Controls) WhiteBalanceTemperature() (int32, error) {
+	return c.int(V4L2_CID_WHITE_BALANCE_TEMPERATURE)
+}
+
+// SetWhiteBalanceTemperature sets the white balance in Kelvin.
+// Automatic white balance must be off.
+func (c *Controls) SetWhiteBalanceTemperature(kelvin int32) error {
+	return c.set(V4L2_CID_WHITE_BALANCE_TEMPERATURE, int64(kelvin))
+}
+
+// PowerLineFrequency returns the frequency of the mains
+// which the flicker filter is set for
+func (c *Controls) PowerLineFrequency() (PowerLineFrequency, error) {
+	value, err := c.int(V4L2_CID_POWER_LINE_FREQUENCY)
+	return PowerLineFrequency(value), err
+}
+
+// SetPowerLineFrequency sets the flicker filter
+// to the frequency of the mains
+func (c *Controls) SetPowerLineFrequency(value PowerLineFrequency) error {
+	return c.set(V4L2_CID_POWER_LINE_FREQUENCY, int64(value))
+}
+
+// ExposureMode returns whether exposure time and iris are automatic
+func (c *Controls) ExposureMode() (ExposureMode, error) {
+	value, err := c.int(V4L2_CID_EXPOSURE_AUTO)
+	return ExposureMode(value), err
+}
+
+// SetExposureMode sets whether exposure time and iris are automatic
+func (c *Controls) SetExposureMode(mode ExposureMode) error {
+	return c.set(V4L2_CID_EXPOSURE_AUTO, int64(mode))
+}
+
+// Exposure returns the exposure time
+func (c *Controls) Exposure() (time.Duration, error) {
+	value, err := c.int(V4L2_CID_EXPOSURE_ABSOLUTE)
+	return time.Duration(value) * exposureUnit, err
+}
+
+// SetExposure sets the exposure time, which the device supports in
+// steps of 100µs. The exposure mode must be manual or shutter priority.
+func (c *Controls) SetExposure(d time.Duration) error {
+	return c.set(V4L2_CID_EXPOSURE_ABSOLUTE, int64((d+exposureUnit/2)/exposureUnit))
+}
+
+// ExposureAutoPriority reports whether automatic exposure
+// may lower the frame rate
+func (c *Controls) ExposureAutoPriority() (bool, error) {
+	return c.bool(V4L2_CID_EXPOSURE_AUTO_PRIORITY)
+}
+
+// SetExposureAutoPriority sets whether automatic
+// exposure may lower the frame rate
+func (c *Controls) SetExposureAutoPriority(on bool) error {
+	return c.setBool(V4L2_CID_EXPOSURE_AUTO_PRIORITY, on)
+}
+
+// AutoFocus reports whether continuous automatic focus is on
+func (c *Controls) AutoFocus() (bool, error) {
+	return c.bool(V4L2_CID_FOCUS_AUTO)
+}
+
+// SetAutoFocus turns continuous automatic focus on or off
+func (c *Controls) SetAutoFocus(on bool) error {
+	return c.setBool(V4L2_CID_FOCUS_AUTO, on)
+}
+
+// Focus returns the focal point distance
+// in units defined by the device
+func (c *Controls) Focus() (int32, error) {
+	return c.int(V4L2_CID_FOCUS_ABSOLUTE)
+}
+
+// SetFocus sets the focal point distance in units defined
+// by the device. Automatic focus must be off.
+func (c *Controls) SetFocus(value int32) error {
+	return c.set(V4L2_CID_FOCUS_ABSOLUTE, int64(value))
+}
+
+// Zoom returns the focal length in units defined by the device
+func (c *Controls) Zoom() (int32, error) {
+	return c.int(V4L2_CID_ZOOM_ABSOLUTE)
+}
+
+// SetZoom sets the focal length in units defined by the device
+func (c *Controls) SetZoom(value int32) error {
+	return c.set(V4L2_CID_ZOOM_ABSOLUTE, int64(value))
+}
+
+// Pan returns the horizontal rotation in degrees,
+// positive values to the right
+func (c *Controls) Pan() (float64, error) {
+	return c.degrees(V4L2_CID_PAN_ABSOLUTE)
+}
+
+// SetPan rotates the camera horizontally to the
+// angle in degrees, positive values to the right
+func (c *Controls) SetPan(degrees float64) error {
+	return c.setDegrees(V4L2_CID_PAN_ABSOLUTE, degrees)
+}
+
+// Tilt returns the vertical rotation in degrees, positive values up
+func (c *Controls) Tilt() (float64, error) {
+	return c.degrees(V4L2_CID_TILT_ABSOLUTE)
+}
+
+// SetTilt rotates the camera vertically to the
+// angle in degrees, positive values up
+func (c *Controls) SetTilt(degrees float64) error {
+	return c.setDegrees(V4L2_CID_TILT_ABSOLUTE, degrees)
+}
+
+func (c *Controls) int(id uint32) (int32, error) {
+	w := c.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	value, err := getControl(w.dev, id)
+
+	if errors.Is(err, unix.EINVAL) {
+		return 0, unsupportedControl(id, err)
+	}
+	return value, err
+}
+
+func (c *Controls) bool(id uint32) (bool, error) {
+	value, err := c.int(id)
+	return value != 0, err
+}
+
+func (c *Controls) degrees(id uint32) (float64, error) {
+	value, err := c.int(id)
+	return float64(value) / arcSeconds, err
+}
+
+func (c *Controls) setBool(id uint32, on bool) error {
+	var value int64
+	if on {
+		value = 1
+	}
+	return c.set(id, value)
+}
+
+func (c *Controls) setDegrees(id uint32, degrees float64) error {
+	return c.set(id, int64(math.Round(degrees*arcSeconds)))
+}
+
+// set checks the value against the range of the control and sets it
+func (c *Controls) set(id uint32, value int64) error {
+	w := c.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	query, err := queryControl(w.dev, id)
+
+	if errors.Is(err, unix.EINVAL) || (err == nil && query.flags&V4L2_CTRL_FLAG_DISABLED != 0) {
+		return unsupportedControl(id, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	name := CToGoString(query.name[:])
+	err = checkRange(ControlID(id), name, value, int64(query.minimum), int64(query.maximum), int64(query.step))
+
+	if err != nil {
+		return err
+	}
+
+	return setControl(w.dev, id, int32(value))
+}
+
+func unsupportedControl(id uint32, err error) error {
+	msg := fmt.Sprintf("Control 0x%08x is not supported", id)
+	if err != nil {
+		msg += ": " + err.Error()
+	}
+	return &stateError{msg, ErrUnsupported}
+}
@@ -0,0 +1,87 @@
+package webcam
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStandardControls(t *testing.T) {
+	d := newTestDriver()
+	d.AddControl(FakeControl{ID: ControlID(V4L2_CID_EXPOSURE_AUTO), Name: "Auto Exposure", Type: V4L2_CTRL_TYPE_MENU, Max: 3, Default: 3,
+		Menu: []string{"Auto Mode", "Manual Mode", "Shutter Priority Mode", "Aperture Priority Mode"}})
+	d.AddControl(FakeControl{ID: ControlID(V4L2_CID_EXPOSURE_ABSOLUTE), Name: "Exposure Time, Absolute", Type: V4L2_CTRL_TYPE_INTEGER, Min: 1, Max: 5000, Step: 1, Default: 156})
+	d.AddControl(FakeControl{ID: ControlID(V4L2_CID_FOCUS_AUTO), Name: "Focus, Auto", Type: V4L2_CTRL_TYPE_BOOLEAN, Max: 1, Step: 1, Default: 1})
+	d.AddControl(FakeControl{ID: ControlID(V4L2_CID_PAN_ABSOLUTE), Name: "Pan, Absolute", Type: V4L2_CTRL_TYPE_INTEGER, Min: -36000, Max: 36000, Step: 3600})
+	w := openTestDriver(t, d)
+	c := w.Controls()
+
+	if err := c.SetBrightness(-20); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := c.Brightness(); err != nil || value != -20 {
+		t.Errorf("Brightness = %d, %v", value, err)
+	}
+
+	if mode, err := c.ExposureMode(); err != nil || mode != ExposureAperturePriority {
+		t.Errorf("ExposureMode = %v, %v", mode, err)
+	}
+	if err := c.SetExposureMode(ExposureManual); err != nil {
+		t.Fatal(err)
+	}
+
+	// Exposure is set in units of 100µs
+	if err := c.SetExposure(20 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := d.ControlValue(ControlID(V4L2_CID_EXPOSURE_ABSOLUTE)); value != 200 {
+		t.Errorf("driver exposure = %d, want 200", value)
+	}
+	if exposure, err := c.Exposure(); err != nil || exposure != 20*time.Millisecond {
+		t.Errorf("Exposure = %v, %v", exposure, err)
+	}
+
+	if err := c.SetAutoFocus(false); err != nil {
+		t.Fatal(err)
+	}
+	if on, err := c.AutoFocus(); err != nil || on {
+		t.Errorf("AutoFocus = %v, %v", on, err)
+	}
+
+	// Pan is set in arc seconds
+	if err := c.SetPan(-5); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := d.ControlValue(ControlID(V4L2_CID_PAN_ABSOLUTE)); value != -18000 {
+		t.Errorf("driver pan = %d, want -18000", value)
+	}
+	if degrees, err := c.Pan(); err != nil || degrees != -5 {
+		t.Errorf("Pan = %v, %v", degrees, err)
+	}
+}
+
+func TestStandardControlErrors(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	c := w.Controls()
+
+	var rangeErr *ControlRangeError
+	if err := c.SetBrightness(100); !errors.As(err, &rangeErr) || rangeErr.Max != 64 || rangeErr.Name != "Brightness" {
+		t.Errorf("SetBrightness out of range = %v, want *ControlRangeError", err)
+	}
+	if value, _ := d.ControlValue(testBrightness); value != 0 {
+		t.Errorf("brightness set to %d out of range", value)
+	}
+
+	if err := c.SetZoom(1); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("SetZoom without the control = %v, want ErrUnsupported", err)
+	}
+	if _, err := c.Tilt(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Tilt without the control = %v, want ErrUnsupported", err)
+	}
+
+	w.Close()
+	if _, err := c.Brightness(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Brightness after Close = %v, want ErrClosed", err)
+	}
+}
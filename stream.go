@@ -0,0 +1,268 @@
+package webcam
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// ReturnPolicy tells when buffers of frames delivered by Stream
+// are returned to the driver
+type ReturnPolicy int
+
+const (
+	// The buffer of a frame is returned when the consumer receives the
+	// next frame, or when the stream ends
+	ReturnOnNext ReturnPolicy = iota
+
+	// The consumer returns buffers with Frame.Release. Frames dropped
+	// by the stream are returned by the stream itself.
+	ReturnManual
+)
+
+// OverflowPolicy tells what Stream does when the consumer doesn't keep up
+type OverflowPolicy int
+
+const (
+	// Capture waits for the consumer. The driver drops frames
+	// when it runs out of buffers.
+	OverflowBlock OverflowPolicy = iota
+
+	// The oldest frame waiting for the consumer is dropped
+	OverflowDropOldest
+
+	// All frames waiting for the consumer are dropped,
+	// so that it gets the latest one
+	OverflowKeepLatest
+)
+
+// StreamOptions configures Stream. The zero value delivers frames
+// without copying, one at a time, and blocks capture on a slow consumer.
+type StreamOptions struct {
+	// Copy frames out of the driver buffers, which are then returned
+	// immediately. Copied frames need no releasing and their DMABUF
+	// is -1. Otherwise frames refer to the buffers, see Return.
+	Copy bool
+
+	// When buffers of frames which are not copied are returned
+	Return ReturnPolicy
+
+	// What happens when the consumer is slow
+	Overflow OverflowPolicy
+
+	// Number of frames waiting for the consumer, 1 if zero. Without
+	// copying, the buffer count must be larger than Depth + 1, as the
+	// driver needs a buffer to capture into.
+	Depth int
+}
+
+// Stream reads frames in a goroutine and sends them on the returned frame
+// channel until the context is done. Streaming must be started first.
+// The error which ended the stream, e.g. ErrNotStreaming after
+// StopStreaming, is sent on the error channel. Both channels are closed
+// when the stream ends.
+func (w *Webcam) Stream(ctx context.Context, opts StreamOptions) (<-chan *Frame, <-chan error) {
+	return Stream(ctx, w, opts)
+}
+
+// Stream is like Webcam.Stream, but reads frames from any Camera. Cameras
+// which can't wait for a frame with a context, unlike Webcam, are waited
+// for a second at a time, so the stream may end up to a second after the
+// context is done.
+func Stream(ctx context.Context, cam Camera, opts StreamOptions) (<-chan *Frame, <-chan error) {
+	if opts.Depth <= 0 {
+		opts.Depth = 1
+	}
+
+	s := &stream{
+		cam:    cam,
+		opts:   opts,
+		frames: make(chan *Frame),
+		errs:   make(chan error, 1),
+	}
+
+	captured := make(chan *Frame)
+	failed := make(chan error, 1)
+	done := make(chan struct{})
+	go s.capture(ctx, captured, failed, done)
+	go s.dispatch(ctx, captured, failed, done)
+
+	return s.frames, s.errs
+}
+
+// stream hands frames read by capture over to the consumer in dispatch.
+// Frames are sent on the unbuffered frames channel, so that dispatch
+// knows when the consumer received a frame.
+type stream struct {
+	cam    Camera
+	opts   StreamOptions
+	frames chan *Frame
+	errs   chan error
+
+	// Frames waiting for the consumer
+	queue []*Frame
+
+	// Frame received last with ReturnOnNext
+	last *Frame
+}
+
+func (s *stream) capture(ctx context.Context, captured chan<- *Frame, failed chan<- error, done <-chan struct{}) {
+	defer close(captured)
+
+	for {
+		frame, err := s.read(ctx)
+
+		if err != nil {
+			if ctx.Err() == nil {
+				failed <- err
+			}
+			return
+		}
+
+		select {
+		case captured <- frame:
+		case <-done:
+			frame.Release()
+			return
+		}
+	}
+}
+
+func (s *stream) read(ctx context.Context) (*Frame, error) {
+	for {
+		err := s.wait(ctx)
+
+		var timeout *Timeout
+		if errors.As(err, &timeout) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		frame, err := s.cam.GetFrameWithMetadata()
+
+		// Another reader may have taken the frame
+		if errors.Is(err, unix.EAGAIN) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if s.opts.Copy {
+			c := cloneFrame(frame)
+			frame.Release()
+			frame = c
+		}
+		return frame, nil
+	}
+}
+
+// contextWaiter is implemented by cameras which can wait for
+// a frame until a context is done, like Webcam
+type contextWaiter interface {
+	WaitForFrameContext(ctx context.Context) error
+}
+
+func (s *stream) wait(ctx context.Context) error {
+	if w, ok := s.cam.(contextWaiter); ok {
+		return w.WaitForFrameContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.cam.WaitForFrame(1)
+}
+
+func (s *stream) dispatch(ctx context.Context, captured <-chan *Frame, failed <-chan error, done chan<- struct{}) {
+	in := captured
+
+	defer func() {
+		close(done)
+		for frame := range captured {
+			frame.Release()
+		}
+		for _, frame := range s.queue {
+			frame.Release()
+		}
+		if s.last != nil {
+			s.last.Release()
+		}
+
+		select {
+		case err := <-failed:
+			s.errs <- err
+		default:
+		}
+		close(s.frames)
+		close(s.errs)
+	}()
+
+	for {
+		// Frames captured before an error are still delivered
+		if in == nil && len(s.queue) == 0 {
+			return
+		}
+
+		var out chan<- *Frame
+		var next *Frame
+		if len(s.queue) > 0 {
+			out = s.frames
+			next = s.queue[0]
+		}
+
+		receive := in
+		if len(s.queue) >= s.opts.Depth && s.opts.Overflow == OverflowBlock {
+			receive = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case frame, ok := <-receive:
+			if !ok {
+				in = nil
+				continue
+			}
+			s.push(frame)
+		case out <- next:
+			s.queue = s.queue[1:]
+			if !s.opts.Copy && s.opts.Return == ReturnOnNext {
+				if s.last != nil {
+					s.last.Release()
+				}
+				s.last = next
+			}
+		}
+	}
+}
+
+// push queues a frame for the consumer, dropping frames if the queue is full
+func (s *stream) push(frame *Frame) {
+	if len(s.queue) >= s.opts.Depth {
+		drop := 1
+		if s.opts.Overflow == OverflowKeepLatest {
+			drop = len(s.queue)
+		}
+		for _, f := range s.queue[:drop] {
+			f.Release()
+		}
+		s.queue = s.queue[drop:]
+	}
+	s.queue = append(s.queue, frame)
+}
+
+// cloneFrame copies a frame and its planes out of the buffer
+func cloneFrame(frame *Frame) *Frame {
+	c := *frame
+	c.Planes = make([][]byte, len(frame.Planes))
+	for i, plane := range frame.Planes {
+		c.Planes[i] = append([]byte(nil), plane...)
+	}
+	c.Data = c.Planes[0]
+	c.DMABUF = -1
+	c.ref = nil
+	return &c
+}
@@ -0,0 +1,112 @@
+package webcam
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// receiveFrame receives a frame from the stream
+func receiveFrame(t *testing.T, frames <-chan *Frame) *Frame {
+	t.Helper()
+
+	select {
+	case frame, ok := <-frames:
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		return frame
+	case <-time.After(5 * time.Second):
+		t.Fatal("frame was not received")
+	}
+	return nil
+}
+
+func TestStreamKeepLatest(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	frames, _ := w.Stream(ctx, StreamOptions{Copy: true, Overflow: OverflowKeepLatest})
+
+	// Copied frames return buffers right away
+	for i, data := range []string{"first", "second", "third"} {
+		if err := d.PushFrame([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		for w.Stats().Frames != uint64(i+1) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	frame := receiveFrame(t, frames)
+	if string(frame.Data) == "first" {
+		t.Fatal("received the first frame, which was not the latest")
+	}
+	if frame.DMABUF != -1 || frame.Release() != nil {
+		t.Errorf("copied frame refers to a buffer")
+	}
+}
+
+func TestStreamEnds(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 3)
+
+	frames, errs := w.Stream(context.Background(), StreamOptions{})
+	if err := d.PushFrame([]byte("frame")); err != nil {
+		t.Fatal(err)
+	}
+	frame := receiveFrame(t, frames)
+	if string(frame.Data) != "frame" {
+		t.Fatalf("frame = %q", frame.Data)
+	}
+
+	if err := w.StopStreaming(); err != nil {
+		t.Fatal(err)
+	}
+	for range frames {
+	}
+	if err := <-errs; !errors.Is(err, ErrNotStreaming) {
+		t.Errorf("stream ended with %v, want ErrNotStreaming", err)
+	}
+}
+
+// plainCamera hides the methods of Webcam which are not part of Camera
+type plainCamera struct {
+	Camera
+}
+
+func TestStreamCamera(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	frames, errs := Stream(ctx, plainCamera{w}, StreamOptions{})
+
+	for _, data := range []string{"first", "second"} {
+		if err := d.PushFrame([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case frame := <-frames:
+			if string(frame.Data) != data {
+				t.Fatalf("frame = %q, want %q", frame.Data, data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("frame was not received")
+		}
+	}
+
+	cancel()
+	for range frames {
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("stream ended with %v", err)
+	}
+}
@@ -0,0 +1,340 @@
+package webcam
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"time"
+)
+
+// TestPatternConfig describes the virtual camera created by OpenTestPattern.
+// Zero values select the defaults.
+type TestPatternConfig struct {
+	// Frame sizes supported by every format.
+	// Default is 320x240, 640x480 and 1280x720.
+	Sizes []FrameSize
+
+	// Frame rates supported by every format and frame size.
+	// Default is 5, 10, 15, 25, 30 and 60 frames per second.
+	FrameRates []FrameRate
+
+	// Initial frame rate, default is 30 frames per second.
+	Framerate float32
+}
+
+// Formats produced by the test pattern camera, the first one is the default
+var testPatternFormats = []struct {
+	code        PixelFormat
+	description string
+}{
+	{V4L2_PIX_FMT_YUYV, "YUYV 4:2:2"},
+	{V4L2_PIX_FMT_MJPEG, "Motion-JPEG"},
+	{V4L2_PIX_FMT_RGB24, "24-bit RGB 8-8-8"},
+	{V4L2_PIX_FMT_GREY, "8-bit Greyscale"},
+	{V4L2_PIX_FMT_NV12, "Y/CbCr 4:2:0"},
+	{V4L2_PIX_FMT_YUV420, "Planar YUV 4:2:0"},
+}
+
+// Color bars from left to right: white, yellow, cyan, green,
+// magenta, red, blue and black
+var testPatternBars = [][3]uint8{
+	{235, 235, 235}, {235, 235, 16}, {16, 235, 235}, {16, 235, 16},
+	{235, 16, 235}, {235, 16, 16}, {16, 16, 235}, {16, 16, 16},
+}
+
+// 3x5 glyphs used to print the frame counter and the timestamp
+var testPatternGlyphs = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7}, '1': {2, 6, 2, 2, 7}, '2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 7, 1, 7}, '4': {5, 5, 7, 1, 1}, '5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7}, '7': {7, 1, 1, 1, 1}, '8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7}, ':': {0, 2, 0, 2, 0}, '.': {0, 0, 0, 0, 2},
+}
+
+// OpenTestPattern opens a virtual camera which generates color bars
+// with a moving box, a frame counter and the time of capture. The camera
+// offers YUYV, MJPEG, RGB24, GREY, NV12 and YUV420 formats and paces
+// frames according to the selected frame rate. Brightness, contrast and
+// saturation controls are applied to the generated pixels.
+//
+// Frames are generated by a background goroutine that stops when
+// the webcam is closed.
+func OpenTestPattern(config TestPatternConfig) (*Webcam, error) {
+	sizes := config.Sizes
+	if len(sizes) == 0 {
+		sizes = []FrameSize{
+			{MinWidth: 320, MaxWidth: 320, MinHeight: 240, MaxHeight: 240},
+			{MinWidth: 640, MaxWidth: 640, MinHeight: 480, MaxHeight: 480},
+			{MinWidth: 1280, MaxWidth: 1280, MinHeight: 720, MaxHeight: 720},
+		}
+	}
+	rates := config.FrameRates
+	if len(rates) == 0 {
+		for _, fps := range []uint32{60, 30, 25, 15, 10, 5} {
+			rates = append(rates, FrameRate{
+				MinNumerator:   1,
+				MaxNumerator:   1,
+				MinDenominator: fps,
+				MaxDenominator: fps,
+			})
+		}
+	}
+
+	d := NewFakeDriver()
+	d.Driver = "testpattern"
+	d.Card = "Test Pattern Camera"
+	d.BusInfo = "platform:testpattern"
+	d.Capabilities |= V4L2_CAP_READWRITE
+	for _, f := range testPatternFormats {
+		d.AddFormat(f.code, f.description, sizes...)
+		d.SetFrameRates(f.code, rates...)
+	}
+	d.AddControl(FakeControl{ID: ControlID(V4L2_CID_BRIGHTNESS), Name: "Brightness", Type: V4L2_CTRL_TYPE_INTEGER, Min: -128, Max: 127, Step: 1, Default: 0})
+	d.AddControl(FakeControl{ID: ControlID(V4L2_CID_CONTRAST), Name: "Contrast", Type: V4L2_CTRL_TYPE_INTEGER, Min: 0, Max: 200, Step: 1, Default: 100})
+	d.AddControl(FakeControl{ID: ControlID(V4L2_CID_SATURATION), Name: "Saturation", Type: V4L2_CTRL_TYPE_INTEGER, Min: 0, Max: 200, Step: 1, Default: 100})
+
+	w, err := OpenFake(d)
+	if err != nil {
+		return nil, err
+	}
+	if config.Framerate > 0 {
+		if err := w.SetFramerate(config.Framerate); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+
+	d.mu.Lock()
+	closed := d.closed
+	d.mu.Unlock()
+
+	go (&testPattern{drv: d}).run(closed)
+	return w, nil
+}
+
+type testPattern struct {
+	drv   *FakeDriver
+	count uint64
+
+	// Full resolution planes the pattern is rendered to
+	y, cb, cr []uint8
+}
+
+// run pushes a new frame into the driver every frame interval
+// until stop is closed
+func (p *testPattern) run(stop <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	next := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+
+		p.drv.mu.Lock()
+		pix := p.drv.pix
+		tf := p.drv.timePerFrame
+		streaming := p.drv.streaming || p.drv.reading
+		brightness := p.control(V4L2_CID_BRIGHTNESS)
+		contrast := p.control(V4L2_CID_CONTRAST)
+		saturation := p.control(V4L2_CID_SATURATION)
+		p.drv.mu.Unlock()
+
+		if streaming {
+			p.render(int(pix.Width), int(pix.Height), time.Now())
+			p.adjust(brightness, contrast, saturation)
+			// A full queue means the frame is dropped, like real hardware does
+			p.drv.PushFrame(p.encode(PixelFormat(pix.Pixelformat), int(pix.Width), int(pix.Height)))
+			p.count++
+		}
+
+		interval := time.Second * time.Duration(tf.Numerator) / time.Duration(tf.Denominator)
+		next = next.Add(interval)
+		wait := time.Until(next)
+		if wait < -interval {
+			// Fell too far behind, don't try to catch up
+			next = time.Now()
+			wait = 0
+		}
+		timer.Reset(wait)
+	}
+}
+
+// control must be called with the driver lock held
+func (p *testPattern) control(id uint32) int32 {
+	if c := p.drv.findControl(id); c != nil {
+		return int32(c.value)
+	}
+	return 0
+}
+
+func (p *testPattern) render(width, height int, now time.Time) {
+	n := width * height
+	if len(p.y) != n {
+		p.y = make([]uint8, n)
+		p.cb = make([]uint8, n)
+		p.cr = make([]uint8, n)
+	}
+
+	// Color bars
+	bars := make([][3]uint8, len(testPatternBars))
+	for i, c := range testPatternBars {
+		bars[i][0], bars[i][1], bars[i][2] = color.RGBToYCbCr(c[0], c[1], c[2])
+	}
+	for x := 0; x < width; x++ {
+		c := bars[x*len(bars)/width]
+		for i := x; i < n; i += width {
+			p.y[i], p.cb[i], p.cr[i] = c[0], c[1], c[2]
+		}
+	}
+
+	// Box bouncing from left to right across the lower half
+	size := height / 6
+	if size < 1 {
+		size = 1
+	}
+	if span := width - size; span > 0 {
+		pos := int(p.count*4) % (2 * span)
+		if pos > span {
+			pos = 2*span - pos
+		}
+		p.fill(pos, height/2, size, size, width, height, 128)
+	}
+
+	// Frame counter and timestamp in the top left corner
+	scale := height / 120
+	if scale < 1 {
+		scale = 1
+	}
+	lines := []string{
+		padNumber(p.count, 8),
+		now.Format("15:04:05.000"),
+	}
+	p.fill(0, 0, (12*4+2)*scale, (len(lines)*6+2)*scale, width, height, 16)
+	for l, s := range lines {
+		for c, r := range s {
+			p.glyph(r, (c*4+2)*scale, (l*6+2)*scale, scale, width, height)
+		}
+	}
+}
+
+// fill paints a grey rectangle clipped to the frame
+func (p *testPattern) fill(x0, y0, w, h, width, height int, luma uint8) {
+	for y := y0; y < y0+h && y < height; y++ {
+		for x := x0; x < x0+w && x < width; x++ {
+			i := y*width + x
+			p.y[i], p.cb[i], p.cr[i] = luma, 128, 128
+		}
+	}
+}
+
+func (p *testPattern) glyph(r rune, x0, y0, scale, width, height int) {
+	g, ok := testPatternGlyphs[r]
+	if !ok {
+		return
+	}
+	for row, bits := range g {
+		for col := 0; col < 3; col++ {
+			if bits&(4>>col) != 0 {
+				p.fill(x0+col*scale, y0+row*scale, scale, scale, width, height, 235)
+			}
+		}
+	}
+}
+
+// adjust applies brightness, contrast (percent) and saturation (percent)
+func (p *testPattern) adjust(brightness, contrast, saturation int32) {
+	if brightness == 0 && contrast == 100 && saturation == 100 {
+		return
+	}
+	for i := range p.y {
+		p.y[i] = clampUint8((int32(p.y[i])-128)*contrast/100 + 128 + brightness)
+		p.cb[i] = clampUint8((int32(p.cb[i])-128)*saturation/100 + 128)
+		p.cr[i] = clampUint8((int32(p.cr[i])-128)*saturation/100 + 128)
+	}
+}
+
+// encode converts the rendered planes into the requested pixel format
+func (p *testPattern) encode(code PixelFormat, width, height int) []byte {
+	n := width * height
+	switch code {
+	case V4L2_PIX_FMT_GREY:
+		return append([]byte(nil), p.y...)
+
+	case V4L2_PIX_FMT_RGB24:
+		out := make([]byte, 0, n*3)
+		for i := 0; i < n; i++ {
+			r, g, b := color.YCbCrToRGB(p.y[i], p.cb[i], p.cr[i])
+			out = append(out, r, g, b)
+		}
+		return out
+
+	case V4L2_PIX_FMT_NV12, V4L2_PIX_FMT_YUV420:
+		out := make([]byte, n, n*3/2)
+		copy(out, p.y)
+		cb, cr := p.subsample(width, height)
+		if code == V4L2_PIX_FMT_YUV420 {
+			return append(append(out, cb...), cr...)
+		}
+		for i := range cb {
+			out = append(out, cb[i], cr[i])
+		}
+		return out
+
+	case V4L2_PIX_FMT_MJPEG:
+		img := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+		copy(img.Y, p.y)
+		cb, cr := p.subsample(width, height)
+		copy(img.Cb, cb)
+		copy(img.Cr, cr)
+		buf := &bytes.Buffer{}
+		jpeg.Encode(buf, img, &jpeg.Options{Quality: 80})
+		return buf.Bytes()
+	}
+
+	// YUYV
+	out := make([]byte, 0, n*2)
+	for i := 0; i+1 < n; i += 2 {
+		cb := uint8((uint16(p.cb[i]) + uint16(p.cb[i+1])) / 2)
+		cr := uint8((uint16(p.cr[i]) + uint16(p.cr[i+1])) / 2)
+		out = append(out, p.y[i], cb, p.y[i+1], cr)
+	}
+	return out
+}
+
+// subsample returns chroma planes reduced by two in both directions
+func (p *testPattern) subsample(width, height int) (cb, cr []uint8) {
+	cw, ch := (width+1)/2, (height+1)/2
+	cb = make([]uint8, 0, cw*ch)
+	cr = make([]uint8, 0, cw*ch)
+	for y := 0; y < height; y += 2 {
+		for x := 0; x < width; x += 2 {
+			i := y*width + x
+			cb = append(cb, p.cb[i])
+			cr = append(cr, p.cr[i])
+		}
+	}
+	return
+}
+
+func clampUint8(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+func padNumber(v uint64, digits int) string {
+	b := make([]byte, digits)
+	for i := digits - 1; i >= 0; i-- {
+		b[i] = byte('0' + v%10)
+		v /= 10
+	}
+	return string(b)
+}
@@ -0,0 +1,85 @@
+package webcam
+
+import (
+	"bytes"
+	"image/jpeg"
+	"testing"
+)
+
+// readTestPattern reads a frame of the test pattern
+func readTestPattern(t *testing.T, w *Webcam) []byte {
+	t.Helper()
+
+	if err := w.WaitForFrame(1); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := w.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestTestPattern(t *testing.T) {
+	w, err := OpenTestPattern(TestPatternConfig{Framerate: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if formats := w.GetSupportedFormats(); len(formats) != len(testPatternFormats) {
+		t.Fatalf("formats = %v", formats)
+	}
+	if fps, err := w.GetFramerate(); err != nil || fps != 60 {
+		t.Fatalf("GetFramerate = %v, %v", fps, err)
+	}
+
+	if _, _, _, err := w.SetImageFormat(V4L2_PIX_FMT_GREY, 320, 240); err != nil {
+		t.Fatal(err)
+	}
+	startTestStreaming(t, w, 2)
+	frame := readTestPattern(t, w)
+	if len(frame) != 320*240 {
+		t.Fatalf("GREY frame of %d bytes", len(frame))
+	}
+
+	// The white bar is on the left and the black one on the right,
+	// above the box and below the text
+	row := frame[60*320 : 61*320]
+	white, black := row[10], row[310]
+	if white < 200 || black > 50 {
+		t.Errorf("luma of white bar %d, of black bar %d", white, black)
+	}
+
+	if err := w.SetControl(ControlID(V4L2_CID_BRIGHTNESS), -100); err != nil {
+		t.Fatal(err)
+	}
+	// Both buffers may hold frames captured before
+	readTestPattern(t, w)
+	readTestPattern(t, w)
+	frame = readTestPattern(t, w)
+	if darker := frame[60*320+10]; darker >= white {
+		t.Errorf("luma %d with lower brightness, was %d", darker, white)
+	}
+}
+
+func TestTestPatternMJPEG(t *testing.T) {
+	w, err := OpenTestPattern(TestPatternConfig{Framerate: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, _, _, err := w.SetImageFormat(V4L2_PIX_FMT_MJPEG, 640, 480); err != nil {
+		t.Fatal(err)
+	}
+	startTestStreaming(t, w, 2)
+
+	img, err := jpeg.Decode(bytes.NewReader(readTestPattern(t, w)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != 640 || size.Y != 480 {
+		t.Errorf("image size = %v", size)
+	}
+}
@@ -3,7 +3,10 @@ package webcam
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"runtime"
+	"strconv"
 	"unsafe"
 
 	"github.com/blackjack/webcam/ioctl"
@@ -22,6 +25,11 @@ const (
 	c_int controlType = iota
 	c_bool
 	c_menu
+	c_button
+	c_int64
+	c_string
+	c_bitmask
+	c_int_menu
 )
 
 type control struct {
@@ -31,14 +39,82 @@ type control struct {
 	step   int32
 	min    int32
 	max    int32
+	def    int32
+	flags  uint32
 }
 
+// Capabilities reported by VIDIOC_QUERYCAP
 const (
-	V4L2_CAP_VIDEO_CAPTURE      uint32 = 0x00000001
-	V4L2_CAP_STREAMING          uint32 = 0x04000000
-	V4L2_BUF_TYPE_VIDEO_CAPTURE uint32 = 1
-	V4L2_MEMORY_MMAP            uint32 = 1
-	V4L2_FIELD_ANY              uint32 = 0
+	V4L2_CAP_VIDEO_CAPTURE        uint32 = 0x00000001
+	V4L2_CAP_VIDEO_OUTPUT         uint32 = 0x00000002
+	V4L2_CAP_VIDEO_OVERLAY        uint32 = 0x00000004
+	V4L2_CAP_VBI_CAPTURE          uint32 = 0x00000010
+	V4L2_CAP_VBI_OUTPUT           uint32 = 0x00000020
+	V4L2_CAP_SLICED_VBI_CAPTURE   uint32 = 0x00000040
+	V4L2_CAP_SLICED_VBI_OUTPUT    uint32 = 0x00000080
+	V4L2_CAP_RDS_CAPTURE          uint32 = 0x00000100
+	V4L2_CAP_VIDEO_OUTPUT_OVERLAY uint32 = 0x00000200
+	V4L2_CAP_HW_FREQ_SEEK         uint32 = 0x00000400
+	V4L2_CAP_RDS_OUTPUT           uint32 = 0x00000800
+	V4L2_CAP_VIDEO_CAPTURE_MPLANE uint32 = 0x00001000
+	V4L2_CAP_VIDEO_OUTPUT_MPLANE  uint32 = 0x00002000
+	V4L2_CAP_VIDEO_M2M_MPLANE     uint32 = 0x00004000
+	V4L2_CAP_VIDEO_M2M            uint32 = 0x00008000
+	V4L2_CAP_TUNER                uint32 = 0x00010000
+	V4L2_CAP_AUDIO                uint32 = 0x00020000
+	V4L2_CAP_RADIO                uint32 = 0x00040000
+	V4L2_CAP_MODULATOR            uint32 = 0x00080000
+	V4L2_CAP_SDR_CAPTURE          uint32 = 0x00100000
+	V4L2_CAP_EXT_PIX_FORMAT       uint32 = 0x00200000
+	V4L2_CAP_SDR_OUTPUT           uint32 = 0x00400000
+	V4L2_CAP_META_CAPTURE         uint32 = 0x00800000
+	V4L2_CAP_READWRITE            uint32 = 0x01000000
+	V4L2_CAP_STREAMING            uint32 = 0x04000000
+	V4L2_CAP_META_OUTPUT          uint32 = 0x08000000
+	V4L2_CAP_TOUCH                uint32 = 0x10000000
+	V4L2_CAP_IO_MC                uint32 = 0x20000000
+	V4L2_CAP_DEVICE_CAPS          uint32 = 0x80000000
+)
+
+const (
+	V4L2_BUF_TYPE_VIDEO_CAPTURE        uint32 = 1
+	V4L2_BUF_TYPE_VIDEO_CAPTURE_MPLANE uint32 = 9
+	VIDEO_MAX_PLANES                   uint32 = 8
+	V4L2_MEMORY_MMAP                   uint32 = 1
+	V4L2_MEMORY_USERPTR                uint32 = 2
+	V4L2_MEMORY_DMABUF                 uint32 = 4
+	V4L2_FIELD_ANY                     uint32 = 0
+)
+
+const (
+	V4L2_FIELD_NONE          uint32 = 1
+	V4L2_FIELD_TOP           uint32 = 2
+	V4L2_FIELD_BOTTOM        uint32 = 3
+	V4L2_FIELD_INTERLACED    uint32 = 4
+	V4L2_FIELD_SEQ_TB        uint32 = 5
+	V4L2_FIELD_SEQ_BT        uint32 = 6
+	V4L2_FIELD_ALTERNATE     uint32 = 7
+	V4L2_FIELD_INTERLACED_TB uint32 = 8
+	V4L2_FIELD_INTERLACED_BT uint32 = 9
+)
+
+const (
+	V4L2_BUF_FLAG_MAPPED              uint32 = 0x00000001
+	V4L2_BUF_FLAG_QUEUED              uint32 = 0x00000002
+	V4L2_BUF_FLAG_DONE                uint32 = 0x00000004
+	V4L2_BUF_FLAG_KEYFRAME            uint32 = 0x00000008
+	V4L2_BUF_FLAG_PFRAME              uint32 = 0x00000010
+	V4L2_BUF_FLAG_BFRAME              uint32 = 0x00000020
+	V4L2_BUF_FLAG_ERROR               uint32 = 0x00000040
+	V4L2_BUF_FLAG_TIMECODE            uint32 = 0x00000100
+	V4L2_BUF_FLAG_TIMESTAMP_MASK      uint32 = 0x0000e000
+	V4L2_BUF_FLAG_TIMESTAMP_UNKNOWN   uint32 = 0x00000000
+	V4L2_BUF_FLAG_TIMESTAMP_MONOTONIC uint32 = 0x00002000
+	V4L2_BUF_FLAG_TIMESTAMP_COPY      uint32 = 0x00004000
+	V4L2_BUF_FLAG_TSTAMP_SRC_MASK     uint32 = 0x00070000
+	V4L2_BUF_FLAG_TSTAMP_SRC_EOF      uint32 = 0x00000000
+	V4L2_BUF_FLAG_TSTAMP_SRC_SOE      uint32 = 0x00010000
+	V4L2_BUF_FLAG_LAST                uint32 = 0x00100000
 )
 
 const (
@@ -53,10 +129,99 @@ const (
 	V4L2_FRMIVAL_TYPE_STEPWISE   uint32 = 3
 )
 
+// Controls of the user class
+const (
+	V4L2_CID_BASE                      uint32 = 0x00980900
+	V4L2_CID_BRIGHTNESS                uint32 = V4L2_CID_BASE + 0
+	V4L2_CID_CONTRAST                  uint32 = V4L2_CID_BASE + 1
+	V4L2_CID_SATURATION                uint32 = V4L2_CID_BASE + 2
+	V4L2_CID_HUE                       uint32 = V4L2_CID_BASE + 3
+	V4L2_CID_AUTO_WHITE_BALANCE        uint32 = V4L2_CID_BASE + 12
+	V4L2_CID_DO_WHITE_BALANCE          uint32 = V4L2_CID_BASE + 13
+	V4L2_CID_RED_BALANCE               uint32 = V4L2_CID_BASE + 14
+	V4L2_CID_BLUE_BALANCE              uint32 = V4L2_CID_BASE + 15
+	V4L2_CID_GAMMA                     uint32 = V4L2_CID_BASE + 16
+	V4L2_CID_EXPOSURE                  uint32 = V4L2_CID_BASE + 17
+	V4L2_CID_AUTOGAIN                  uint32 = V4L2_CID_BASE + 18
+	V4L2_CID_GAIN                      uint32 = V4L2_CID_BASE + 19
+	V4L2_CID_HFLIP                     uint32 = V4L2_CID_BASE + 20
+	V4L2_CID_VFLIP                     uint32 = V4L2_CID_BASE + 21
+	V4L2_CID_POWER_LINE_FREQUENCY      uint32 = V4L2_CID_BASE + 24
+	V4L2_CID_HUE_AUTO                  uint32 = V4L2_CID_BASE + 25
+	V4L2_CID_WHITE_BALANCE_TEMPERATURE uint32 = V4L2_CID_BASE + 26
+	V4L2_CID_SHARPNESS                 uint32 = V4L2_CID_BASE + 27
+	V4L2_CID_BACKLIGHT_COMPENSATION    uint32 = V4L2_CID_BASE + 28
+	V4L2_CID_CHROMA_AGC                uint32 = V4L2_CID_BASE + 29
+	V4L2_CID_COLOR_KILLER              uint32 = V4L2_CID_BASE + 30
+	V4L2_CID_COLORFX                   uint32 = V4L2_CID_BASE + 31
+	V4L2_CID_AUTOBRIGHTNESS            uint32 = V4L2_CID_BASE + 32
+	V4L2_CID_BAND_STOP_FILTER          uint32 = V4L2_CID_BASE + 33
+	V4L2_CID_ROTATE                    uint32 = V4L2_CID_BASE + 34
+	V4L2_CID_BG_COLOR                  uint32 = V4L2_CID_BASE + 35
+	V4L2_CID_CHROMA_GAIN               uint32 = V4L2_CID_BASE + 36
+	V4L2_CID_ILLUMINATORS_1            uint32 = V4L2_CID_BASE + 37
+	V4L2_CID_ILLUMINATORS_2            uint32 = V4L2_CID_BASE + 38
+	V4L2_CID_MIN_BUFFERS_FOR_CAPTURE   uint32 = V4L2_CID_BASE + 39
+	V4L2_CID_MIN_BUFFERS_FOR_OUTPUT    uint32 = V4L2_CID_BASE + 40
+	V4L2_CID_ALPHA_COMPONENT           uint32 = V4L2_CID_BASE + 41
+	V4L2_CID_COLORFX_CBCR              uint32 = V4L2_CID_BASE + 42
+	V4L2_CID_PRIVATE_BASE              uint32 = 0x08000000
+)
+
+// Controls of the camera class
+const (
+	V4L2_CID_CAMERA_CLASS_BASE           uint32 = V4L2_CTRL_CLASS_CAMERA | 0x900
+	V4L2_CID_EXPOSURE_AUTO               uint32 = V4L2_CID_CAMERA_CLASS_BASE + 1
+	V4L2_CID_EXPOSURE_ABSOLUTE           uint32 = V4L2_CID_CAMERA_CLASS_BASE + 2
+	V4L2_CID_EXPOSURE_AUTO_PRIORITY      uint32 = V4L2_CID_CAMERA_CLASS_BASE + 3
+	V4L2_CID_PAN_RELATIVE                uint32 = V4L2_CID_CAMERA_CLASS_BASE + 4
+	V4L2_CID_TILT_RELATIVE               uint32 = V4L2_CID_CAMERA_CLASS_BASE + 5
+	V4L2_CID_PAN_RESET                   uint32 = V4L2_CID_CAMERA_CLASS_BASE + 6
+	V4L2_CID_TILT_RESET                  uint32 = V4L2_CID_CAMERA_CLASS_BASE + 7
+	V4L2_CID_PAN_ABSOLUTE                uint32 = V4L2_CID_CAMERA_CLASS_BASE + 8
+	V4L2_CID_TILT_ABSOLUTE               uint32 = V4L2_CID_CAMERA_CLASS_BASE + 9
+	V4L2_CID_FOCUS_ABSOLUTE              uint32 = V4L2_CID_CAMERA_CLASS_BASE + 10
+	V4L2_CID_FOCUS_RELATIVE              uint32 = V4L2_CID_CAMERA_CLASS_BASE + 11
+	V4L2_CID_FOCUS_AUTO                  uint32 = V4L2_CID_CAMERA_CLASS_BASE + 12
+	V4L2_CID_ZOOM_ABSOLUTE               uint32 = V4L2_CID_CAMERA_CLASS_BASE + 13
+	V4L2_CID_ZOOM_RELATIVE               uint32 = V4L2_CID_CAMERA_CLASS_BASE + 14
+	V4L2_CID_ZOOM_CONTINUOUS             uint32 = V4L2_CID_CAMERA_CLASS_BASE + 15
+	V4L2_CID_PRIVACY                     uint32 = V4L2_CID_CAMERA_CLASS_BASE + 16
+	V4L2_CID_IRIS_ABSOLUTE               uint32 = V4L2_CID_CAMERA_CLASS_BASE + 17
+	V4L2_CID_IRIS_RELATIVE               uint32 = V4L2_CID_CAMERA_CLASS_BASE + 18
+	V4L2_CID_AUTO_EXPOSURE_BIAS          uint32 = V4L2_CID_CAMERA_CLASS_BASE + 19
+	V4L2_CID_AUTO_N_PRESET_WHITE_BALANCE uint32 = V4L2_CID_CAMERA_CLASS_BASE + 20
+	V4L2_CID_WIDE_DYNAMIC_RANGE          uint32 = V4L2_CID_CAMERA_CLASS_BASE + 21
+	V4L2_CID_IMAGE_STABILIZATION         uint32 = V4L2_CID_CAMERA_CLASS_BASE + 22
+	V4L2_CID_ISO_SENSITIVITY             uint32 = V4L2_CID_CAMERA_CLASS_BASE + 23
+	V4L2_CID_ISO_SENSITIVITY_AUTO        uint32 = V4L2_CID_CAMERA_CLASS_BASE + 24
+	V4L2_CID_EXPOSURE_METERING           uint32 = V4L2_CID_CAMERA_CLASS_BASE + 25
+	V4L2_CID_SCENE_MODE                  uint32 = V4L2_CID_CAMERA_CLASS_BASE + 26
+	V4L2_CID_3A_LOCK                     uint32 = V4L2_CID_CAMERA_CLASS_BASE + 27
+	V4L2_CID_AUTO_FOCUS_START            uint32 = V4L2_CID_CAMERA_CLASS_BASE + 28
+	V4L2_CID_AUTO_FOCUS_STOP             uint32 = V4L2_CID_CAMERA_CLASS_BASE + 29
+	V4L2_CID_AUTO_FOCUS_STATUS           uint32 = V4L2_CID_CAMERA_CLASS_BASE + 30
+	V4L2_CID_AUTO_FOCUS_RANGE            uint32 = V4L2_CID_CAMERA_CLASS_BASE + 31
+	V4L2_CID_PAN_SPEED                   uint32 = V4L2_CID_CAMERA_CLASS_BASE + 32
+	V4L2_CID_TILT_SPEED                  uint32 = V4L2_CID_CAMERA_CLASS_BASE + 33
+	V4L2_CID_CAMERA_ORIENTATION          uint32 = V4L2_CID_CAMERA_CLASS_BASE + 34
+	V4L2_CID_CAMERA_SENSOR_ROTATION      uint32 = V4L2_CID_CAMERA_CLASS_BASE + 35
+)
+
+// Items of V4L2_CID_POWER_LINE_FREQUENCY
 const (
-	V4L2_CID_BASE               uint32 = 0x00980900
-	V4L2_CID_AUTO_WHITE_BALANCE uint32 = V4L2_CID_BASE + 12
-	V4L2_CID_PRIVATE_BASE       uint32 = 0x08000000
+	V4L2_CID_POWER_LINE_FREQUENCY_DISABLED uint32 = 0
+	V4L2_CID_POWER_LINE_FREQUENCY_50HZ     uint32 = 1
+	V4L2_CID_POWER_LINE_FREQUENCY_60HZ     uint32 = 2
+	V4L2_CID_POWER_LINE_FREQUENCY_AUTO     uint32 = 3
+)
+
+// Items of V4L2_CID_EXPOSURE_AUTO
+const (
+	V4L2_EXPOSURE_AUTO              uint32 = 0
+	V4L2_EXPOSURE_MANUAL            uint32 = 1
+	V4L2_EXPOSURE_SHUTTER_PRIORITY  uint32 = 2
+	V4L2_EXPOSURE_APERTURE_PRIORITY uint32 = 3
 )
 
 const (
@@ -77,23 +242,82 @@ const (
 )
 
 const (
-	V4L2_CTRL_FLAG_DISABLED  uint32 = 0x00000001
-	V4L2_CTRL_FLAG_NEXT_CTRL uint32 = 0x80000000
+	V4L2_EVENT_ALL  uint32 = 0
+	V4L2_EVENT_CTRL uint32 = 3
+)
+
+// Flags of v4l2_event_subscription
+const (
+	V4L2_EVENT_SUB_FL_SEND_INITIAL   uint32 = 0x0001
+	V4L2_EVENT_SUB_FL_ALLOW_FEEDBACK uint32 = 0x0002
+)
+
+// Changes reported by V4L2_EVENT_CTRL
+const (
+	V4L2_EVENT_CTRL_CH_VALUE      uint32 = 0x0001
+	V4L2_EVENT_CTRL_CH_FLAGS      uint32 = 0x0002
+	V4L2_EVENT_CTRL_CH_RANGE      uint32 = 0x0004
+	V4L2_EVENT_CTRL_CH_DIMENSIONS uint32 = 0x0008
+)
+
+// Values of v4l2_ext_controls.which
+const (
+	V4L2_CTRL_WHICH_CUR_VAL uint32 = 0
+	V4L2_CTRL_WHICH_DEF_VAL uint32 = 0x0f000000
+)
+
+const (
+	V4L2_CTRL_FLAG_DISABLED         uint32 = 0x00000001
+	V4L2_CTRL_FLAG_GRABBED          uint32 = 0x00000002
+	V4L2_CTRL_FLAG_READ_ONLY        uint32 = 0x00000004
+	V4L2_CTRL_FLAG_UPDATE           uint32 = 0x00000008
+	V4L2_CTRL_FLAG_INACTIVE         uint32 = 0x00000010
+	V4L2_CTRL_FLAG_SLIDER           uint32 = 0x00000020
+	V4L2_CTRL_FLAG_WRITE_ONLY       uint32 = 0x00000040
+	V4L2_CTRL_FLAG_VOLATILE         uint32 = 0x00000080
+	V4L2_CTRL_FLAG_HAS_PAYLOAD      uint32 = 0x00000100
+	V4L2_CTRL_FLAG_EXECUTE_ON_WRITE uint32 = 0x00000200
+	V4L2_CTRL_FLAG_MODIFY_LAYOUT    uint32 = 0x00000400
+	V4L2_CTRL_FLAG_DYNAMIC_ARRAY    uint32 = 0x00000800
+
+	V4L2_CTRL_FLAG_NEXT_CTRL     uint32 = 0x80000000
+	V4L2_CTRL_FLAG_NEXT_COMPOUND uint32 = 0x40000000
+)
+
+// Control classes, the upper 16 bits of control IDs
+const (
+	V4L2_CTRL_CLASS_USER            uint32 = 0x00980000
+	V4L2_CTRL_CLASS_CODEC           uint32 = 0x00990000
+	V4L2_CTRL_CLASS_CAMERA          uint32 = 0x009a0000
+	V4L2_CTRL_CLASS_FM_TX           uint32 = 0x009b0000
+	V4L2_CTRL_CLASS_FLASH           uint32 = 0x009c0000
+	V4L2_CTRL_CLASS_JPEG            uint32 = 0x009d0000
+	V4L2_CTRL_CLASS_IMAGE_SOURCE    uint32 = 0x009e0000
+	V4L2_CTRL_CLASS_IMAGE_PROC      uint32 = 0x009f0000
+	V4L2_CTRL_CLASS_DV              uint32 = 0x00a00000
+	V4L2_CTRL_CLASS_FM_RX           uint32 = 0x00a10000
+	V4L2_CTRL_CLASS_RF_TUNER        uint32 = 0x00a20000
+	V4L2_CTRL_CLASS_DETECT          uint32 = 0x00a30000
+	V4L2_CTRL_CLASS_CODEC_STATELESS uint32 = 0x00a40000
+	V4L2_CTRL_CLASS_COLORIMETRY     uint32 = 0x00a50000
 )
 
 var (
 	VIDIOC_QUERYCAP  = ioctl.IoR(uintptr('V'), 0, unsafe.Sizeof(v4l2_capability{}))
 	VIDIOC_ENUM_FMT  = ioctl.IoRW(uintptr('V'), 2, unsafe.Sizeof(v4l2_fmtdesc{}))
+	VIDIOC_G_FMT     = ioctl.IoRW(uintptr('V'), 4, unsafe.Sizeof(v4l2_format{}))
 	VIDIOC_S_FMT     = ioctl.IoRW(uintptr('V'), 5, unsafe.Sizeof(v4l2_format{}))
 	VIDIOC_REQBUFS   = ioctl.IoRW(uintptr('V'), 8, unsafe.Sizeof(v4l2_requestbuffers{}))
 	VIDIOC_QUERYBUF  = ioctl.IoRW(uintptr('V'), 9, unsafe.Sizeof(v4l2_buffer{}))
 	VIDIOC_QBUF      = ioctl.IoRW(uintptr('V'), 15, unsafe.Sizeof(v4l2_buffer{}))
+	VIDIOC_EXPBUF    = ioctl.IoRW(uintptr('V'), 16, unsafe.Sizeof(v4l2_exportbuffer{}))
 	VIDIOC_DQBUF     = ioctl.IoRW(uintptr('V'), 17, unsafe.Sizeof(v4l2_buffer{}))
 	VIDIOC_G_PARM    = ioctl.IoRW(uintptr('V'), 21, unsafe.Sizeof(v4l2_streamparm{}))
 	VIDIOC_S_PARM    = ioctl.IoRW(uintptr('V'), 22, unsafe.Sizeof(v4l2_streamparm{}))
 	VIDIOC_G_CTRL    = ioctl.IoRW(uintptr('V'), 27, unsafe.Sizeof(v4l2_control{}))
 	VIDIOC_S_CTRL    = ioctl.IoRW(uintptr('V'), 28, unsafe.Sizeof(v4l2_control{}))
 	VIDIOC_QUERYCTRL = ioctl.IoRW(uintptr('V'), 36, unsafe.Sizeof(v4l2_queryctrl{}))
+	VIDIOC_QUERYMENU = ioctl.IoRW(uintptr('V'), 37, unsafe.Sizeof(v4l2_querymenu{}))
 	//sizeof int32
 	VIDIOC_STREAMON            = ioctl.IoW(uintptr('V'), 18, 4)
 	VIDIOC_STREAMOFF           = ioctl.IoW(uintptr('V'), 19, 4)
@@ -101,7 +325,14 @@ var (
 	VIDIOC_S_INPUT             = ioctl.IoRW(uintptr('V'), 39, 4)
 	VIDIOC_ENUM_FRAMESIZES     = ioctl.IoRW(uintptr('V'), 74, unsafe.Sizeof(v4l2_frmsizeenum{}))
 	VIDIOC_ENUM_FRAMEINTERVALS = ioctl.IoRW(uintptr('V'), 75, unsafe.Sizeof(v4l2_frmivalenum{}))
-	__p                        = unsafe.Pointer(uintptr(0))
+	VIDIOC_G_EXT_CTRLS         = ioctl.IoRW(uintptr('V'), 71, unsafe.Sizeof(v4l2_ext_controls{}))
+	VIDIOC_S_EXT_CTRLS         = ioctl.IoRW(uintptr('V'), 72, unsafe.Sizeof(v4l2_ext_controls{}))
+	VIDIOC_TRY_EXT_CTRLS       = ioctl.IoRW(uintptr('V'), 73, unsafe.Sizeof(v4l2_ext_controls{}))
+	VIDIOC_QUERY_EXT_CTRL      = ioctl.IoRW(uintptr('V'), 103, unsafe.Sizeof(v4l2_query_ext_ctrl{}))
+	VIDIOC_DQEVENT             = ioctl.IoR(uintptr('V'), 89, unsafe.Sizeof(v4l2_event{}))
+	VIDIOC_SUBSCRIBE_EVENT     = ioctl.IoW(uintptr('V'), 90, unsafe.Sizeof(v4l2_event_subscription{}))
+	VIDIOC_UNSUBSCRIBE_EVENT   = ioctl.IoW(uintptr('V'), 91, unsafe.Sizeof(v4l2_event_subscription{}))
+	__p                        unsafe.Pointer
 	NativeByteOrder            = getNativeByteOrder()
 )
 
@@ -192,6 +423,27 @@ type v4l2_pix_format struct {
 	Xfer_func    uint32
 }
 
+type v4l2_plane_pix_format struct {
+	Sizeimage    uint32
+	Bytesperline uint32
+	reserved     [6]uint16
+}
+
+type v4l2_pix_format_mplane struct {
+	Width        uint32
+	Height       uint32
+	Pixelformat  uint32
+	Field        uint32
+	Colorspace   uint32
+	Plane_fmt    [VIDEO_MAX_PLANES]v4l2_plane_pix_format
+	Num_planes   uint8
+	Flags        uint8
+	Ycbcr_enc    uint8
+	Quantization uint8
+	Xfer_func    uint8
+	reserved     [7]uint8
+}
+
 type v4l2_requestbuffers struct {
 	count    uint32
 	_type    uint32
@@ -215,6 +467,23 @@ type v4l2_buffer struct {
 	reserved  uint32
 }
 
+type v4l2_plane struct {
+	bytesused   uint32
+	length      uint32
+	m           [unsafe.Sizeof(__p)]uint8
+	data_offset uint32
+	reserved    [11]uint32
+}
+
+type v4l2_exportbuffer struct {
+	_type    uint32
+	index    uint32
+	plane    uint32
+	flags    uint32
+	fd       int32
+	reserved [11]uint32
+}
+
 type v4l2_timecode struct {
 	_type    uint32
 	flags    uint32
@@ -237,11 +506,97 @@ type v4l2_queryctrl struct {
 	reserved      [2]uint32
 }
 
+// The name of the item is a union with int64 value of integer menu items
+type v4l2_querymenu struct {
+	id       uint32
+	index    uint32
+	name     [32]uint8
+	reserved uint32
+}
+
 type v4l2_control struct {
 	id    uint32
 	value int32
 }
 
+type v4l2_event_subscription struct {
+	_type    uint32
+	id       uint32
+	flags    uint32
+	reserved [5]uint32
+}
+
+type v4l2_event struct {
+	_type     uint32
+	_         uint32 // the union is 64-bit aligned
+	union     [64]uint8
+	pending   uint32
+	sequence  uint32
+	timestamp unix.Timespec
+	id        uint32
+	reserved  [8]uint32
+}
+
+type v4l2_event_ctrl struct {
+	changes       uint32
+	_type         uint32
+	union         [8]uint8 // value, or value64 of 64-bit controls
+	flags         uint32
+	minimum       int32
+	maximum       int32
+	step          int32
+	default_value int32
+}
+
+type v4l2_query_ext_ctrl struct {
+	id            uint32
+	_type         uint32
+	name          [32]uint8
+	minimum       int64
+	maximum       int64
+	step          uint64
+	default_value int64
+	flags         uint32
+	elem_size     uint32
+	elems         uint32
+	nr_of_dims    uint32
+	dims          [4]uint32
+	reserved      [32]uint32
+}
+
+// The struct is packed, so the union of the value and the pointer
+// to the payload is a byte array which isn't aligned
+type v4l2_ext_control struct {
+	id        uint32
+	size      uint32
+	reserved2 uint32
+	union     [8]uint8
+}
+
+// setPointer points the control to a string or payload buffer. The pointer
+// is copied bytewise, as the union isn't aligned for it.
+func (c *v4l2_ext_control) setPointer(buf []byte) {
+	p := unsafe.Pointer(&buf[0])
+	c.size = uint32(len(buf))
+	copy(c.union[:], (*[unsafe.Sizeof(p)]byte)(unsafe.Pointer(&p))[:])
+}
+
+// data returns the string or payload buffer the control points to
+func (c *v4l2_ext_control) data() []byte {
+	var p unsafe.Pointer
+	copy((*[unsafe.Sizeof(p)]byte)(unsafe.Pointer(&p))[:], c.union[:])
+	return unsafe.Slice((*byte)(p), c.size)
+}
+
+type v4l2_ext_controls struct {
+	which      uint32
+	count      uint32
+	error_idx  uint32
+	request_fd int32
+	reserved   uint32
+	controls   unsafe.Pointer
+}
+
 type v4l2_fract struct {
 	Numerator   uint32
 	Denominator uint32
@@ -262,30 +617,37 @@ type v4l2_streamparm struct {
 	union v4l2_streamparm_union
 }
 
-func checkCapabilities(fd uintptr) (supportsVideoCapture bool, supportsVideoStreaming bool, err error) {
+func queryCapabilities(dev device) (caps v4l2_capability, err error) {
+	err = dev.ioctl(VIDIOC_QUERYCAP, unsafe.Pointer(&caps))
+	return
+}
 
-	caps := &v4l2_capability{}
+// checkCapabilities returns capabilities of the opened device node
+func checkCapabilities(dev device) (capabilities uint32, err error) {
 
-	err = ioctl.Ioctl(fd, VIDIOC_QUERYCAP, uintptr(unsafe.Pointer(caps)))
+	caps, err := queryCapabilities(dev)
 
 	if err != nil {
 		return
 	}
 
-	supportsVideoCapture = (caps.capabilities & V4L2_CAP_VIDEO_CAPTURE) != 0
-	supportsVideoStreaming = (caps.capabilities & V4L2_CAP_STREAMING) != 0
+	// capabilities field describes the physical device as a whole
+	capabilities = caps.capabilities
+	if capabilities&V4L2_CAP_DEVICE_CAPS != 0 {
+		capabilities = caps.device_caps
+	}
 	return
 
 }
 
-func getPixelFormat(fd uintptr, index uint32) (code uint32, description string, err error) {
+func getPixelFormat(dev device, bufType uint32, index uint32) (code uint32, description string, err error) {
 
 	fmtdesc := &v4l2_fmtdesc{}
 
 	fmtdesc.index = index
-	fmtdesc._type = V4L2_BUF_TYPE_VIDEO_CAPTURE
+	fmtdesc._type = bufType
 
-	err = ioctl.Ioctl(fd, VIDIOC_ENUM_FMT, uintptr(unsafe.Pointer(fmtdesc)))
+	err = dev.ioctl(VIDIOC_ENUM_FMT, unsafe.Pointer(fmtdesc))
 
 	if err != nil {
 		return
@@ -297,13 +659,13 @@ func getPixelFormat(fd uintptr, index uint32) (code uint32, description string,
 	return
 }
 
-func getFrameSize(fd uintptr, index uint32, code uint32) (frameSize FrameSize, err error) {
+func getFrameSize(dev device, index uint32, code uint32) (frameSize FrameSize, err error) {
 
 	frmsizeenum := &v4l2_frmsizeenum{}
 	frmsizeenum.index = index
 	frmsizeenum.pixel_format = code
 
-	err = ioctl.Ioctl(fd, VIDIOC_ENUM_FRAMESIZES, uintptr(unsafe.Pointer(frmsizeenum)))
+	err = dev.ioctl(VIDIOC_ENUM_FRAMESIZES, unsafe.Pointer(frmsizeenum))
 
 	if err != nil {
 		return
@@ -347,16 +709,16 @@ func getFrameSize(fd uintptr, index uint32, code uint32) (frameSize FrameSize, e
 	return
 }
 
-func getName(fd uintptr) (string, error) {
-	var caps v4l2_capability
-	if err := ioctl.Ioctl(fd, VIDIOC_QUERYCAP, uintptr(unsafe.Pointer(&caps))); err != nil {
+func getName(dev device) (string, error) {
+	caps, err := queryCapabilities(dev)
+	if err != nil {
 		return "", err
 	}
 
 	return CToGoString(caps.card[:]), nil
 }
 
-func getFrameInterval(fd uintptr, index uint32, code uint32, width uint32, height uint32) (FrameRate, error) {
+func getFrameInterval(dev device, index uint32, code uint32, width uint32, height uint32) (FrameRate, error) {
 	frmivalEnum := &v4l2_frmivalenum{
 		index:        index,
 		pixel_format: code,
@@ -364,7 +726,7 @@ func getFrameInterval(fd uintptr, index uint32, code uint32, width uint32, heigh
 		height:       height,
 	}
 
-	if err := ioctl.Ioctl(fd, VIDIOC_ENUM_FRAMEINTERVALS, uintptr(unsafe.Pointer(frmivalEnum))); err != nil {
+	if err := dev.ioctl(VIDIOC_ENUM_FRAMEINTERVALS, unsafe.Pointer(frmivalEnum)); err != nil {
 		return FrameRate{}, err
 	}
 
@@ -405,16 +767,16 @@ func getFrameInterval(fd uintptr, index uint32, code uint32, width uint32, heigh
 	return FrameRate{}, fmt.Errorf("unknown frame interval type")
 }
 
-func getBusInfo(fd uintptr) (string, error) {
-	var caps v4l2_capability
-	if err := ioctl.Ioctl(fd, VIDIOC_QUERYCAP, uintptr(unsafe.Pointer(&caps))); err != nil {
+func getBusInfo(dev device) (string, error) {
+	caps, err := queryCapabilities(dev)
+	if err != nil {
 		return "", err
 	}
 
 	return CToGoString(caps.bus_info[:]), nil
 }
 
-func setImageFormat(fd uintptr, formatcode *uint32, width *uint32, height *uint32) (err error) {
+func setImageFormat(dev device, formatcode *uint32, width *uint32, height *uint32) (err error) {
 
 	format := &v4l2_format{
 		_type: V4L2_BUF_TYPE_VIDEO_CAPTURE,
@@ -436,7 +798,7 @@ func setImageFormat(fd uintptr, formatcode *uint32, width *uint32, height *uint3
 
 	copy(format.union.data[:], pixbytes.Bytes())
 
-	err = ioctl.Ioctl(fd, VIDIOC_S_FMT, uintptr(unsafe.Pointer(format)))
+	err = dev.ioctl(VIDIOC_S_FMT, unsafe.Pointer(format))
 
 	if err != nil {
 		return
@@ -457,14 +819,31 @@ func setImageFormat(fd uintptr, formatcode *uint32, width *uint32, height *uint3
 
 }
 
-func mmapRequestBuffers(fd uintptr, buf_count *uint32) (err error) {
+func getImageFormat(dev device) (pix v4l2_pix_format, err error) {
+
+	format := &v4l2_format{
+		_type: V4L2_BUF_TYPE_VIDEO_CAPTURE,
+	}
+
+	err = dev.ioctl(VIDIOC_G_FMT, unsafe.Pointer(format))
+
+	if err != nil {
+		return
+	}
+
+	err = binary.Read(bytes.NewBuffer(format.union.data[:]), NativeByteOrder, &pix)
+	return
+
+}
+
+func requestBuffers(dev device, bufType uint32, memory uint32, buf_count *uint32) (err error) {
 
 	req := &v4l2_requestbuffers{}
 	req.count = *buf_count
-	req._type = V4L2_BUF_TYPE_VIDEO_CAPTURE
-	req.memory = V4L2_MEMORY_MMAP
+	req._type = bufType
+	req.memory = memory
 
-	err = ioctl.Ioctl(fd, VIDIOC_REQBUFS, uintptr(unsafe.Pointer(req)))
+	err = dev.ioctl(VIDIOC_REQBUFS, unsafe.Pointer(req))
 
 	if err != nil {
 		return
@@ -476,7 +855,7 @@ func mmapRequestBuffers(fd uintptr, buf_count *uint32) (err error) {
 
 }
 
-func mmapQueryBuffer(fd uintptr, index uint32, length *uint32) (buffer []byte, err error) {
+func mmapQueryBuffer(dev device, index uint32, length *uint32) (buffer []byte, err error) {
 
 	req := &v4l2_buffer{}
 
@@ -484,7 +863,7 @@ func mmapQueryBuffer(fd uintptr, index uint32, length *uint32) (buffer []byte, e
 	req.memory = V4L2_MEMORY_MMAP
 	req.index = index
 
-	err = ioctl.Ioctl(fd, VIDIOC_QUERYBUF, uintptr(unsafe.Pointer(req)))
+	err = dev.ioctl(VIDIOC_QUERYBUF, unsafe.Pointer(req))
 
 	if err != nil {
 		return
@@ -499,68 +878,226 @@ func mmapQueryBuffer(fd uintptr, index uint32, length *uint32) (buffer []byte, e
 
 	*length = req.length
 
-	buffer, err = unix.Mmap(int(fd), int64(offset), int(req.length), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	buffer, err = dev.mmap(int64(offset), int(req.length))
+	return
+}
+
+func dequeueBuffer(dev device, memory uint32, buffer *v4l2_buffer) (err error) {
+
+	buffer._type = V4L2_BUF_TYPE_VIDEO_CAPTURE
+	buffer.memory = memory
+
+	err = dev.ioctl(VIDIOC_DQBUF, unsafe.Pointer(buffer))
 	return
+
 }
 
-func mmapDequeueBuffer(fd uintptr, index *uint32, length *uint32) (err error) {
+func mmapEnqueueBuffer(dev device, index uint32) (err error) {
 
 	buffer := &v4l2_buffer{}
 
 	buffer._type = V4L2_BUF_TYPE_VIDEO_CAPTURE
 	buffer.memory = V4L2_MEMORY_MMAP
+	buffer.index = index
 
-	err = ioctl.Ioctl(fd, VIDIOC_DQBUF, uintptr(unsafe.Pointer(buffer)))
+	err = dev.ioctl(VIDIOC_QBUF, unsafe.Pointer(buffer))
+	return
+
+}
+
+func userptrEnqueueBuffer(dev device, index uint32, data []byte) (err error) {
+
+	buffer := &v4l2_buffer{}
+
+	buffer._type = V4L2_BUF_TYPE_VIDEO_CAPTURE
+	buffer.memory = V4L2_MEMORY_USERPTR
+	buffer.index = index
+	buffer.length = uint32(len(data))
+	*(*unsafe.Pointer)(unsafe.Pointer(&buffer.union[0])) = unsafe.Pointer(&data[0])
+
+	err = dev.ioctl(VIDIOC_QBUF, unsafe.Pointer(buffer))
+	return
+
+}
+
+func dmabufEnqueueBuffer(dev device, index uint32, fd int, length uint32) (err error) {
+
+	buffer := &v4l2_buffer{}
+
+	buffer._type = V4L2_BUF_TYPE_VIDEO_CAPTURE
+	buffer.memory = V4L2_MEMORY_DMABUF
+	buffer.index = index
+	buffer.length = length
+	*(*int32)(unsafe.Pointer(&buffer.union[0])) = int32(fd)
+
+	err = dev.ioctl(VIDIOC_QBUF, unsafe.Pointer(buffer))
+	return
+
+}
+
+func exportBuffer(dev device, index uint32) (fd int, err error) {
+
+	req := &v4l2_exportbuffer{}
+
+	req._type = V4L2_BUF_TYPE_VIDEO_CAPTURE
+	req.index = index
+	req.flags = unix.O_CLOEXEC | unix.O_RDWR
+
+	err = dev.ioctl(VIDIOC_EXPBUF, unsafe.Pointer(req))
+
+	if err != nil {
+		return -1, err
+	}
+
+	return int(req.fd), nil
+
+}
+
+func mplaneSetImageFormat(dev device, formatcode *uint32, width *uint32, height *uint32) (err error) {
+
+	format := &v4l2_format{
+		_type: V4L2_BUF_TYPE_VIDEO_CAPTURE_MPLANE,
+	}
+
+	pix := (*v4l2_pix_format_mplane)(unsafe.Pointer(&format.union.data[0]))
+	pix.Width = *width
+	pix.Height = *height
+	pix.Pixelformat = *formatcode
+	pix.Field = V4L2_FIELD_ANY
+
+	err = dev.ioctl(VIDIOC_S_FMT, unsafe.Pointer(format))
 
 	if err != nil {
 		return
 	}
 
-	*index = buffer.index
-	*length = buffer.bytesused
+	*width = pix.Width
+	*height = pix.Height
+	*formatcode = pix.Pixelformat
 
 	return
 
 }
 
-func mmapEnqueueBuffer(fd uintptr, index uint32) (err error) {
+func mplaneGetImageFormat(dev device) (pix v4l2_pix_format_mplane, err error) {
+
+	format := &v4l2_format{
+		_type: V4L2_BUF_TYPE_VIDEO_CAPTURE_MPLANE,
+	}
+
+	err = dev.ioctl(VIDIOC_G_FMT, unsafe.Pointer(format))
+
+	if err != nil {
+		return
+	}
+
+	pix = *(*v4l2_pix_format_mplane)(unsafe.Pointer(&format.union.data[0]))
+	return
+
+}
+
+// setBufferPlanes points a multi-planar buffer to its plane array.
+// The array must be kept alive until the request returns.
+func setBufferPlanes(buffer *v4l2_buffer, planes []v4l2_plane) {
+	*(*unsafe.Pointer)(unsafe.Pointer(&buffer.union[0])) = unsafe.Pointer(&planes[0])
+	buffer.length = uint32(len(planes))
+}
+
+func mplaneQueryBuffer(dev device, index uint32, numPlanes uint32) (buffers [][]byte, err error) {
+
+	req := &v4l2_buffer{}
+	planes := make([]v4l2_plane, numPlanes)
+
+	req._type = V4L2_BUF_TYPE_VIDEO_CAPTURE_MPLANE
+	req.memory = V4L2_MEMORY_MMAP
+	req.index = index
+	setBufferPlanes(req, planes)
+
+	err = dev.ioctl(VIDIOC_QUERYBUF, unsafe.Pointer(req))
+	runtime.KeepAlive(planes)
+
+	if err != nil {
+		return
+	}
+
+	for _, plane := range planes[:req.length] {
+		var offset uint32
+		err = binary.Read(bytes.NewBuffer(plane.m[:]), NativeByteOrder, &offset)
+
+		if err != nil {
+			return
+		}
+
+		var buffer []byte
+		buffer, err = dev.mmap(int64(offset), int(plane.length))
+
+		if err != nil {
+			for _, b := range buffers {
+				dev.munmap(b)
+			}
+			return nil, err
+		}
+
+		buffers = append(buffers, buffer)
+	}
+
+	return
+}
+
+func mplaneEnqueueBuffer(dev device, index uint32, numPlanes uint32) (err error) {
 
 	buffer := &v4l2_buffer{}
+	planes := make([]v4l2_plane, numPlanes)
 
-	buffer._type = V4L2_BUF_TYPE_VIDEO_CAPTURE
+	buffer._type = V4L2_BUF_TYPE_VIDEO_CAPTURE_MPLANE
 	buffer.memory = V4L2_MEMORY_MMAP
 	buffer.index = index
+	setBufferPlanes(buffer, planes)
+
+	err = dev.ioctl(VIDIOC_QBUF, unsafe.Pointer(buffer))
+	runtime.KeepAlive(planes)
+	return
 
-	err = ioctl.Ioctl(fd, VIDIOC_QBUF, uintptr(unsafe.Pointer(buffer)))
+}
+
+func mplaneDequeueBuffer(dev device, buffer *v4l2_buffer, planes []v4l2_plane) (err error) {
+
+	buffer._type = V4L2_BUF_TYPE_VIDEO_CAPTURE_MPLANE
+	buffer.memory = V4L2_MEMORY_MMAP
+	setBufferPlanes(buffer, planes)
+
+	err = dev.ioctl(VIDIOC_DQBUF, unsafe.Pointer(buffer))
+	runtime.KeepAlive(planes)
 	return
 
 }
 
-func mmapReleaseBuffer(buffer []byte) (err error) {
-	err = unix.Munmap(buffer)
+func mmapReleaseBuffer(dev device, buffer []byte) (err error) {
+	err = dev.munmap(buffer)
 	return
 }
 
-func startStreaming(fd uintptr) (err error) {
+func startStreaming(dev device, bufType uint32) (err error) {
 
-	var uintPointer uint32 = V4L2_BUF_TYPE_VIDEO_CAPTURE
-	err = ioctl.Ioctl(fd, VIDIOC_STREAMON, uintptr(unsafe.Pointer(&uintPointer)))
+	var uintPointer uint32 = bufType
+	err = dev.ioctl(VIDIOC_STREAMON, unsafe.Pointer(&uintPointer))
 	return
 
 }
 
-func stopStreaming(fd uintptr) (err error) {
+func stopStreaming(dev device, bufType uint32) (err error) {
 
-	var uintPointer uint32 = V4L2_BUF_TYPE_VIDEO_CAPTURE
-	err = ioctl.Ioctl(fd, VIDIOC_STREAMOFF, uintptr(unsafe.Pointer(&uintPointer)))
+	var uintPointer uint32 = bufType
+	err = dev.ioctl(VIDIOC_STREAMOFF, unsafe.Pointer(&uintPointer))
 	return
 
 }
 
-func waitForFrame(pollFds []unix.PollFd, timeout uint32) (count int, err error) {
+// waitForFrame polls the device, timeout is in milliseconds
+func waitForFrame(dev device, pollFds []unix.PollFd, timeout int) (count int, err error) {
 	for {
-		count, err = unix.Poll(pollFds, int(timeout*1000))
-		if count < 0 && err == unix.EINTR {
+		count, err = dev.poll(pollFds, timeout)
+		if count < 0 && errors.Is(err, unix.EINTR) {
 			continue
 		}
 		return
@@ -568,35 +1105,65 @@ func waitForFrame(pollFds []unix.PollFd, timeout uint32) (count int, err error)
 
 }
 
-func getControl(fd uintptr, id uint32) (int32, error) {
+func subscribeEvent(dev device, eventType uint32, id uint32, flags uint32) error {
+	sub := &v4l2_event_subscription{
+		_type: eventType,
+		id:    id,
+		flags: flags,
+	}
+	return dev.ioctl(VIDIOC_SUBSCRIBE_EVENT, unsafe.Pointer(sub))
+}
+
+func unsubscribeEvent(dev device, eventType uint32, id uint32) error {
+	sub := &v4l2_event_subscription{
+		_type: eventType,
+		id:    id,
+	}
+	return dev.ioctl(VIDIOC_UNSUBSCRIBE_EVENT, unsafe.Pointer(sub))
+}
+
+// dequeueEvent returns the next pending event. It fails
+// with ENOENT when there are no more events.
+func dequeueEvent(dev device) (event v4l2_event, err error) {
+	err = dev.ioctl(VIDIOC_DQEVENT, unsafe.Pointer(&event))
+	return
+}
+
+func queryControl(dev device, id uint32) (query v4l2_queryctrl, err error) {
+	query.id = id
+	err = dev.ioctl(VIDIOC_QUERYCTRL, unsafe.Pointer(&query))
+	return
+}
+
+func getControl(dev device, id uint32) (int32, error) {
 	ctrl := &v4l2_control{}
 	ctrl.id = id
-	err := ioctl.Ioctl(fd, VIDIOC_G_CTRL, uintptr(unsafe.Pointer(ctrl)))
+	err := dev.ioctl(VIDIOC_G_CTRL, unsafe.Pointer(ctrl))
 	return ctrl.value, err
 }
 
-func setControl(fd uintptr, id uint32, val int32) error {
+func setControl(dev device, id uint32, val int32) error {
 	ctrl := &v4l2_control{}
 	ctrl.id = id
 	ctrl.value = val
-	return ioctl.Ioctl(fd, VIDIOC_S_CTRL, uintptr(unsafe.Pointer(ctrl)))
+	return dev.ioctl(VIDIOC_S_CTRL, unsafe.Pointer(ctrl))
 }
 
-func getInput(fd uintptr) (index int32, err error) {
-	err = ioctl.Ioctl(fd, VIDIOC_G_INPUT, uintptr(unsafe.Pointer(&index)))
+func getInput(dev device) (index int32, err error) {
+	err = dev.ioctl(VIDIOC_G_INPUT, unsafe.Pointer(&index))
 	return
 }
 
-func selectInput(fd uintptr, index uint32) (err error) {
-	err = ioctl.Ioctl(fd, VIDIOC_S_INPUT, uintptr(unsafe.Pointer(&index)))
+func selectInput(dev device, index uint32) (err error) {
+	err = dev.ioctl(VIDIOC_S_INPUT, unsafe.Pointer(&index))
 	return
 }
 
-func getFramerate(fd uintptr) (float32, error) {
+func getFramerate(dev device, bufType uint32) (float32, error) {
 	param := &v4l2_streamparm{}
-	param._type = V4L2_BUF_TYPE_VIDEO_CAPTURE
+	param._type = bufType
 
-	err := ioctl.Ioctl(fd, VIDIOC_G_PARM, uintptr(unsafe.Pointer(param)))
+	err := dev.ioctl(VIDIOC_G_PARM, unsafe.Pointer(param))
 	if err != nil {
 		return 0, err
 	}
@@ -607,15 +1174,39 @@ func getFramerate(fd uintptr) (float32, error) {
 	return float32(tf.Denominator) / float32(tf.Numerator), nil
 }
 
-func setFramerate(fd uintptr, num, denom uint32) error {
+func setFramerate(dev device, bufType uint32, num, denom uint32) error {
 	param := &v4l2_streamparm{}
-	param._type = V4L2_BUF_TYPE_VIDEO_CAPTURE
+	param._type = bufType
 	param.union.time_per_frame.Numerator = num
 	param.union.time_per_frame.Denominator = denom
-	return ioctl.Ioctl(fd, VIDIOC_S_PARM, uintptr(unsafe.Pointer(param)))
+	return dev.ioctl(VIDIOC_S_PARM, unsafe.Pointer(param))
 }
 
-func queryControls(fd uintptr) []control {
+// controlTypeOf maps V4L2_CTRL_TYPE_* types to the
+// ones of this package, which doesn't support all of them
+func controlTypeOf(t uint32) (controlType, bool) {
+	switch t {
+	case V4L2_CTRL_TYPE_INTEGER:
+		return c_int, true
+	case V4L2_CTRL_TYPE_BOOLEAN:
+		return c_bool, true
+	case V4L2_CTRL_TYPE_MENU:
+		return c_menu, true
+	case V4L2_CTRL_TYPE_BUTTON:
+		return c_button, true
+	case V4L2_CTRL_TYPE_INTEGER64:
+		return c_int64, true
+	case V4L2_CTRL_TYPE_STRING:
+		return c_string, true
+	case V4L2_CTRL_TYPE_BITMASK:
+		return c_bitmask, true
+	case V4L2_CTRL_TYPE_INTEGER_MENU:
+		return c_int_menu, true
+	}
+	return 0, false
+}
+
+func queryControls(dev device) []control {
 	controls := []control{}
 	var err error
 	// Don't use V42L_CID_BASE since it is the same as brightness.
@@ -624,34 +1215,80 @@ func queryControls(fd uintptr) []control {
 		id |= V4L2_CTRL_FLAG_NEXT_CTRL
 		query := &v4l2_queryctrl{}
 		query.id = id
-		err = ioctl.Ioctl(fd, VIDIOC_QUERYCTRL, uintptr(unsafe.Pointer(query)))
+		err = dev.ioctl(VIDIOC_QUERYCTRL, unsafe.Pointer(query))
 		id = query.id
 		if err == nil {
 			if (query.flags & V4L2_CTRL_FLAG_DISABLED) != 0 {
 				continue
 			}
 			var c control
-			switch query._type {
-			default:
+			var ok bool
+			c.c_type, ok = controlTypeOf(query._type)
+			if !ok {
+				// Class controls only name the class
 				continue
-			case V4L2_CTRL_TYPE_INTEGER, V4L2_CTRL_TYPE_INTEGER64:
-				c.c_type = c_int
-			case V4L2_CTRL_TYPE_BOOLEAN:
-				c.c_type = c_bool
-			case V4L2_CTRL_TYPE_MENU:
-				c.c_type = c_menu
 			}
 			c.id = id
 			c.name = CToGoString(query.name[:])
 			c.min = query.minimum
 			c.max = query.maximum
 			c.step = query.step
+			c.def = query.default_value
+			c.flags = query.flags
 			controls = append(controls, c)
 		}
 	}
 	return controls
 }
 
+func queryExtControl(dev device, id uint32) (query v4l2_query_ext_ctrl, err error) {
+	query.id = id
+	err = dev.ioctl(VIDIOC_QUERY_EXT_CTRL, unsafe.Pointer(&query))
+	return
+}
+
+// extControls gets, sets or tries a batch of controls. The index of
+// the control which failed is returned, or count if the error isn't
+// associated with a control.
+func extControls(dev device, op uintptr, controls []v4l2_ext_control) (errorIdx uint32, err error) {
+	req := &v4l2_ext_controls{
+		which:     V4L2_CTRL_WHICH_CUR_VAL,
+		count:     uint32(len(controls)),
+		error_idx: uint32(len(controls)),
+		controls:  unsafe.Pointer(&controls[0]),
+	}
+	err = dev.ioctl(op, unsafe.Pointer(req))
+	return req.error_idx, err
+}
+
+// queryMenu enumerates items of a menu control. Drivers may skip
+// some of the indexes between min and max.
+func queryMenu(dev device, id uint32, min int32, max int32, integer bool) []MenuItem {
+	var items []MenuItem
+	if min < 0 {
+		min = 0
+	}
+	for index := int64(min); index <= int64(max); index++ {
+		query := &v4l2_querymenu{}
+		query.id = id
+		query.index = uint32(index)
+		err := dev.ioctl(VIDIOC_QUERYMENU, unsafe.Pointer(query))
+		if err != nil {
+			continue
+		}
+
+		item := MenuItem{Index: uint32(index), Value: int64(index)}
+		if integer {
+			item.Value = int64(NativeByteOrder.Uint64(query.name[:8]))
+			item.Name = strconv.FormatInt(item.Value, 10)
+		} else {
+			item.Name = CToGoString(query.name[:])
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
 func getNativeByteOrder() binary.ByteOrder {
 	var i int32 = 0x01020304
 	u := unsafe.Pointer(&i)
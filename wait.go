@@ -0,0 +1,289 @@
+package webcam
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// errCanceled is returned by wait when the cancel descriptor is signalled
+var errCanceled = errors.New("Wait canceled")
+
+// WaitForFrameContext waits until a frame can be read, the context is
+// done or the webcam is closed. Unlike WaitForFrame, the wait can be
+// shorter than a second, following the deadline of the context.
+func (w *Webcam) WaitForFrameContext(ctx context.Context) error {
+	return waitContext(ctx, w.wait)
+}
+
+// WaitForAny waits until a frame can be read from any of the webcams and
+// returns the ones which are ready, in the order they were given. The
+// devices are waited for with a single poll, so that one goroutine can
+// serve many of them. The timeout is in seconds. If some of the webcams
+// are closed, they are returned with ErrClosed.
+func WaitForAny(timeout uint32, cams ...*Webcam) ([]*Webcam, error) {
+	return waitAny(cams, timeoutMs(timeout), -1)
+}
+
+// WaitForAnyContext is like WaitForAny, but waits until the context is done.
+func WaitForAnyContext(ctx context.Context, cams ...*Webcam) ([]*Webcam, error) {
+	var ready []*Webcam
+	err := waitContext(ctx, func(timeout int, cancel int) (err error) {
+		ready, err = waitAny(cams, timeout, cancel)
+		return
+	})
+	return ready, err
+}
+
+// waitContext calls wait with the timeout in milliseconds following
+// the deadline of the context, and a descriptor which becomes readable
+// when the context is done.
+func waitContext(ctx context.Context, wait func(timeout int, cancel int) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	timeout := -1
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		// Round up, so that the deadline has passed on timeout
+		ms := (time.Until(deadline) + time.Millisecond - 1) / time.Millisecond
+		if ms < 0 {
+			ms = 0
+		}
+		if ms < math.MaxInt32 {
+			timeout = int(ms)
+		}
+	}
+
+	// Background and similar contexts are never done
+	if ctx.Done() == nil {
+		return wait(timeout, -1)
+	}
+
+	cancel, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			var one = [8]byte{1}
+			unix.Write(cancel, one[:])
+		case <-stop:
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+		unix.Close(cancel)
+	}()
+
+	err = wait(timeout, cancel)
+
+	var timeoutErr *Timeout
+	if errors.Is(err, errCanceled) || (hasDeadline && errors.As(err, &timeoutErr)) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// ReadFrameContext is like ReadFrame, but waits for the frame until the
+// context is done or the webcam is closed.
+func (w *Webcam) ReadFrameContext(ctx context.Context) ([]byte, error) {
+	for {
+		if err := w.WaitForFrameContext(ctx); err != nil {
+			return nil, err
+		}
+
+		frame, err := w.ReadFrame()
+
+		// Another reader may have taken the frame
+		if errors.Is(err, unix.EAGAIN) {
+			continue
+		}
+		return frame, err
+	}
+}
+
+// wait polls the device for a frame with timeout in milliseconds,
+// or -1 to wait forever. The wait is interrupted by Close and, if cancel
+// is a valid descriptor, when it becomes readable.
+func (w *Webcam) wait(timeout int, cancel int) error {
+	return w.waitFor(unix.POLLIN, timeout, cancel)
+}
+
+// waitFor polls the device until one of the events occurs, see wait.
+// Pending control events are dispatched meanwhile, and waiting only for
+// them with POLLPRI returns once they are.
+func (w *Webcam) waitFor(events int16, timeout int, cancel int) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+	w.waiters.Add(1)
+	fds := make([]unix.PollFd, len(w.pollFds), len(w.pollFds)+1)
+	copy(fds, w.pollFds)
+	w.mu.Unlock()
+	defer w.waiters.Done()
+
+	// The device is polled first
+	fds[0].Events = events | unix.POLLPRI
+	if cancel >= 0 {
+		fds = append(fds, unix.PollFd{Fd: int32(cancel), Events: unix.POLLIN})
+	}
+
+	deadline := pollDeadline(timeout)
+	for {
+		count, err := waitForFrame(w.dev, fds, timeout)
+
+		if count < 0 || err != nil {
+			return err
+		}
+		for _, fd := range fds {
+			if fd.Revents == 0 {
+				continue
+			}
+			switch int(fd.Fd) {
+			case w.closeFd:
+				return ErrClosed
+			case cancel:
+				return errCanceled
+			}
+		}
+		if count == 0 {
+			return new(Timeout)
+		}
+
+		revents := fds[0].Revents
+		if revents&unix.POLLPRI != 0 {
+			err := w.dispatchEvents()
+			if events == unix.POLLPRI {
+				return err
+			}
+		}
+		if revents&(unix.POLLERR|unix.POLLHUP) != 0 && events == unix.POLLPRI {
+			return ErrDisconnected
+		}
+		if revents&^unix.POLLPRI != 0 {
+			return nil
+		}
+		timeout = pollTimeout(deadline, timeout)
+	}
+}
+
+// waitAny polls the devices of several webcams at once, see wait
+func waitAny(cams []*Webcam, timeout int, cancel int) ([]*Webcam, error) {
+	if len(cams) == 0 {
+		return nil, &stateError{"no webcams to wait for", unix.EINVAL}
+	}
+
+	// Each webcam has its own part of the set, the device and
+	// the descriptor Close signals
+	var fds []unix.PollFd
+	var closed []*Webcam
+	parts := make([]int, 0, len(cams)+1)
+	for _, w := range cams {
+		w.mu.Lock()
+		if w.closed {
+			closed = append(closed, w)
+		} else {
+			w.waiters.Add(1)
+			defer w.waiters.Done()
+		}
+		parts = append(parts, len(fds))
+		fds = append(fds, w.pollFds...)
+		w.mu.Unlock()
+	}
+	parts = append(parts, len(fds))
+
+	if len(closed) > 0 {
+		return closed, ErrClosed
+	}
+
+	if cancel >= 0 {
+		fds = append(fds, unix.PollFd{Fd: int32(cancel), Events: unix.POLLIN})
+	}
+
+	deadline := pollDeadline(timeout)
+	for {
+		count, err := unix.Poll(fds, timeout)
+		if err == unix.EINTR {
+			timeout = pollTimeout(deadline, timeout)
+			continue
+		}
+		if err != nil {
+			return nil, syscallError("poll", err)
+		}
+
+		var ready []*Webcam
+		for i, w := range cams {
+			part := fds[parts[i]:parts[i+1]]
+			if part[1].Revents != 0 {
+				closed = append(closed, w)
+				continue
+			}
+
+			events := w.dev.polled(part[0])
+			if events&unix.POLLPRI != 0 {
+				w.dispatchEvents()
+			}
+			if events&^unix.POLLPRI != 0 {
+				ready = append(ready, w)
+			}
+		}
+
+		switch {
+		case len(closed) > 0:
+			return closed, ErrClosed
+		case len(ready) > 0:
+			return ready, nil
+		case cancel >= 0 && fds[len(fds)-1].Revents != 0:
+			return nil, errCanceled
+		case count == 0:
+			return nil, new(Timeout)
+		}
+		timeout = pollTimeout(deadline, timeout)
+	}
+}
+
+// pollDeadline returns when a poll with timeout
+// in milliseconds ends, zero if it doesn't
+func pollDeadline(timeout int) time.Time {
+	if timeout < 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(timeout) * time.Millisecond)
+}
+
+// pollTimeout returns the timeout left until the deadline
+// for polling again, or -1 if the first poll had none
+func pollTimeout(deadline time.Time, timeout int) int {
+	if timeout < 0 {
+		return -1
+	}
+	ms := (time.Until(deadline) + time.Millisecond - 1) / time.Millisecond
+	if ms < 0 {
+		ms = 0
+	}
+	return int(ms)
+}
+
+// timeoutMs converts timeout in seconds to milliseconds for poll
+func timeoutMs(timeout uint32) int {
+	ms := int64(timeout) * 1000
+	if ms > math.MaxInt32 {
+		return -1
+	}
+	return int(ms)
+}
@@ -0,0 +1,135 @@
+package webcam
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitForAny(t *testing.T) {
+	d1, d2 := newTestDriver(), newTestDriver()
+	w1, w2 := openTestDriver(t, d1), openTestDriver(t, d2)
+	startTestStreaming(t, w1, 2)
+	startTestStreaming(t, w2, 2)
+
+	var timeout *Timeout
+	if _, err := WaitForAnyContext(timeoutContext(t, 10*time.Millisecond), w1, w2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitForAnyContext without frames = %v, want DeadlineExceeded", err)
+	}
+
+	d2.PushFrame([]byte("second"))
+	ready, err := WaitForAny(1, w1, w2)
+	if err != nil || len(ready) != 1 || ready[0] != w2 {
+		t.Fatalf("WaitForAny = %v, %v, want the second webcam", ready, err)
+	}
+
+	d1.PushFrame([]byte("first"))
+	ready, err = WaitForAny(1, w1, w2)
+	if err != nil || len(ready) != 2 {
+		t.Fatalf("WaitForAny = %v, %v, want both webcams", ready, err)
+	}
+
+	for _, w := range ready {
+		if _, err := w.ReadFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := WaitForAny(0, w1, w2); !errors.As(err, &timeout) {
+		t.Fatalf("WaitForAny after reading = %v, want timeout", err)
+	}
+}
+
+// A single poll waits for a frame which arrives later
+func TestWaitForAnyBlocks(t *testing.T) {
+	d1, d2 := newTestDriver(), newReadDriver()
+	w1, w2 := openTestDriver(t, d1), openTestDriver(t, d2)
+	startTestStreaming(t, w1, 2)
+	startTestStreaming(t, w2, 2)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		d2.PushFrame([]byte("late"))
+	}()
+
+	// Capture with read() starts with the poll
+	ready, err := WaitForAny(5, w1, w2)
+	if err != nil || len(ready) != 1 || ready[0] != w2 {
+		t.Fatalf("WaitForAny = %v, %v, want the second webcam", ready, err)
+	}
+	if frame, err := w2.ReadFrame(); err != nil || string(frame) != "late" {
+		t.Fatalf("ReadFrame = %q, %v", frame, err)
+	}
+}
+
+func TestWaitForAnyClose(t *testing.T) {
+	d1, d2 := newTestDriver(), newTestDriver()
+	w1, w2 := openTestDriver(t, d1), openTestDriver(t, d2)
+	startTestStreaming(t, w1, 2)
+	startTestStreaming(t, w2, 2)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		w1.Close()
+	}()
+
+	ready, err := WaitForAny(5, w1, w2)
+	if !errors.Is(err, ErrClosed) || len(ready) != 1 || ready[0] != w1 {
+		t.Fatalf("WaitForAny = %v, %v, want the first webcam closed", ready, err)
+	}
+}
+
+func TestWaitForFrameContext(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := w.WaitForFrameContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitForFrameContext with canceled context = %v", err)
+	}
+
+	// The wait follows the deadline rather than whole seconds
+	start := time.Now()
+	if err := w.WaitForFrameContext(timeoutContext(t, 20*time.Millisecond)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForFrameContext = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("waited %v for a deadline of 20ms", elapsed)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := w.WaitForFrameContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitForFrameContext canceled meanwhile = %v", err)
+	}
+
+	time.AfterFunc(20*time.Millisecond, func() { d.PushFrame([]byte("late")) })
+	frame, err := w.ReadFrameContext(timeoutContext(t, 5*time.Second))
+	if err != nil || string(frame) != "late" {
+		t.Errorf("ReadFrameContext = %q, %v", frame, err)
+	}
+}
+
+func TestCloseInterruptsWait(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 2)
+
+	time.AfterFunc(20*time.Millisecond, func() { w.Close() })
+	if err := w.WaitForFrameContext(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("WaitForFrameContext interrupted by Close = %v, want ErrClosed", err)
+	}
+	if err := w.WaitForFrame(1); !errors.Is(err, ErrClosed) {
+		t.Errorf("WaitForFrame after Close = %v, want ErrClosed", err)
+	}
+}
+
+// timeoutContext returns a context which is canceled
+// after d or when the test ends
+func timeoutContext(t *testing.T, d time.Duration) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	t.Cleanup(cancel)
+	return ctx
+}
@@ -4,40 +4,87 @@
 package webcam
 
 import (
-	"errors"
 	"fmt"
-	"reflect"
+	"sync"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
 
-// Webcam object
+// Webcam object. It is safe for concurrent use, e.g. one goroutine
+// can wait for and read frames while another changes controls or
+// stops streaming.
 type Webcam struct {
-	fd        uintptr
+	mu        sync.Mutex
+	dev       device
+	bufType   uint32
 	bufcount  uint32
 	buffers   [][]byte
 	streaming bool
 	pollFds   []unix.PollFd
+	closeFd   int
+	events    chan ControlEvent
+	stats     captureStats
+	ioMethod  IOMethod
+	allocator BufferAllocator
+
+	exportDMABUF bool
+	imports      []int
+	dmabufs      []int
+
+	planes    [][][]byte
+	numPlanes uint32
+
+	// References to buffers dequeued by the caller, nil for queued
+	// buffers. Buffers still held when streaming stops are kept mapped
+	// as orphans.
+	held        []*frameRef
+	orphans     []orphan
+	queueMemory uint32
+	frameDebug  func(error)
+
+	// Waiters are woken with closeFd when the webcam is closed,
+	// which waits for them to leave poll before closing the device
+	waiters sync.WaitGroup
+	closed  bool
+
+	readFree     []uint32
+	readSize     uint32
+	readSequence uint32
 }
 
 type ControlID uint32
 
+// Control describes a control as reported by the driver.
+// Type is one of ControlType* constants.
 type Control struct {
 	Name string
 	Min  int32
 	Max  int32
 	Type int32
 	Step int32
+
+	// Value of the control when the driver is loaded
+	Default int32
+
+	// Decoded V4L2_CTRL_FLAG_* flags
+	Flags ControlFlags
+
+	// V4L2_CTRL_CLASS_* class the control belongs to
+	Class uint32
+
+	// Items of menu and integer menu controls
+	Menu []MenuItem
 }
 
 // Open a webcam with a given path
 // Checks if device is a v4l2 device and if it is
-// capable to stream video
+// capable to stream video. Devices which can't stream,
+// but support read() are opened with IOMethodRead.
 func Open(path string) (*Webcam, error) {
 	handle, err := unix.Open(path, unix.O_RDWR|unix.O_NONBLOCK, 0666)
 	if err != nil {
-		return nil, err
+		return nil, syscallError("open", err)
 	}
 	if handle < 0 {
 		return nil, fmt.Errorf("failed to open %v", path)
@@ -50,27 +97,59 @@ func Open(path string) (*Webcam, error) {
 			unix.Close(handle)
 		}
 	}()
-	fd := uintptr(handle)
 
-	supportsVideoCapture, supportsVideoStreaming, err := checkCapabilities(fd)
+	w, err := openDevice(&fileDevice{handle: uintptr(handle)})
+	if err != nil {
+		return nil, err
+	}
+	success = true
+	return w, nil
+}
+
+// openDevice checks that dev is capable to stream video and
+// wraps it into a Webcam
+func openDevice(dev device) (*Webcam, error) {
+	caps, err := checkCapabilities(dev)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if !supportsVideoCapture {
-		return nil, errors.New("Not a video capture device")
+	w := new(Webcam)
+	w.dev = dev
+
+	switch {
+	case caps&V4L2_CAP_VIDEO_CAPTURE != 0:
+		w.bufType = V4L2_BUF_TYPE_VIDEO_CAPTURE
+	case caps&V4L2_CAP_VIDEO_CAPTURE_MPLANE != 0:
+		w.bufType = V4L2_BUF_TYPE_VIDEO_CAPTURE_MPLANE
+	default:
+		return nil, &stateError{"Not a video capture device", ErrUnsupported}
 	}
 
-	if !supportsVideoStreaming {
-		return nil, errors.New("Device does not support the streaming I/O method")
+	supportsVideoStreaming := caps&V4L2_CAP_STREAMING != 0
+	supportsReadWrite := caps&V4L2_CAP_READWRITE != 0 && w.bufType == V4L2_BUF_TYPE_VIDEO_CAPTURE
+
+	if !supportsVideoStreaming && !supportsReadWrite {
+		return nil, &stateError{"Device supports neither the streaming nor the read I/O method", ErrUnsupported}
 	}
 
-	w := new(Webcam)
-	w.fd = fd
+	// Streaming is preferred, read() copies every frame
+	if !supportsVideoStreaming {
+		w.ioMethod = IOMethodRead
+	}
 	w.bufcount = 256
-	w.pollFds = []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
-	success = true
+	w.allocator = PageAlignedAllocator{}
+
+	w.closeFd, err = unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	w.pollFds = []unix.PollFd{
+		{Fd: int32(dev.fd()), Events: unix.POLLIN | unix.POLLPRI},
+		{Fd: int32(w.closeFd), Events: unix.POLLIN},
+	}
+	w.events = make(chan ControlEvent, controlEventQueue)
 	return w, nil
 }
 
@@ -81,6 +160,12 @@ func Open(path string) (*Webcam, error) {
 // See http://linuxtv.org/downloads/v4l-dvb-apis/vidioc-enum-framesizes.html
 // for more information
 func (w *Webcam) GetSupportedFormats() map[PixelFormat]string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return make(map[PixelFormat]string)
+	}
 
 	result := make(map[PixelFormat]string)
 	var err error
@@ -89,7 +174,7 @@ func (w *Webcam) GetSupportedFormats() map[PixelFormat]string {
 	var index uint32
 
 	for index = 0; err == nil; index++ {
-		code, desc, err = getPixelFormat(w.fd, index)
+		code, desc, err = getPixelFormat(w.dev, w.bufType, index)
 
 		if err != nil {
 			break
@@ -103,33 +188,68 @@ func (w *Webcam) GetSupportedFormats() map[PixelFormat]string {
 
 // GetName returns the human-readable name of the device
 func (w *Webcam) GetName() (string, error) {
-	return getName(w.fd)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return "", ErrClosed
+	}
+
+	return getName(w.dev)
 }
 
 // GetBusInfo returns the location of the device in the system
 func (w *Webcam) GetBusInfo() (string, error) {
-	return getBusInfo(w.fd)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return "", ErrClosed
+	}
+
+	return getBusInfo(w.dev)
 }
 
 // SelectInput selects the current video input.
 func (w *Webcam) SelectInput(index uint32) error {
-	return selectInput(w.fd, index)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	return selectInput(w.dev, index)
 }
 
 // GetInput queries the current video input.
 func (w *Webcam) GetInput() (int32, error) {
-	return getInput(w.fd)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	return getInput(w.dev)
 }
 
 // Returns supported frame sizes for a given image format
 func (w *Webcam) GetSupportedFrameSizes(f PixelFormat) []FrameSize {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return make([]FrameSize, 0)
+	}
+
 	result := make([]FrameSize, 0)
 
 	var index uint32
 	var err error
 
 	for index = 0; err == nil; index++ {
-		s, err := getFrameSize(w.fd, index, uint32(f))
+		s, err := getFrameSize(w.dev, index, uint32(f))
 
 		if err != nil {
 			break
@@ -143,6 +263,13 @@ func (w *Webcam) GetSupportedFrameSizes(f PixelFormat) []FrameSize {
 
 // GetSupportedFramerates returns supported frame rates for a given image format and frame size.
 func (w *Webcam) GetSupportedFramerates(fp PixelFormat, width uint32, height uint32) []FrameRate {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+
 	var result []FrameRate
 	var index uint32
 	var err error
@@ -150,7 +277,7 @@ func (w *Webcam) GetSupportedFramerates(fp PixelFormat, width uint32, height uin
 	// keep incrementing the index value until we get an EINVAL error
 	index = 0
 	for err == nil {
-		r, err := getFrameInterval(w.fd, index, uint32(fp), width, height)
+		r, err := getFrameInterval(w.dev, index, uint32(fp), width, height)
 		if err != nil {
 			break
 		}
@@ -166,12 +293,23 @@ func (w *Webcam) GetSupportedFramerates(fp PixelFormat, width uint32, height uin
 // Resulting values are returned by a function
 // alongside with an error if any
 func (w *Webcam) SetImageFormat(f PixelFormat, width, height uint32) (PixelFormat, uint32, uint32, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, 0, 0, ErrClosed
+	}
 
 	code := uint32(f)
 	cw := width
 	ch := height
 
-	err := setImageFormat(w.fd, &code, &width, &height)
+	var err error
+	if w.IsMultiPlanar() {
+		err = mplaneSetImageFormat(w.dev, &code, &width, &height)
+	} else {
+		err = setImageFormat(w.dev, &code, &width, &height)
+	}
 
 	if err != nil {
 		return 0, 0, 0, err
@@ -183,8 +321,11 @@ func (w *Webcam) SetImageFormat(f PixelFormat, width, height uint32) (PixelForma
 // Set the number of frames to be buffered.
 // Not allowed if streaming is already on.
 func (w *Webcam) SetBufferCount(count uint32) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	if w.streaming {
-		return errors.New("Cannot set buffer count when streaming")
+		return &stateError{"Cannot set buffer count when streaming", ErrAlreadyStreaming}
 	}
 	w.bufcount = count
 	return nil
@@ -192,78 +333,146 @@ func (w *Webcam) SetBufferCount(count uint32) error {
 
 // Get a map of available controls.
 func (w *Webcam) GetControls() map[ControlID]Control {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return make(map[ControlID]Control)
+	}
+
+	return w.controls()
+}
+
+// controls queries all controls, it must be called with the lock held
+func (w *Webcam) controls() map[ControlID]Control {
 	cmap := make(map[ControlID]Control)
-	for _, c := range queryControls(w.fd) {
-		cmap[ControlID(c.id)] = Control{
-			Name: c.name,
-			Min:  c.min,
-			Max:  c.max,
-			Type: int32(c.c_type),
-			Step: c.step,
+	for _, c := range queryControls(w.dev) {
+		control := Control{
+			Name:    c.name,
+			Min:     c.min,
+			Max:     c.max,
+			Type:    int32(c.c_type),
+			Step:    c.step,
+			Default: c.def,
+			Flags:   ControlFlags(c.flags),
+			Class:   controlClass(c.id),
 		}
+		if c.c_type == c_menu || c.c_type == c_int_menu {
+			control.Menu = queryMenu(w.dev, c.id, c.min, c.max, c.c_type == c_int_menu)
+		}
+		cmap[ControlID(c.id)] = control
 	}
 	return cmap
 }
 
 // Get the value of a control.
 func (w *Webcam) GetControl(id ControlID) (int32, error) {
-	return getControl(w.fd, uint32(id))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	return getControl(w.dev, uint32(id))
 }
 
 // Set a control.
 func (w *Webcam) SetControl(id ControlID, value int32) error {
-	return setControl(w.fd, uint32(id), value)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	return setControl(w.dev, uint32(id), value)
 }
 
 // Get the framerate.
 func (w *Webcam) GetFramerate() (float32, error) {
-	return getFramerate(w.fd)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	return getFramerate(w.dev, w.bufType)
 }
 
 // Set FPS
 func (w *Webcam) SetFramerate(fps float32) error {
-	return setFramerate(w.fd, 1000, uint32(1000*(fps)))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	return setFramerate(w.dev, w.bufType, 1000, uint32(1000*(fps)))
 }
 
 // Start streaming process
 func (w *Webcam) StartStreaming() error {
-	if w.streaming {
-		return errors.New("Already streaming")
-	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	err := mmapRequestBuffers(w.fd, &w.bufcount)
+	if w.closed {
+		return ErrClosed
+	}
 
-	if err != nil {
-		return errors.New("Failed to map request buffers: " + string(err.Error()))
+	if w.streaming {
+		return ErrAlreadyStreaming
 	}
 
-	w.buffers = make([][]byte, w.bufcount, w.bufcount)
-	for index, _ := range w.buffers {
-		var length uint32
+	var err error
 
-		buffer, err := mmapQueryBuffer(w.fd, uint32(index), &length)
+	switch {
+	case w.IsMultiPlanar():
+		err = w.mapPlanes()
+	case w.ioMethod == IOMethodUserPtr:
+		err = w.allocUserBuffers()
+	case w.ioMethod == IOMethodDMABUF:
+		err = w.importDMABUFs()
+	case w.ioMethod == IOMethodRead:
+		err = w.allocReadBuffers()
+	default:
+		err = w.mapBuffers()
+	}
 
-		if err != nil {
-			return errors.New("Failed to map memory: " + string(err.Error()))
-		}
+	if err != nil {
+		w.releaseBuffers()
+		return err
+	}
 
-		w.buffers[index] = buffer
+	// Imported DMABUFs override the buffer count
+	count := w.bufcount
+	if w.ioMethod == IOMethodDMABUF {
+		count = uint32(len(w.buffers))
 	}
+	w.stats.reset(count)
+	w.held = make([]*frameRef, count)
 
 	for index, _ := range w.buffers {
 
-		err := mmapEnqueueBuffer(w.fd, uint32(index))
+		err := w.enqueue(uint32(index))
 
 		if err != nil {
-			return errors.New("Failed to enqueue buffer: " + string(err.Error()))
+			w.releaseBuffers()
+			return fmt.Errorf("Failed to enqueue buffer: %w", err)
 		}
 
 	}
 
-	err = startStreaming(w.fd)
+	// Capture with read() starts with the first read
+	if w.ioMethod != IOMethodRead {
+		err = startStreaming(w.dev, w.bufType)
+	}
 
 	if err != nil {
-		return errors.New("Failed to start streaming: " + string(err.Error()))
+		w.releaseBuffers()
+		return fmt.Errorf("Failed to start streaming: %w", err)
 	}
 	w.streaming = true
 
@@ -286,77 +495,241 @@ func (w *Webcam) ReadFrame() ([]byte, error) {
 // If frame cannot be read at the moment
 // function will return empty slice
 func (w *Webcam) GetFrame() ([]byte, uint32, error) {
-	var index uint32
-	var length uint32
-
-	err := mmapDequeueBuffer(w.fd, &index, &length)
+	// The caller gets no frame which could be checked for leaks
+	frame, err := w.getFrame(false)
 
 	if err != nil {
 		return nil, 0, err
 	}
 
-	return w.buffers[int(index)][:length], index, nil
+	return frame.Data, frame.Index, nil
 
 }
 
-// Release the frame buffer that was obtained via GetFrame
-func (w *Webcam) ReleaseFrame(index uint32) error {
-	return mmapEnqueueBuffer(w.fd, index)
+// Get a single frame from the webcam alongside with the metadata
+// reported by the driver. To return the buffer, either Release must be
+// called on the frame or ReleaseFrame with frame's Index. GetFrame and
+// ReadFrame return only the first plane of multi-planar frames.
+func (w *Webcam) GetFrameWithMetadata() (*Frame, error) {
+	return w.getFrame(true)
 }
 
-// Wait until frame could be read
-func (w *Webcam) WaitForFrame(timeout uint32) error {
+// getFrame dequeues a frame and holds its buffer for the caller.
+// In frame debug mode, frames returned to the caller are checked for
+// leaks, which frames of the index API can't be.
+func (w *Webcam) getFrame(returned bool) (*Frame, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	count, err := waitForFrame(w.pollFds, timeout)
+	if w.closed {
+		return nil, ErrClosed
+	}
 
-	if count < 0 || err != nil {
-		return err
-	} else if count == 0 {
-		return new(Timeout)
-	} else {
-		return nil
+	if !w.streaming {
+		return nil, ErrNotStreaming
+	}
+
+	frame, err := w.dequeue()
+
+	if err != nil {
+		return nil, err
+	}
+
+	w.hold(frame, returned)
+	w.stats.dequeued(frame)
+
+	return frame, nil
+}
+
+func (w *Webcam) dequeue() (*Frame, error) {
+	if w.ioMethod == IOMethodRead {
+		return w.readFrame()
+	}
+
+	if w.IsMultiPlanar() {
+		return w.dequeuePlanes()
+	}
+
+	buffer := &v4l2_buffer{}
+
+	err := dequeueBuffer(w.dev, w.ioMethod.memory(), buffer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Imported DMABUF may be impossible to map
+	data := w.buffers[int(buffer.index)]
+	if data != nil {
+		data = data[:buffer.bytesused]
+	}
+
+	frame := newFrame(data, buffer)
+	if int(buffer.index) < len(w.dmabufs) {
+		frame.DMABUF = w.dmabufs[buffer.index]
+	}
+
+	return frame, nil
+}
+
+// Release the frame buffer that was obtained via GetFrame.
+// Buffers held when streaming was stopped are unmapped only when
+// released, which is allowed after Close too. Such a buffer is released
+// if the buffer with the same index is not held since streaming restarted,
+// Frame.Release tells the buffers apart reliably.
+func (w *Webcam) ReleaseFrame(index uint32) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.streaming || int(index) >= len(w.held) || w.held[index] == nil {
+		// The most recent orphan is the one most likely released
+		// late by a reader racing with StopStreaming
+		ok, err := w.releaseOrphan(func(o *orphan) bool {
+			return o.index == index
+		})
+		if ok {
+			return err
+		}
 	}
+
+	if w.closed {
+		return ErrClosed
+	}
+	if !w.streaming {
+		return ErrNotStreaming
+	}
+	return w.enqueue(index)
+}
+
+func (w *Webcam) enqueue(index uint32) error {
+	// The memory of the buffer is passed to the driver
+	// along with the index, which it can't check before
+	if (w.ioMethod == IOMethodUserPtr || w.ioMethod == IOMethodDMABUF) && int(index) >= len(w.buffers) {
+		return &stateError{fmt.Sprintf("invalid buffer index %d", index), unix.EINVAL}
+	}
+
+	var err error
+	switch w.ioMethod {
+	case IOMethodUserPtr:
+		err = userptrEnqueueBuffer(w.dev, index, w.buffers[index])
+	case IOMethodDMABUF:
+		err = dmabufEnqueueBuffer(w.dev, index, w.dmabufs[index], 0)
+	case IOMethodRead:
+		// Unlike the driver, the free list doesn't reject
+		// buffers which are not held
+		if int(index) >= len(w.held) || w.held[index] == nil {
+			return &stateError{fmt.Sprintf("buffer %d is not held", index), unix.EINVAL}
+		}
+		w.readFree = append(w.readFree, index)
+	default:
+		if w.IsMultiPlanar() {
+			err = mplaneEnqueueBuffer(w.dev, index, w.numPlanes)
+		} else {
+			err = mmapEnqueueBuffer(w.dev, index)
+		}
+	}
+	if err == nil {
+		if int(index) < len(w.held) && w.held[index] != nil {
+			w.held[index].drop()
+			w.held[index] = nil
+		}
+		w.stats.enqueued(index)
+	}
+	return err
+}
+
+// Stats returns capture statistics collected since streaming was started:
+// dropped and corrupted frames, measured frame rate and jitter, and how
+// long buffers stay queued in the driver.
+func (w *Webcam) Stats() CaptureStats {
+	return w.stats.snapshot()
+}
+
+// Wait until frame could be read. The timeout is in seconds,
+// see WaitForFrameContext for shorter waits.
+func (w *Webcam) WaitForFrame(timeout uint32) error {
+	return w.wait(timeoutMs(timeout), -1)
 }
 
+// Stop streaming. Buffers are unmapped and freed, except the ones
+// held by the caller, which stay valid until they are released.
 func (w *Webcam) StopStreaming() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.stopStreaming()
+}
+
+func (w *Webcam) stopStreaming() error {
 	if !w.streaming {
-		return errors.New("Request to stop streaming when not streaming")
+		return &stateError{"Request to stop streaming when not streaming", ErrNotStreaming}
 	}
 	w.streaming = false
-	for _, buffer := range w.buffers {
-		err := mmapReleaseBuffer(buffer)
-		if err != nil {
-			return err
-		}
+
+	// The driver may still be writing into the buffers until streaming
+	// is off. Buffers are released even if it fails, e.g. when the
+	// device was unplugged.
+	var err error
+	if w.ioMethod != IOMethodRead {
+		err = stopStreaming(w.dev, w.bufType)
 	}
 
-	return stopStreaming(w.fd)
+	if e := w.releaseBuffers(); e != nil && err == nil {
+		err = e
+	}
+	return err
 }
 
-// Close the device
+// Close the device. Goroutines waiting for a frame
+// are woken and get ErrClosed.
 func (w *Webcam) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	var one = [8]byte{1}
+	unix.Write(w.closeFd, one[:])
+	w.waiters.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	if w.streaming {
-		w.StopStreaming()
+		w.stopStreaming()
 	}
 
-	err := unix.Close(int(w.fd))
+	// Closing the device frees the buffers of the driver
+	w.queueMemory = 0
+	err := w.dev.close()
+	unix.Close(w.closeFd)
+
+	// Only waiters send events
+	close(w.events)
 
 	return err
 }
 
 // Sets automatic white balance correction
 func (w *Webcam) SetAutoWhiteBalance(val bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
 	v := int32(0)
 	if val {
 		v = 1
 	}
-	return setControl(w.fd, V4L2_CID_AUTO_WHITE_BALANCE, v)
+	return setControl(w.dev, V4L2_CID_AUTO_WHITE_BALANCE, v)
 }
 
 func gobytes(p unsafe.Pointer, n int) []byte {
 
-	h := reflect.SliceHeader{uintptr(p), n, n}
-	s := *(*[]byte)(unsafe.Pointer(&h))
-
-	return s
+	return unsafe.Slice((*byte)(p), n)
 }
@@ -0,0 +1,88 @@
+package webcam
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Readers and control requests run concurrently with Close,
+// the race detector checks the locking
+func TestConcurrentClose(t *testing.T) {
+	d := newTestDriver()
+	w := openTestDriver(t, d)
+	startTestStreaming(t, w, 4)
+
+	stop := make(chan struct{})
+	pushed := make(chan struct{})
+	go func() {
+		defer close(pushed)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			d.PushFrame([]byte("frame"))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-pushed
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := w.ReadFrameContext(context.Background()); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for value := int32(0); ; value++ {
+			if err := w.SetControl(testBrightness, value%64); err != nil {
+				errs <- err
+				return
+			}
+			w.GetControl(testBrightness)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("goroutines are still running after Close")
+	}
+
+	close(errs)
+	for err := range errs {
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("request after Close = %v, want ErrClosed", err)
+		}
+	}
+	if d.Streaming() {
+		t.Error("driver is streaming after Close")
+	}
+}